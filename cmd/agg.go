@@ -2,25 +2,57 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hodctl/pkg/io"
 	"hodctl/pkg/pipeline"
 	"hodctl/pkg/sink"
+	"hodctl/pkg/worker"
 	stdio "io"
 	"log"
+	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
 	"time"
+	_ "time/tzdata" // Time zones of --timezone on systems without zoneinfo
 
+	"github.com/gocarina/gocsv"
 	"github.com/spf13/cobra"
 )
 
 type AggArgs struct {
-	InputCurrencyValue string // Path to the currency value CSV file
-	InputTransactions  string // Path to the transactions CSV file
-	Output             string // Path to the output
-	OutputErr          string // Path to the error output
-	Parallelism        int    // Number of goroutines for parallel processing
-	MicroBatchSize     int    // Size of each micro-batch for processing
+	InputCurrencyValue string        // Path to the currency value CSV file
+	InputTransactions  []string      // Paths, globs or prefixes of the transactions files
+	InputFormat        string        // Format of the transactions files: csv, ndjson, parquet or avro, detected from their extension when empty
+	Output             string        // Path to the output
+	OutputErr          string        // Path to the error output
+	Parallelism        int           // Number of goroutines for parallel processing
+	FileParallelism    int           // Number of transactions files read at the same time
+	MicroBatchSize     int           // Size of each micro-batch for processing
+	SymbolPolicy       string        // Policy to pick the coin of a symbol shared by several coins
+	SymbolOverrides    string        // Path to the JSON/YAML file mapping symbols to coin IDs
+	SymbolReport       string        // Path to save the report of the ambiguous symbols
+	Currencies         []string      // Reporting currencies of the total volumes
+	GroupBy            []string      // Dimensions the transactions are grouped by, date included
+	Metrics            []string      // Optional metrics of the aggregates
+	Filter             string        // CEL expression selecting the transactions to aggregate
+	UnknownCurrency    string        // What to do with the transactions in a currency without price: fail, dead-letter or skip
+	TimeBucket         string        // Period of the date the transactions are grouped by: hour, day, week or month
+	Timezone           string        // IANA time zone of the time buckets, e.g. Europe/Berlin
+	MaxPriceAge        time.Duration // Maximum age of the prices at the end of the data window, 0 to skip the check
+	StalePrices        string        // What to do with prices older than MaxPriceAge: warn or fail
+	Timeout            time.Duration // Maximum processing time, 0 for no limit
+	MemoryBudget       int           // Memory of the groups before they are spilled to disk, in MB, the default budget when 0
+	SpillDir           string        // Directory of the groups spilled to disk, the system temp directory when empty
+	Reducers           int           // Number of goroutines reducing a disjoint slice of the group keys each, 1 when 0
+	Checkpoint         string        // Location of the checkpoints (gs, s3, local file system), no checkpoint when empty
+	CheckpointEvery    int64         // Number of rows between two checkpoints
+	Resume             bool          // Resume an interrupted aggregation from its last checkpoint
 }
 
 var aggArgs AggArgs
@@ -31,13 +63,21 @@ const (
 	// a Batch size of 10K records is the Optimal size for processing small CSV files as the Benchmark tests have shown
 	DefaultMicroBatchSize = 10_000 // (10K records) offers a good balance between memory usage (~40Mb) and processing speed
 
-	// Hard limit for processing time to avoid hanging processes burning unecesary resources in case something goes terribly wrong.
-	// TODO adjust based on actual load (or parametrize) to a maximum reasonable time we never expect to exceed.
-	MaxProcessTime = 30 * time.Minute
+	// DefaultTimeout Default limit for processing time to avoid hanging processes burning unecesary resources in case something goes terribly wrong.
+	DefaultTimeout = 30 * time.Minute
 )
 
 var DefaultParallelism = runtime.NumCPU()
 
+// DefaultFileParallelism Default number of transactions files read at the same time
+const DefaultFileParallelism = 4
+
+// Policies for the currency values older than --max-price-age
+const (
+	stalePricesWarn = "warn"
+	stalePricesFail = "fail"
+)
+
 var aggCmd = &cobra.Command{
 	Use:   "agg",
 	Short: "Aggregate transactions",
@@ -46,12 +86,34 @@ var aggCmd = &cobra.Command{
 }
 
 func init() {
-	aggCmd.Flags().StringVarP(&aggArgs.InputCurrencyValue, "input-currencies", "c", "", "Path to the currency value CSV file (gs, s3, local file system) (required)")
-	aggCmd.Flags().StringVarP(&aggArgs.InputTransactions, "input-transactions", "t", "", "Path to the transactions CSV file (gs, s3, local file system) (required)")
+	aggCmd.Flags().StringVarP(&aggArgs.InputCurrencyValue, "input-currencies", "c", "", "Path to the currency value CSV or JSON file saved by fetch, or JSON/YAML file of pinned prices (gs, s3, local file system) (required)")
+	aggCmd.Flags().StringArrayVarP(&aggArgs.InputTransactions, "input-transactions", "t", nil, "Path to the transactions files, see --input-format (gs, s3, local file system): a file, a glob in the file name e.g. gs://bucket/events/2024-04-15/*.csv, or a directory or bucket prefix, repeatable (required)")
 	aggCmd.Flags().StringVarP(&aggArgs.Output, "output", "o", "", "Path to save the aggregated result (BigQuery, gs, s3, local file system) (required)")
 	aggCmd.Flags().StringVarP(&aggArgs.OutputErr, "output-error", "e", "", "Path to save the error output CSV file (gs, s3, local file system) (required)")
 	aggCmd.Flags().IntVarP(&aggArgs.Parallelism, "parallelism", "p", DefaultParallelism, "Number of goroutines for parallel processing")
+	aggCmd.Flags().StringVar(&aggArgs.InputFormat, "input-format", "", "Format of the transactions files: csv, ndjson (JSON lines or array), parquet or avro (default detected from the extension of each file, .jsonl/.ndjson/.json, .parquet or .avro, otherwise csv)")
+	aggCmd.Flags().IntVar(&aggArgs.FileParallelism, "file-parallelism", DefaultFileParallelism, "Number of transactions files read at the same time")
 	aggCmd.Flags().IntVarP(&aggArgs.MicroBatchSize, "micro-batch-size", "b", DefaultMicroBatchSize, "Size of each microbatch for processing")
+	aggCmd.Flags().StringSliceVar(&aggArgs.GroupBy, "group-by", worker.DefaultGroupBy, "Dimensions the transactions are grouped by: date (required) and any of "+strings.Join(worker.Dimensions(), ", "))
+	aggCmd.Flags().StringVar(&aggArgs.Filter, "filter", "", "CEL expression selecting the transactions to aggregate, over the raw CSV columns (strings) and timestamp, currency_symbol and volume, e.g. 'event == \"BUY_ITEMS\" && volume > 0'")
+	aggCmd.Flags().StringVar(&aggArgs.UnknownCurrency, "unknown-currency", string(worker.UnknownCurrencyFail), "What to do with the transactions in a currency without price: fail, dead-letter (sent to the error output) or skip")
+	aggCmd.Flags().StringSliceVar(&aggArgs.Metrics, "metrics", nil, "Optional metrics of each group: min, max, mean (volume), distinct_users, distinct_sessions (approximate), p50, p95, p99 (approximate volume quantiles)")
+	aggCmd.Flags().StringVar(&aggArgs.TimeBucket, "time-bucket", string(worker.BucketDay), "Period of the date the transactions are grouped by: hour, day, week (ISO week from Monday) or month")
+	aggCmd.Flags().StringVar(&aggArgs.Timezone, "timezone", "UTC", "Time zone of the time buckets, e.g. Europe/Berlin")
+	aggCmd.Flags().StringSliceVar(&aggArgs.Currencies, "reporting-currency", []string{io.DefaultCurrency}, "Reporting currencies of the total volumes, e.g. eur or usd,eur (one TotalVolume column each)")
+	aggCmd.Flags().StringVar(&aggArgs.SymbolPolicy, "symbol-policy", string(io.SymbolPolicyMarketCap), "Coin used when several coins share a symbol: market-cap (highest market cap) or fail")
+	aggCmd.Flags().StringVar(&aggArgs.SymbolOverrides, "symbol-overrides", "", "Path to a JSON/YAML file mapping symbols to the coin ID to use, e.g. {\"ETH\": \"ethereum\"}")
+	aggCmd.Flags().StringVar(&aggArgs.SymbolReport, "symbol-report", "", "Path to save the CSV report of the ambiguous symbols and the chosen coins")
+
+	aggCmd.Flags().DurationVar(&aggArgs.MaxPriceAge, "max-price-age", 0, "Maximum age of the currency values at the end of the data window, e.g. 24h (0 skips the check)")
+	aggCmd.Flags().StringVar(&aggArgs.StalePrices, "stale-prices", stalePricesWarn, "What to do when the currency values are older than --max-price-age: warn or fail")
+	aggCmd.Flags().IntVar(&aggArgs.MemoryBudget, "memory-budget", worker.DefaultMemoryBudget>>20, "Estimated memory of the groups in MB, beyond which they are spilled to disk as sorted runs and merged at the end")
+	aggCmd.Flags().IntVar(&aggArgs.Reducers, "reducers", 1, "Number of goroutines reducing the partial aggregates, each owning a disjoint slice of the group keys (the output is only sorted within each)")
+	aggCmd.Flags().StringVar(&aggArgs.SpillDir, "spill-dir", "", "Local directory of the groups spilled to disk (default the system temp directory)")
+	aggCmd.Flags().StringVar(&aggArgs.Checkpoint, "checkpoint", "", "Location to save checkpoints of the aggregation (gs, s3, local file system), e.g. gs://bucket/checkpoints/daily-agg")
+	aggCmd.Flags().Int64Var(&aggArgs.CheckpointEvery, "checkpoint-every", pipeline.DefaultCheckpointEvery, "Number of transaction rows between two checkpoints")
+	aggCmd.Flags().BoolVar(&aggArgs.Resume, "resume", false, "Resume an interrupted aggregation from its last checkpoint in --checkpoint")
+	aggCmd.Flags().DurationVar(&aggArgs.Timeout, "timeout", DefaultTimeout, "Maximum processing time before the aggregation is stopped, e.g. 2h (0 for no limit)")
 
 	aggCmd.MarkFlagRequired("input-currencies")
 	aggCmd.MarkFlagRequired("input-transactions")
@@ -62,55 +124,200 @@ func init() {
 }
 
 func runAggregationCmd(cmd *cobra.Command, args []string) {
-	log.Printf("Starting aggregation with parallelism=%d and microbatch size=%d\n", aggArgs.Parallelism, aggArgs.MicroBatchSize)
+	log.Printf("Starting aggregation with parallelism=%d, microbatch size=%d and %d reducers\n", aggArgs.Parallelism, aggArgs.MicroBatchSize, aggArgs.Reducers)
 	log.Printf("Input currency values: %s", aggArgs.InputCurrencyValue)
-	log.Printf("Input transactions: %s", aggArgs.InputTransactions)
+	log.Printf("Input transactions: %s", strings.Join(aggArgs.InputTransactions, ", "))
 	log.Printf("Output results: %s", aggArgs.Output)
 
-	if err := aggregateTransactions(
-		aggArgs.InputCurrencyValue,
-		aggArgs.InputTransactions,
-		aggArgs.Output,
-		aggArgs.OutputErr,
-		aggArgs.Parallelism,
-		aggArgs.MicroBatchSize); err != nil {
+	// SIGINT and SIGTERM stop the aggregation cleanly, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := aggregateTransactions(ctx, aggArgs); err != nil {
 		log.Fatalf("Error during aggregation: %v", err)
 	}
 }
 
-func aggregateTransactions(inputCurrencyValue, inputTransactions, aggOutput, errOutput string, parallelism, microBatchSize int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), MaxProcessTime)
-	defer cancel()
+func aggregateTransactions(ctx context.Context, args AggArgs) (err error) {
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
 
-	// Opening currency value file
-	currencyReader, err := io.Open(inputCurrencyValue)
+	spec, err := worker.NewAggSpec(args.Currencies)
 	if err != nil {
-		return fmt.Errorf("failed to open currency value file: %w", err)
+		return err
+	}
+	if len(args.GroupBy) > 0 {
+		if err := spec.SetGroupBy(args.GroupBy); err != nil {
+			return err
+		}
+	}
+	if args.Filter != "" {
+		if spec.Filter, err = worker.NewFilter(args.Filter); err != nil {
+			return err
+		}
+		log.Printf("Filtering the transactions with: %s\n", args.Filter)
+	}
+	if args.UnknownCurrency != "" {
+		if spec.UnknownCurrencies, err = worker.ParseUnknownCurrencyPolicy(args.UnknownCurrency); err != nil {
+			return err
+		}
+	}
+	if spec.Metrics, err = worker.ParseMetrics(args.Metrics); err != nil {
+		return err
+	}
+	if args.TimeBucket != "" {
+		if spec.Bucket, err = worker.ParseTimeBucket(args.TimeBucket); err != nil {
+			return err
+		}
+	}
+	if args.Timezone != "" {
+		if spec.Location, err = time.LoadLocation(args.Timezone); err != nil {
+			return fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+	spec.MaxPriceAge = args.MaxPriceAge
+	switch args.StalePrices {
+	case "", stalePricesWarn:
+	case stalePricesFail:
+		spec.FailOnStalePrices = true
+	default:
+		return fmt.Errorf("unsupported stale prices policy: %s (expected %s or %s)", args.StalePrices, stalePricesWarn, stalePricesFail)
 	}
-	defer safeClose(currencyReader, "currencyReader")
 
-	// Opening transactions file
-	transactionReader, err := io.Open(inputTransactions)
+	// Listing the transactions files
+	files, err := io.ExpandPaths(args.InputTransactions)
 	if err != nil {
-		return fmt.Errorf("failed to open transactions file: %w", err)
+		return fmt.Errorf("failed to list transactions files: %w", err)
+	}
+	log.Printf("Found %d transactions files\n", len(files))
+	format, err := io.ParseInputFormat(args.InputFormat)
+	if err != nil {
+		return err
+	}
+	transactions := io.TransactionFiles{Paths: files, Parallelism: args.FileParallelism, Format: format, Columns: spec.InputColumns()}
+
+	var checkpoints *pipeline.Checkpoints
+	if args.Checkpoint != "" {
+		fingerprint, err := aggFingerprint(args, files)
+		if err != nil {
+			return err
+		}
+		checkpoints = &pipeline.Checkpoints{Path: args.Checkpoint, Every: args.CheckpointEvery, Resume: args.Resume, Fingerprint: fingerprint}
+	} else if args.Resume {
+		return errors.New("--resume requires the --checkpoint location of the interrupted aggregation")
+	}
+
+	// Loading currency values
+	resolver, err := newSymbolResolver(args)
+	if err != nil {
+		return err
+	}
+	prices, err := io.LoadPriceTable(ctx, args.InputCurrencyValue, resolver)
+	if err != nil {
+		return fmt.Errorf("failed to read currency values: %w", err)
+	}
+	if err := reportSymbolResolutions(prices.Resolutions, args.SymbolReport); err != nil {
+		return err
 	}
-	defer safeClose(transactionReader, "transactionReader")
 
 	// Creating sinks
-	aggSink, err := sink.NewAggSink(ctx, aggOutput)
+	aggSink, err := sink.NewAggSink(ctx, args.Output, spec)
 	if err != nil {
 		return fmt.Errorf("failed to create sink: %w", err)
 	}
-	defer safeClose(aggSink, "aggSink")
+	// The aggregates are only kept when the aggregation completes
+	defer func() {
+		if err == nil {
+			err = aggSink.Close()
+			// The checkpoint is only removed once the output is complete
+			if err == nil && checkpoints != nil {
+				err = checkpoints.Clean()
+			}
+			return
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Aggregation stopped, discarding the output %s\n", args.Output)
+		}
+		if abortErr := aggSink.Abort(); abortErr != nil {
+			log.Printf("Error aborting aggSink: %v", abortErr)
+		}
+	}()
 
-	errSink, err := sink.NewOutlierSink(ctx, errOutput)
+	errSink, err := sink.NewOutlierSink(ctx, args.OutputErr)
 	if err != nil {
 		return fmt.Errorf("failed to create error sink: %w", err)
 	}
 	defer safeClose(errSink, "errSink")
 
 	// Start the aggregation process
-	return pipeline.DoAgg(currencyReader, transactionReader, aggSink, errSink, parallelism, microBatchSize)
+	reduce := worker.ReduceOptions{MemoryBudget: int64(args.MemoryBudget) << 20, SpillDir: args.SpillDir, Partitions: args.Reducers}
+	return pipeline.DoAgg(ctx, prices, transactions, aggSink, errSink, spec, args.Parallelism, args.MicroBatchSize, reduce, checkpoints)
+}
+
+// aggFingerprint identifies the aggregation of the arguments and of the input files: the transactions files they expand to,
+// the currency values and the symbol overrides, by size and modification time. An aggregation is only resumed from
+// a checkpoint with the same fingerprint, not once an input is replaced.
+func aggFingerprint(args AggArgs, files []string) (string, error) {
+	inputs := append([]string{args.InputCurrencyValue}, files...)
+	if args.SymbolOverrides != "" {
+		inputs = append(inputs, args.SymbolOverrides)
+	}
+	versions := make([]io.FileVersion, len(inputs))
+	for i, input := range inputs {
+		var err error
+		if versions[i], err = io.StatFile(input); err != nil {
+			return "", err
+		}
+	}
+
+	aggregation, _ := json.Marshal([]any{args.InputCurrencyValue, files, versions, args.InputFormat, args.SymbolPolicy, args.SymbolOverrides,
+		args.Currencies, args.GroupBy, args.Metrics, args.Filter, args.UnknownCurrency, args.TimeBucket, args.Timezone, max(args.Reducers, 1)})
+	hash := sha256.Sum256(aggregation)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// newSymbolResolver creates the resolver of the symbols shared by several coins
+func newSymbolResolver(args AggArgs) (*io.SymbolResolver, error) {
+	policy, err := io.ParseSymbolPolicy(args.SymbolPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := &io.SymbolResolver{Policy: policy}
+	if args.SymbolOverrides != "" {
+		resolver.Overrides, err = io.LoadSymbolOverrides(args.SymbolOverrides)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resolver, nil
+}
+
+// reportSymbolResolutions logs the coin chosen for each ambiguous symbol, and saves the report as CSV if a path is given
+func reportSymbolResolutions(resolutions []io.SymbolResolution, path string) error {
+	for _, resolution := range resolutions {
+		log.Printf("Symbol %s resolved to %s (%s), candidates: %s", resolution.Symbol, resolution.ChosenID, resolution.Reason, resolution.Candidates)
+	}
+	if path == "" {
+		return nil
+	}
+
+	writer, err := io.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open symbol report: %w", err)
+	}
+	if err := gocsv.Marshal(&resolutions, writer); err != nil {
+		safeClose(writer, "symbolReport")
+		return fmt.Errorf("failed to write symbol report: %w", err)
+	}
+	return writer.Close()
 }
 
 func safeClose(closer stdio.Closer, name string) {
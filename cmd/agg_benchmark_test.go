@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"hodctl/pkg/io"
 	"runtime"
 	"testing"
 	"time"
@@ -10,13 +12,19 @@ import (
 func benchmarkAgg(b *testing.B, parallelism int, microBatchSize int) {
 	b.ResetTimer()
 
-	inputCurrencyValue := "../testdata/currencies_usd.csv"
-	inputTransactions := "../testdata/big_sample_data.csv"
-	aggOutput := "../testdata/output.csv"
-	errOutput := "../testdata/errors.csv"
+	args := AggArgs{
+		InputCurrencyValue: "../testdata/currencies_usd.csv",
+		InputTransactions:  []string{"../testdata/big_sample_data.csv"},
+		Output:             "../testdata/output.csv",
+		OutputErr:          "../testdata/errors.csv",
+		Parallelism:        parallelism,
+		MicroBatchSize:     microBatchSize,
+		SymbolPolicy:       string(io.SymbolPolicyMarketCap),
+		Currencies:         []string{io.DefaultCurrency},
+	}
 
 	for i := 0; i < b.N; i++ {
-		err := aggregateTransactions(inputCurrencyValue, inputTransactions, aggOutput, errOutput, parallelism, microBatchSize)
+		err := aggregateTransactions(context.Background(), args)
 		if err != nil {
 			b.Fatalf("Error during benchmark: %v", err)
 		}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"hodctl/pkg/io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeAggInputs writes pinned prices and n SFL transactions, returning the args aggregating them into dir
+func writeAggInputs(t *testing.T, n int) AggArgs {
+	dir := t.TempDir()
+	args := AggArgs{
+		InputCurrencyValue: filepath.Join(dir, "prices.json"),
+		InputTransactions:  []string{filepath.Join(dir, "transactions.csv")},
+		Output:             filepath.Join(dir, "output.csv"),
+		OutputErr:          filepath.Join(dir, "errors.csv"),
+		Parallelism:        2,
+		MicroBatchSize:     10,
+		SymbolPolicy:       string(io.SymbolPolicyMarketCap),
+		Currencies:         []string{io.DefaultCurrency},
+	}
+	assert.NoError(t, os.WriteFile(args.InputCurrencyValue, []byte(`{"SFL": 0.5}`), 0o600))
+
+	var transactions strings.Builder
+	transactions.WriteString("ts,project_id,event,props,nums\n")
+	for i := 0; i < n; i++ {
+		transactions.WriteString(`2024-04-15 02:15:07.167,4974,BUY_ITEMS,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""2""}"` + "\n")
+	}
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(transactions.String()), 0o600))
+	return args
+}
+
+func TestAggregateTransactions(t *testing.T) {
+	args := writeAggInputs(t, 100)
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,100,100\n", string(content))
+}
+
+func TestAggregateTransactions_GroupBy(t *testing.T) {
+	args := writeAggInputs(t, 10)
+	args.GroupBy = []string{"date", "country", "device_type"}
+	content, err := os.ReadFile(args.InputTransactions[0])
+	assert.NoError(t, err)
+	transactions := strings.Replace(string(content), "ts,project_id,event,props,nums\n", "ts,project_id,event,props,nums,country,device_type\n", 1)
+	transactions = strings.ReplaceAll(transactions, "}\"\n", "}\",DE,desktop\n")
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(transactions), 0o600))
+
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err = os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,Country,DeviceType,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,DE,desktop,10,10\n", string(content))
+}
+
+func TestAggregateTransactions_TimeBucket(t *testing.T) {
+	args := writeAggInputs(t, 10)
+	args.TimeBucket = "hour"
+	args.Timezone = "Europe/Berlin"
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hour,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15T04:00:00+02:00,4974,10,10\n", string(content))
+
+	args.Timezone = "Mars/Olympus_Mons"
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "invalid time zone")
+}
+
+func TestAggregateTransactions_Metrics(t *testing.T) {
+	args := writeAggInputs(t, 10)
+	args.Metrics = []string{"mean", "max", "distinct_users"}
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd,MaxVolumeUsd,MeanVolumeUsd,DistinctUsers\n2024-04-15,4974,10,10,1,1,1\n", string(content))
+}
+
+func TestAggregateTransactions_Filter(t *testing.T) {
+	args := writeAggInputs(t, 10)
+	args.Filter = `event == "SELL_ITEMS"`
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	// Every transaction is filtered out
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n", string(content))
+
+	args.Filter = `volume > "1"`
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "invalid filter")
+}
+
+func TestAggregateTransactions_UnknownCurrency(t *testing.T) {
+	args := writeAggInputs(t, 3)
+	content, err := os.ReadFile(args.InputTransactions[0])
+	assert.NoError(t, err)
+	exotic := strings.Replace(strings.TrimSuffix(string(content), "\n"), "4974", "0", 1)
+	exotic = strings.Replace(exotic, "SFL", "EXOTIC", 1)
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(exotic+"\n"), 0o600))
+
+	// The run fails by default
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "currency symbol not supported: EXOTIC")
+
+	// The transaction is sent to the error output, the others are aggregated
+	args.UnknownCurrency = "dead-letter"
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+	content, err = os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,2,2\n", string(content))
+	content, err = os.ReadFile(args.OutputErr)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "currency symbol not supported: EXOTIC on 2024-04-15 in usd,2024-04-15 02:15:07.167,0,"), string(content))
+}
+
+func TestAggregateTransactions_Canceled(t *testing.T) {
+	args := writeAggInputs(t, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := aggregateTransactions(ctx, args)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// The aggregates are discarded
+	assert.NoFileExists(t, args.Output)
+}
+
+func TestAggregateTransactions_Resume(t *testing.T) {
+	args := writeAggInputs(t, 100)
+	args.MicroBatchSize = 5
+	args.Metrics = []string{"max", "distinct_users"}
+	// Prices fetched two weeks before the transactions
+	prices := `[{"id":"sunflower-land","symbol":"sfl","name":"Sunflower Land","currency":"usd","price":0.5,"market_cap":1000,"snapshot_time":"2024-04-01T00:00:00Z"}]`
+	assert.NoError(t, os.WriteFile(args.InputCurrencyValue, []byte(prices), 0o600))
+
+	// 100 transactions of 3 projects, the 6th one is an outlier and the 56th one is in an unknown currency
+	content, err := os.ReadFile(args.InputTransactions[0])
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	for i := 1; i < len(lines); i++ {
+		lines[i] = strings.Replace(lines[i], "4974", []string{"1", "2", "3"}[i%3], 1)
+	}
+	lines[6] = strings.Replace(lines[6], "2024-04-15", "15/04/2024", 1)
+	fixed := strings.Join(lines, "\n") + "\n"
+	lines[56] = strings.Replace(lines[56], "SFL", "EXOTIC", 1)
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+
+	// The run fails after the checkpoints of the first 20 and 40 transactions
+	args.Checkpoint = filepath.Join(t.TempDir(), "checkpoint")
+	args.CheckpointEvery = 20
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "currency symbol not supported: EXOTIC")
+	checkpoint, err := os.ReadFile(filepath.Join(args.Checkpoint, "checkpoint.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(checkpoint), `"rows":40`)
+
+	// Once the transactions file is replaced, its checkpoint cannot be resumed
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(fixed), 0o600))
+	args.Resume = true
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "it was started with other arguments or input files")
+
+	// The run fails on the stale prices, once every transaction is checkpointed
+	args.Resume = false
+	args.MaxPriceAge = 24 * time.Hour
+	args.StalePrices = stalePricesFail
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "stale currency values")
+	assert.FileExists(t, filepath.Join(args.Checkpoint, "checkpoint.json"))
+
+	// Resumed accepting the stale prices, the output is the one of an uninterrupted run
+	args.Resume = true
+	args.StalePrices = stalePricesWarn
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+	resumedOutput, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	resumedErrors, err := os.ReadFile(args.OutputErr)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(args.Checkpoint, "checkpoint.json"))
+
+	args.Checkpoint, args.Resume = "", false
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+	output, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	outliers, err := os.ReadFile(args.OutputErr)
+	assert.NoError(t, err)
+	assert.Equal(t, string(output), string(resumedOutput))
+	assert.Equal(t, string(outliers), string(resumedErrors))
+	assert.Contains(t, string(output), "2024-04-15,1,32,32,1,1\n")
+	assert.Contains(t, string(outliers), "15/04/2024")
+
+	// An aggregation with other arguments cannot be resumed
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+	args.Checkpoint = filepath.Join(t.TempDir(), "checkpoint")
+	assert.Error(t, aggregateTransactions(context.Background(), args))
+	args.Resume = true
+	args.Metrics = []string{"max"}
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "it was started with other arguments")
+}
+
+func TestAggregateTransactions_MultipleFiles(t *testing.T) {
+	args := writeAggInputs(t, 4)
+	content, err := os.ReadFile(args.InputTransactions[0])
+	assert.NoError(t, err)
+	events := filepath.Join(filepath.Dir(args.InputTransactions[0]), "events")
+	assert.NoError(t, os.Mkdir(events, 0o700))
+	exotic := strings.Replace(string(content), "SFL", "EXOTIC", 1)
+	for _, name := range []string{"part-1.csv", "part-2.csv", "part-3.csv"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(events, name), content, 0o600))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(events, "part-2.csv"), []byte(exotic), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(events, "README.md"), []byte("not a transactions file"), 0o600))
+
+	// A glob, a file it already matches and another file
+	args.InputTransactions = []string{filepath.Join(events, "*.csv"), filepath.Join(events, "part-1.csv"), args.InputTransactions[0]}
+	args.UnknownCurrency = "dead-letter"
+	args.FileParallelism = 2
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err = os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,15,15\n", string(content))
+	content, err = os.ReadFile(args.OutputErr)
+	assert.NoError(t, err)
+	// The dead-letter row records its file
+	assert.True(t, strings.HasSuffix(string(content), ","+filepath.Join(events, "part-2.csv")+"\n"), string(content))
+
+	// A path without any file fails
+	args.InputTransactions = []string{filepath.Join(events, "*.json")}
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "no file found at")
+}
+
+// gzipFile writes the gzip of the file next to it, returning its path
+func gzipFile(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, os.WriteFile(path+".gz", compressed.Bytes(), 0o600))
+	return path + ".gz"
+}
+
+func TestAggregateTransactions_Compressed(t *testing.T) {
+	args := writeAggInputs(t, 10)
+
+	// gzip transactions and currency values, detected by their extension
+	args.InputTransactions[0] = gzipFile(t, args.InputTransactions[0])
+	args.InputCurrencyValue = gzipFile(t, args.InputCurrencyValue)
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,10,10\n", string(content))
+}
+
+func TestAggregateTransactions_InputErrors(t *testing.T) {
+	// A row that cannot be parsed ends its file, like before, but the aggregation goes on
+	args := writeAggInputs(t, 10)
+	file, err := os.OpenFile(args.InputTransactions[0], os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = file.WriteString("2024-04-15 02:15:07.167,4974,BUY_ITEMS,\"{\"currencySymbol\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,10,10\n", string(content))
+
+	// A file that cannot be read fails the aggregation
+	args = writeAggInputs(t, 100)
+	compressed, err := os.ReadFile(gzipFile(t, args.InputTransactions[0]))
+	assert.NoError(t, err)
+	truncated := args.InputTransactions[0] + ".truncated.gz"
+	assert.NoError(t, os.WriteFile(truncated, compressed[:len(compressed)/2], 0o600))
+	args.InputTransactions = append(args.InputTransactions, truncated)
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "failed to read transactions file "+truncated)
+}
+
+func TestAggregateTransactions_InputFormat(t *testing.T) {
+	args := writeAggInputs(t, 0)
+	var transactions strings.Builder
+	for i := 0; i < 10; i++ {
+		transactions.WriteString(`{"ts": "2024-04-15 02:15:07.167", "project_id": "4974", "event": "BUY_ITEMS", "props": {"currencySymbol": "SFL"}, "nums": {"currencyValueDecimal": "2"}}` + "\n")
+	}
+	args.InputTransactions[0] = strings.TrimSuffix(args.InputTransactions[0], ".csv") + ".jsonl"
+	assert.NoError(t, os.WriteFile(args.InputTransactions[0], []byte(transactions.String()), 0o600))
+
+	// Detected from the extension
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+	content, err := os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,10,10\n", string(content))
+
+	// Or given for the files without extension
+	events := strings.TrimSuffix(args.InputTransactions[0], ".jsonl")
+	assert.NoError(t, os.Rename(args.InputTransactions[0], events))
+	args.InputTransactions[0] = events
+	args.InputFormat = "ndjson"
+	assert.NoError(t, aggregateTransactions(context.Background(), args))
+	content, err = os.ReadFile(args.Output)
+	assert.NoError(t, err)
+	assert.Equal(t, "Date,ProjectId,NumberOfTransactions,TotalVolumeUsd\n2024-04-15,4974,10,10\n", string(content))
+
+	args.InputFormat = "xml"
+	assert.ErrorContains(t, aggregateTransactions(context.Background(), args), "unsupported input format")
+}
@@ -1,30 +1,65 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"hodctl/pkg/io"
+	"hodctl/pkg/secret"
+	"hodctl/pkg/sink"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 // FetchArgs ars for the 'fetch' command
 type FetchArgs struct {
-	apikey string // API key for CoinGecko
-	output string // Path to the output CSV file
+	apikey       string        // API key for CoinGecko, prefer apikeyFrom as flags leak into the shell history and process list
+	apikeyFrom   string        // Reference of the API key for CoinGecko (env:NAME, file:/path, cmd:command, keychain:service/account)
+	pro          bool          // Use the CoinGecko Pro API
+	apiURL       string        // Base URL of the CoinGecko API, empty for the default Demo or Pro API
+	rateLimit    int           // Maximum requests per minute to the CoinGecko API, 0 for the plan's default quota
+	attempts     int           // Maximum attempts of each request to the CoinGecko API
+	output       string        // Path to the output file or BigQuery table
+	source       string        // Provider of the prices (coingecko, file, rest)
+	sourceURL    string        // Path of the price file or URL of the REST endpoint for the file and rest sources
+	from         string        // First day of the price history (YYYY-MM-DD), empty to fetch the current prices
+	to           string        // Last day of the price history (YYYY-MM-DD)
+	ids          []string      // CoinGecko IDs of the coins to fetch the price history for
+	vsCurrencies []string      // Quote currencies of the prices
+	resume       bool          // Resume an interrupted fetch from its last completed page
+	symbolsFrom  string        // Path to the transactions whose currency symbols are the only coins to fetch
+	cacheDir     string        // Directory of the cached CoinGecko API responses, empty for the user cache directory
+	cacheTTL     time.Duration // Time the cached responses are served without revalidation, 0 disables the cache
+	offline      bool          // Only serve the CoinGecko API responses from the cache
 }
 
+// apiKeyEnv is the environment variable read for the CoinGecko API key when no other source is given
+const apiKeyEnv = "COINGECKO_API_KEY"
+
+// Supported price sources
+const (
+	sourceCoinGecko = "coingecko"
+	sourceFile      = "file"
+	sourceRest      = "rest"
+)
+
 var fetchArgs FetchArgs
 
 var fetchCmd = &cobra.Command{
 	Use:   "fetch",
-	Short: "Preload all the currency conversion rates in USD and save as CSV",
-	Long:  "Fetch the value of all currencies from the CoinGecko API and save it as a CSV file",
+	Short: "Fetch the currency prices in one or more quote currencies and save them as CSV, JSON, Parquet or to BigQuery",
+	Long:  "Fetch the value of all currencies from a price source (CoinGecko API by default) and save it as a CSV, JSON or Parquet file, or to BigQuery",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("Fetching currency conversion rates with API key: %s\n", fetchArgs.apikey[:5]+"****")
+		fmt.Printf("Fetching currency conversion rates from: %s\n", fetchArgs.source)
 		fmt.Printf("Saving results to: %s\n", fetchArgs.output)
-		if err := fetchAndSaveConversionRates(fetchArgs.apikey, fetchArgs.output); err != nil {
+		if err := fetchAndSaveConversionRates(fetchArgs); err != nil {
 			fmt.Fprintf(os.Stderr, "Error fetching and saving conversion rates: %v\n", err)
 			os.Exit(1)
 		}
@@ -33,36 +68,241 @@ var fetchCmd = &cobra.Command{
 
 func init() {
 	// Define the flags for the 'fetch' command
-	fetchCmd.Flags().StringVarP(&fetchArgs.apikey, "apikey", "a", "", "API key for CoinGecko (required)")
-	fetchCmd.Flags().StringVarP(&fetchArgs.output, "output", "o", "", "Path to save the CSV file (required)")
+	fetchCmd.Flags().StringVarP(&fetchArgs.apikey, "apikey", "a", "", "API key for CoinGecko, prefer --apikey-from or the "+apiKeyEnv+" environment variable")
+	fetchCmd.Flags().StringVar(&fetchArgs.apikeyFrom, "apikey-from", "", "Where to read the CoinGecko API key from: env:NAME, file:/path, cmd:command or keychain:service/account (default env:"+apiKeyEnv+")")
+	fetchCmd.Flags().BoolVar(&fetchArgs.pro, "pro", false, "Use the CoinGecko Pro API (pro-api.coingecko.com) with a Pro API key")
+	fetchCmd.Flags().StringVar(&fetchArgs.apiURL, "api-url", "", "Override the base URL of the CoinGecko API")
+	fetchCmd.Flags().IntVar(&fetchArgs.rateLimit, "rate-limit", 0, "Maximum requests per minute to the CoinGecko API (default 30 for the Demo API, 500 for the Pro API)")
+	fetchCmd.Flags().IntVar(&fetchArgs.attempts, "max-attempts", io.DefaultRetryPolicy.MaxAttempts, "Maximum attempts of each request to the CoinGecko API before giving up")
+	fetchCmd.Flags().StringVarP(&fetchArgs.output, "output", "o", "", "Path to save the prices: CSV, JSON (.json, .jsonl) or Parquet (.parquet) file, or BigQuery table (bq://project/dataset/table) (required)")
+	fetchCmd.Flags().StringVarP(&fetchArgs.source, "source", "s", sourceCoinGecko, "Provider of the prices: coingecko, file (JSON/YAML symbol->price file) or rest (endpoint returning a JSON symbol->price map)")
+	fetchCmd.Flags().StringVar(&fetchArgs.sourceURL, "source-url", "", "Path of the price file (file source) or URL of the endpoint (rest source)")
+	fetchCmd.Flags().StringVar(&fetchArgs.from, "from", "", "First day (YYYY-MM-DD) of the daily price history to fetch, instead of the current prices")
+	fetchCmd.Flags().StringVar(&fetchArgs.to, "to", "", "Last day (YYYY-MM-DD) of the daily price history to fetch (default today)")
+	fetchCmd.Flags().StringSliceVar(&fetchArgs.ids, "ids", nil, "CoinGecko IDs of the coins to fetch the daily price history for (e.g. bitcoin,ethereum)")
+
+	fetchCmd.Flags().StringVar(&fetchArgs.symbolsFrom, "symbols-from", "", "Path to a transactions CSV file (gs, s3, local file system), only the coins of its currency symbols are fetched")
+	fetchCmd.Flags().StringVar(&fetchArgs.cacheDir, "cache-dir", "", "Directory of the cached CoinGecko API responses (default the hodctl/coingecko user cache directory)")
+	fetchCmd.Flags().DurationVar(&fetchArgs.cacheTTL, "cache-ttl", 0, "Cache the CoinGecko API responses on disk and serve them for this long before revalidating them, e.g. 1h (0 disables the cache)")
+	fetchCmd.Flags().BoolVar(&fetchArgs.offline, "offline", false, "Only serve the CoinGecko API responses from the cache, whatever their age, without any request")
+	fetchCmd.Flags().BoolVar(&fetchArgs.resume, "resume", false, "Resume an interrupted fetch of the current prices from its last completed page")
+	fetchCmd.Flags().StringSliceVar(&fetchArgs.vsCurrencies, "vs-currency", []string{io.DefaultCurrency}, "Quote currencies of the prices, e.g. eur or usd,eur (coingecko source only)")
 
 	// Mark the flags as required
-	fetchCmd.MarkFlagRequired("apikey")
 	fetchCmd.MarkFlagRequired("output")
 
 	rootCmd.AddCommand(fetchCmd)
 }
 
-func fetchAndSaveConversionRates(apikey string, output string) error {
+// newPriceSource creates the price source selected by the 'fetch' args
+func newPriceSource(ctx context.Context, args FetchArgs) (io.PriceSource, error) {
+	vsCurrencies := io.NormalizeCurrencies(args.vsCurrencies)
+	if args.source != sourceCoinGecko && (len(vsCurrencies) > 1 || len(vsCurrencies) == 1 && vsCurrencies[0] != io.DefaultCurrency) {
+		return nil, fmt.Errorf("the %s source only provides %s prices", args.source, io.DefaultCurrency)
+	}
+
+	if args.offline && args.source != sourceCoinGecko {
+		return nil, fmt.Errorf("--offline is only supported by the %s source", sourceCoinGecko)
+	}
+
+	switch args.source {
+	case sourceCoinGecko:
+		// Offline, the responses are served from the cache and the API key is not needed
+		var apiKey secret.Secret
+		if !args.offline {
+			var err error
+			if apiKey, err = resolveAPIKey(ctx, args); err != nil {
+				return nil, err
+			}
+		}
+		opts := []io.CoinGeckoOption{io.WithVsCurrencies(vsCurrencies...)}
+		if args.cacheTTL > 0 || args.offline {
+			cache, err := newResponseCache(args)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, io.WithResponseCache(cache))
+		}
+		if args.pro {
+			opts = append(opts, io.WithProAPI())
+		}
+		if args.apiURL != "" {
+			opts = append(opts, io.WithBaseURL(args.apiURL))
+		}
+		if args.rateLimit > 0 {
+			opts = append(opts, io.WithRateLimit(args.rateLimit))
+		}
+		if args.attempts > 0 {
+			retry := io.DefaultRetryPolicy
+			retry.MaxAttempts = args.attempts
+			opts = append(opts, io.WithRetryPolicy(retry))
+		}
+		client, err := io.NewCoinGeckoClient(ctx, apiKey, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if !args.offline {
+			log.Printf("Connected to CoinGecko API\n")
+		}
+		return client, nil
+	case sourceFile:
+		if args.sourceURL == "" {
+			return nil, errors.New("--source-url is required for the file source")
+		}
+		return io.NewStaticPriceSource(args.sourceURL)
+	case sourceRest:
+		if args.sourceURL == "" {
+			return nil, errors.New("--source-url is required for the rest source")
+		}
+		return io.NewRestPriceSource(args.sourceURL), nil
+	default:
+		return nil, fmt.Errorf("unsupported price source: %s", args.source)
+	}
+}
+
+// newResponseCache creates the cache of the CoinGecko API responses
+func newResponseCache(args FetchArgs) (*io.ResponseCache, error) {
+	dir := args.cacheDir
+	if dir == "" {
+		var err error
+		if dir, err = io.DefaultResponseCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	if !args.offline {
+		log.Printf("Caching the CoinGecko API responses in %s for %v\n", dir, args.cacheTTL)
+	}
+	return &io.ResponseCache{Dir: dir, TTL: args.cacheTTL, Offline: args.offline}, nil
+}
+
+// resolveAPIKey resolves the CoinGecko API key from --apikey, --apikey-from or the COINGECKO_API_KEY environment variable
+func resolveAPIKey(ctx context.Context, args FetchArgs) (secret.Secret, error) {
+	if args.apikey != "" && args.apikeyFrom != "" {
+		return "", errors.New("--apikey and --apikey-from cannot be used together")
+	}
+
+	var apiKey secret.Secret
+	var origin string
+	switch {
+	case args.apikey != "":
+		log.Printf("WARNING: --apikey leaks the API key into the shell history and process list, prefer --apikey-from or %s\n", apiKeyEnv)
+		apiKey, origin = secret.Secret(args.apikey), "--apikey"
+	case args.apikeyFrom != "":
+		key, err := secret.Resolve(ctx, args.apikeyFrom)
+		if err != nil {
+			return "", err
+		}
+		scheme, _, _ := strings.Cut(args.apikeyFrom, ":")
+		apiKey, origin = key, "the "+scheme+" provider"
+	default:
+		key, exists := os.LookupEnv(apiKeyEnv)
+		if !exists || key == "" {
+			return "", fmt.Errorf("an API key is required for the coingecko source, set %s or use --apikey-from", apiKeyEnv)
+		}
+		apiKey, origin = secret.Secret(key), apiKeyEnv
+	}
+
+	fmt.Printf("Using CoinGecko API key %s from %s\n", apiKey, origin)
+	return apiKey, nil
+}
+
+func fetchAndSaveConversionRates(args FetchArgs) error {
+	// SIGINT and SIGTERM cancel the requests in flight, a second signal kills the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	client, err := io.NewCoinGeckoClient(apikey)
+	source, err := newPriceSource(ctx, args)
 	if err != nil {
 		return err
 	}
 
+	if args.from != "" && args.symbolsFrom != "" {
+		return errors.New("--symbols-from cannot be used with --from, use --ids for the price history")
+	}
+
+	if args.from == "" && args.symbolsFrom == "" {
+		// Pages are staged before the output, which is only published once complete
+		output, err := io.NewStagedOutput(stagingPath(args.output), source.Name(), args.resume)
+		if err != nil {
+			return err
+		}
+		return io.SaveStagedPrices(ctx, source, output, func() (io.PriceWriter, error) {
+			return sink.NewPriceSink(ctx, args.output)
+		})
+	}
+
+	priceSink, err := sink.NewPriceSink(ctx, args.output)
 	if err != nil {
 		return err
 	}
-	log.Printf("Connected to CoinGecko API\n")
+	if args.from != "" {
+		err = savePriceHistory(ctx, source, priceSink, args)
+	} else {
+		err = saveSymbolPrices(ctx, source, priceSink, args)
+	}
+	if err != nil {
+		safeClose(priceSink, "priceSink")
+		return err
+	}
+	return priceSink.Close()
+}
 
-	writer, err := io.Open(output)
+// saveSymbolPrices fetches the prices of the currency symbols referenced by the transactions, and reports the unresolved ones
+func saveSymbolPrices(ctx context.Context, source io.PriceSource, writer io.PriceWriter, args FetchArgs) error {
+	transactions, err := io.OpenReader(args.symbolsFrom)
+	if err != nil {
+		return fmt.Errorf("failed to open transactions file: %w", err)
+	}
+	symbols, err := io.ScanCurrencySymbols(transactions)
+	safeClose(transactions, "transactions")
 	if err != nil {
 		return err
 	}
 
-	err = client.Save(writer)
+	log.Printf("Fetching the prices of %d currency symbols: %s\n", len(symbols), strings.Join(symbols, ","))
+	unresolved, err := io.SaveSymbolPrices(ctx, source, writer, symbols)
 	if err != nil {
 		return err
 	}
-	return writer.Close()
+	if len(unresolved) > 0 {
+		fmt.Printf("%d currency symbols could not be resolved to a coin: %s\n", len(unresolved), strings.Join(unresolved, ","))
+	}
+	return nil
+}
+
+// stagingPath returns where the pages of a fetch are staged: next to file outputs,
+// in the temporary directory for the outputs that are not files (BigQuery)
+func stagingPath(output string) string {
+	if !sink.IsBigQueryPath(output) {
+		return output
+	}
+	name := strings.NewReplacer("://", "_", "/", "_").Replace(output)
+	return filepath.Join(os.TempDir(), "hodctl-fetch-"+name)
+}
+
+func savePriceHistory(ctx context.Context, source io.PriceSource, writer io.PriceWriter, args FetchArgs) error {
+	historicalSource, ok := source.(io.HistoricalPriceSource)
+	if !ok {
+		return fmt.Errorf("the %s source does not provide price history", args.source)
+	}
+
+	fromDate, err := time.Parse(io.PriceDateLayout, args.from)
+	if err != nil {
+		return fmt.Errorf("invalid --from date: %w", err)
+	}
+
+	toDate := time.Now().UTC().Truncate(24 * time.Hour)
+	if args.to != "" {
+		toDate, err = time.Parse(io.PriceDateLayout, args.to)
+		if err != nil {
+			return fmt.Errorf("invalid --to date: %w", err)
+		}
+	}
+
+	log.Printf("Fetching daily prices from %s to %s\n", fromDate.Format(io.PriceDateLayout), toDate.Format(io.PriceDateLayout))
+	return io.SavePriceHistory(ctx, historicalSource, writer, args.ids, fromDate, toDate)
 }
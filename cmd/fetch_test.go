@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+	"hodctl/pkg/io/coingeckotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIKey = "CG-test-key"
+
+var testCoins = []coingeckotest.Coin{
+	{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", CurrentPrice: 65000},
+	{ID: "ethereum", Symbol: "eth", Name: "Ethereum", CurrentPrice: 3000},
+	{ID: "sunflower-land", Symbol: "sfl", Name: "Sunflower Land", CurrentPrice: 0.06},
+}
+
+func TestFetchAndSaveConversionRates(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, testCoins)
+	server.Pro = true
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "currencies_usd.csv")
+	err := fetchAndSaveConversionRates(FetchArgs{
+		apikey:    testAPIKey,
+		pro:       true,
+		apiURL:    server.URL,
+		rateLimit: 60_000,
+		source:    sourceCoinGecko,
+		output:    output,
+	})
+	assert.NoError(t, err)
+
+	reader, err := os.Open(output)
+	assert.NoError(t, err)
+	defer reader.Close()
+
+	table, err := io.ReadPriceTable(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, io.Currency2Values{"BTC": 65000, "ETH": 3000, "SFL": 0.06}, table.Quotes["usd"].Snapshot)
+}
+
+func TestFetchAndSaveConversionRates_Unauthorized(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, testCoins)
+	defer server.Close()
+
+	err := fetchAndSaveConversionRates(FetchArgs{
+		apikey:    "CG-wrong-key",
+		apiURL:    server.URL,
+		rateLimit: 60_000,
+		source:    sourceCoinGecko,
+		output:    filepath.Join(t.TempDir(), "currencies_usd.csv"),
+	})
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "wrong-key")
+}
+
+func TestResolveAPIKey(t *testing.T) {
+	t.Setenv(apiKeyEnv, "CG-from-env")
+
+	// Short keys are masked without panicking
+	key, err := resolveAPIKey(context.Background(), FetchArgs{apikey: "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", key.Reveal())
+	assert.Equal(t, "****", key.String())
+
+	key, err = resolveAPIKey(context.Background(), FetchArgs{})
+	assert.NoError(t, err)
+	assert.Equal(t, "CG-from-env", key.Reveal())
+
+	path := filepath.Join(t.TempDir(), "coingecko.key")
+	assert.NoError(t, os.WriteFile(path, []byte("CG-from-file\n"), 0o600))
+	key, err = resolveAPIKey(context.Background(), FetchArgs{apikeyFrom: "file:" + path})
+	assert.NoError(t, err)
+	assert.Equal(t, "CG-from-file", key.Reveal())
+
+	_, err = resolveAPIKey(context.Background(), FetchArgs{apikey: "abc", apikeyFrom: "file:" + path})
+	assert.Error(t, err)
+
+	t.Setenv(apiKeyEnv, "")
+	_, err = resolveAPIKey(context.Background(), FetchArgs{})
+	assert.ErrorContains(t, err, "an API key is required")
+}
+
+func TestFetchAndSaveConversionRates_SymbolsFrom(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, testCoins)
+	defer server.Close()
+
+	dir := t.TempDir()
+	transactions := filepath.Join(dir, "transactions.csv")
+	assert.NoError(t, os.WriteFile(transactions, []byte(`"ts","event","project_id","props","nums"
+"2024-04-15 02:15:07.167","BUY_ITEMS","4974","{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""0.6""}"
+"2024-04-15 02:26:37.134","BUY_ITEMS","0","{""currencySymbol"":""UNKNOWN""}","{""currencyValueDecimal"":""7""}"
+`), 0o644))
+
+	output := filepath.Join(dir, "currencies_usd.csv")
+	err := fetchAndSaveConversionRates(FetchArgs{
+		apikey:      testAPIKey,
+		apiURL:      server.URL,
+		rateLimit:   60_000,
+		source:      sourceCoinGecko,
+		output:      output,
+		symbolsFrom: transactions,
+	})
+	assert.NoError(t, err)
+
+	reader, err := os.Open(output)
+	assert.NoError(t, err)
+	defer reader.Close()
+	table, err := io.ReadPriceTable(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, io.Currency2Values{"SFL": 0.06}, table.Quotes["usd"].Snapshot)
+}
+
+func TestFetchAndSaveConversionRates_Offline(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, testCoins)
+	defer server.Close()
+
+	dir := t.TempDir()
+	args := FetchArgs{
+		apikey:    testAPIKey,
+		apiURL:    server.URL,
+		rateLimit: 60_000,
+		source:    sourceCoinGecko,
+		output:    filepath.Join(dir, "online.csv"),
+		cacheDir:  filepath.Join(dir, "cache"),
+		cacheTTL:  time.Hour,
+	}
+	assert.NoError(t, fetchAndSaveConversionRates(args))
+	requests := len(server.Requests())
+
+	// Offline, no API key is needed and the server is not called
+	args.apikey = ""
+	args.offline = true
+	args.output = filepath.Join(dir, "offline.csv")
+	assert.NoError(t, fetchAndSaveConversionRates(args))
+	assert.Len(t, server.Requests(), requests)
+
+	reader, err := os.Open(args.output)
+	assert.NoError(t, err)
+	defer reader.Close()
+	table, err := io.ReadPriceTable(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, io.Currency2Values{"BTC": 65000, "ETH": 3000, "SFL": 0.06}, table.Quotes["usd"].Snapshot)
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hodctl/pkg/io"
+	stdio "io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// PricesDiffArgs args for the 'prices diff' command
+type PricesDiffArgs struct {
+	threshold   float64 // Relative price change above which a move is reported and breaches the check
+	maxAdded    int     // Maximum coins added before breaching the check, -1 for no limit
+	maxRemoved  int     // Maximum coins removed before breaching the check, -1 for no limit
+	failOnRemap bool    // Breach the check when a symbol is priced by another coin
+	format      string  // Output format: table or json
+}
+
+// Output formats of 'prices diff'
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// exitCodeBreach is the exit code of 'prices diff' when a threshold is breached
+const exitCodeBreach = 2
+
+var pricesDiffArgs PricesDiffArgs
+
+var pricesCmd = &cobra.Command{
+	Use:   "prices",
+	Short: "Inspect the currency value files",
+	Long:  "Inspect the currency value files saved by fetch",
+}
+
+var pricesDiffCmd = &cobra.Command{
+	Use:   "diff <old prices> <new prices>",
+	Short: "Compare two currency value files",
+	Long: "Compare two currency value files and report the coins added or removed, the symbols priced by another coin and the price moves beyond the threshold. " +
+		"Exits with code 2 when a threshold is breached.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		breached, err := diffPrices(args[0], args[1], pricesDiffArgs, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error comparing prices: %v\n", err)
+			os.Exit(1)
+		}
+		if breached {
+			os.Exit(exitCodeBreach)
+		}
+	},
+}
+
+func init() {
+	pricesDiffCmd.Flags().Float64Var(&pricesDiffArgs.threshold, "threshold", 0.5, "Relative price change reported as a move, e.g. 0.5 for 50%")
+	pricesDiffCmd.Flags().IntVar(&pricesDiffArgs.maxAdded, "max-added", -1, "Maximum prices added before failing (-1 for no limit)")
+	pricesDiffCmd.Flags().IntVar(&pricesDiffArgs.maxRemoved, "max-removed", -1, "Maximum prices removed before failing (-1 for no limit)")
+	pricesDiffCmd.Flags().BoolVar(&pricesDiffArgs.failOnRemap, "fail-on-remap", false, "Fail when a symbol is priced by another coin")
+	pricesDiffCmd.Flags().StringVar(&pricesDiffArgs.format, "format", formatTable, "Output format: table or json")
+
+	pricesCmd.AddCommand(pricesDiffCmd)
+	rootCmd.AddCommand(pricesCmd)
+}
+
+// diffPrices compares the price files, writes the differences to out and tells whether a threshold was breached
+func diffPrices(oldPath, newPath string, args PricesDiffArgs, out stdio.Writer) (bool, error) {
+	if args.format != formatTable && args.format != formatJSON {
+		return false, fmt.Errorf("unsupported format: %s (expected %s or %s)", args.format, formatTable, formatJSON)
+	}
+
+	ctx := context.Background()
+	oldPrices, err := io.LoadPrices(ctx, oldPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", oldPath, err)
+	}
+	newPrices, err := io.LoadPrices(ctx, newPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", newPath, err)
+	}
+
+	diff, err := io.DiffPrices(oldPrices, newPrices, args.threshold, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if args.format == formatJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(diff)
+	} else {
+		err = writeDiffTable(diff, out)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to write the differences: %w", err)
+	}
+
+	breached := len(diff.Moves) > 0 ||
+		args.maxAdded >= 0 && len(diff.Added) > args.maxAdded ||
+		args.maxRemoved >= 0 && len(diff.Removed) > args.maxRemoved ||
+		args.failOnRemap && len(diff.Remaps) > 0
+	return breached, nil
+}
+
+// writeDiffTable writes the differences as an aligned text table
+func writeDiffTable(diff *io.PriceDiff, out stdio.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%d added, %d removed, %d remapped, %d moved more than %.2f%%\n",
+		len(diff.Added), len(diff.Removed), len(diff.Remaps), len(diff.Moves), diff.Threshold*100)
+	fmt.Fprintln(w, "CHANGE\tID\tSYMBOL\tCURRENCY\tDATE\tOLD\tNEW\tMOVE")
+	for _, change := range diff.Added {
+		writeChangeRow(w, "added", change)
+	}
+	for _, change := range diff.Removed {
+		writeChangeRow(w, "removed", change)
+	}
+	for _, change := range diff.Moves {
+		writeChangeRow(w, "moved", change)
+	}
+	for _, remap := range diff.Remaps {
+		fmt.Fprintf(w, "remapped\t%s -> %s\t%s\t\t\t\t\t\n", remap.OldID, remap.NewID, remap.Symbol)
+	}
+	return w.Flush()
+}
+
+func writeChangeRow(w stdio.Writer, kind string, change io.PriceChange) {
+	move := ""
+	if kind == "moved" {
+		move = formatChange(change.Change)
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", kind, change.ID, change.Symbol, change.Currency, change.Date,
+		formatPrice(change.OldPrice), formatPrice(change.NewPrice), move)
+}
+
+func formatPrice(price float64) string {
+	if price == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(price, 'f', -1, 64)
+}
+
+func formatChange(change float64) string {
+	return fmt.Sprintf("%+.2f%%", change*100)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hodctl/pkg/io"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writePriceFiles(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "old.csv")
+	newPath := filepath.Join(dir, "new.csv")
+	assert.NoError(t, os.WriteFile(oldPath, []byte(`ID,Symbol,Name,Currency,Price,Market Cap
+bitcoin,btc,Bitcoin,usd,60000,1200000000000
+ethereum,eth,Ethereum,usd,3000,360000000000
+`), 0o644))
+	assert.NoError(t, os.WriteFile(newPath, []byte(`ID,Symbol,Name,Currency,Price,Market Cap
+bitcoin,btc,Bitcoin,usd,66000,1200000000000
+solana,sol,Solana,usd,150,70000000000
+`), 0o644))
+	return oldPath, newPath
+}
+
+func TestDiffPrices_Table(t *testing.T) {
+	oldPath, newPath := writePriceFiles(t)
+
+	var out bytes.Buffer
+	breached, err := diffPrices(oldPath, newPath, PricesDiffArgs{threshold: 0.05, maxAdded: -1, maxRemoved: -1, format: formatTable}, &out)
+	assert.NoError(t, err)
+	assert.True(t, breached)
+	assert.Equal(t, `1 added, 1 removed, 0 remapped, 1 moved more than 5.00%
+CHANGE   ID        SYMBOL  CURRENCY  DATE  OLD    NEW    MOVE
+added    solana    SOL     usd                    150    
+removed  ethereum  ETH     usd             3000          
+moved    bitcoin   BTC     usd             60000  66000  +10.00%
+`, out.String())
+}
+
+func TestDiffPrices_JSON(t *testing.T) {
+	oldPath, newPath := writePriceFiles(t)
+
+	// Moves below the threshold and a single removed coin are accepted
+	var out bytes.Buffer
+	breached, err := diffPrices(oldPath, newPath, PricesDiffArgs{threshold: 0.2, maxAdded: -1, maxRemoved: 1, format: formatJSON}, &out)
+	assert.NoError(t, err)
+	assert.False(t, breached)
+
+	var diff io.PriceDiff
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &diff))
+	assert.Len(t, diff.Added, 1)
+	assert.Len(t, diff.Removed, 1)
+	assert.Empty(t, diff.Moves)
+
+	// No coin can be removed
+	breached, err = diffPrices(oldPath, newPath, PricesDiffArgs{threshold: 0.2, maxAdded: -1, maxRemoved: 0, format: formatJSON}, &out)
+	assert.NoError(t, err)
+	assert.True(t, breached)
+}
@@ -0,0 +1,43 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/hamba/avro/v2/ocf"
+)
+
+// AvroSource reads the transactions of an Avro container file, one record each with the columns of the transactions CSV.
+// The nested values, e.g. a props record, are read as their JSON.
+type AvroSource struct{}
+
+func (AvroSource) Read(ctx context.Context, reader io.Reader, microBatchSize int, skip int64) (<-chan MicroBatch, error) {
+	decoder, err := ocf.NewDecoder(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Avro file: %w", err)
+	}
+	sender, dataCh := newBatchSender(ctx, microBatchSize, skip)
+
+	go func() {
+		for decoder.HasNext() {
+			var record map[string]any
+			if err = decoder.Decode(&record); err != nil {
+				break
+			}
+			var rt RawTransaction
+			for column, value := range record {
+				rt.setColumn(column, value)
+			}
+			if err = sender.add(rt); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = decoder.Error()
+		}
+		sender.close(FormatAvro, err)
+	}()
+
+	return dataCh, nil
+}
@@ -5,159 +5,520 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"hodctl/pkg/secret"
+	stdio "io"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"time"
 
-	"github.com/gocarina/gocsv"
+	"golang.org/x/time/rate"
 )
 
-// Coin represents the cryptocurrency with its price in USD
+// Coin represents the cryptocurrency with its price in a quote currency (USD by default)
 type Coin struct {
-	ID     string  `json:"id" csv:"ID"`
-	Symbol string  `json:"symbol" csv:"Symbol"`
-	Name   string  `json:"name" csv:"Name"`
-	Price  float64 `json:"current_price" csv:"Price (USD)"`
+	ID        string  `json:"id" csv:"ID"`
+	Symbol    string  `json:"symbol" csv:"Symbol"`
+	Name      string  `json:"name" csv:"Name"`
+	Currency  string  `json:"-" csv:"Currency"` // Quote currency of the price and market cap (usd, eur), empty means usd
+	Price     float64 `json:"current_price" csv:"Price,Price (USD)"`
+	MarketCap float64 `json:"market_cap" csv:"Market Cap,Market Cap (USD)"`
+
+	LastUpdated  string `json:"last_updated" csv:"Last Updated"` // Time the provider last updated the price (RFC3339), empty if unknown
+	Source       string `json:"-" csv:"Source"`                  // Provider of the price
+	SnapshotTime string `json:"-" csv:"Snapshot Time"`           // Time the price was fetched (RFC3339)
 }
 
-// Currency2Values In-memory holder for currency values
-type Currency2Values map[string]float64
+// marketChart is the response of the CoinGecko market_chart endpoints, each point is [timestamp in ms, value]
+type marketChart struct {
+	Prices     [][]float64 `json:"prices"`
+	MarketCaps [][]float64 `json:"market_caps"`
+}
 
-// CoinGeckoClient represents the client that interacts with the CoinGecko API
+// CoinGeckoClient represents the client that interacts with the CoinGecko API, it implements HistoricalPriceSource
 type CoinGeckoClient struct {
-	APIKey string
-	Client *http.Client
+	APIKey            secret.Secret
+	Client            *http.Client
+	BaseURL           string         // Base URL of the API, the public Demo API or the Pro API by default
+	Pro               bool           // Whether the API key is a Pro API key
+	RequestsPerMinute int            // Rate limit of the plan, the Demo or Pro plan quota by default
+	Retry             RetryPolicy    // Retry policy for transient failures
+	VsCurrencies      []string       // Quote currencies of the prices, usd by default
+	Cache             *ResponseCache // On-disk cache of the responses, nil to always send the requests
+
+	limiter *rate.Limiter
 }
 
+// CoinGeckoOption configures the optional settings of a CoinGeckoClient
+type CoinGeckoOption func(*CoinGeckoClient)
+
 const (
-	throttlingWaitTime = 31 * time.Second // the Demo API has a rate limit of 30 requests per minute
-	perPage            = 250
-	apiBaseURL         = "https://api.coingecko.com/api/v3"
-	httpTimeout        = 10 * time.Second
+	perPage        = 250
+	idsPerRequest  = 100 // coin IDs of each /coins/markets request filtered by ids, to keep the URLs short
+	DemoAPIBaseURL = "https://api.coingecko.com/api/v3"
+	ProAPIBaseURL  = "https://pro-api.coingecko.com/api/v3"
+	httpTimeout    = 10 * time.Second
+
+	DemoRequestsPerMinute = 30  // the Demo API has a rate limit of 30 requests per minute
+	ProRequestsPerMinute  = 500 // the Analyst plan, the entry Pro plan, has a rate limit of 500 requests per minute
+
+	demoAPIKeyHeader = "x-cg-api-key"
+	proAPIKeyHeader  = "x-cg-pro-api-key"
 )
 
-// NewCoinGeckoClient creates a new client and verifies connection with ping
-func NewCoinGeckoClient(apiKey string) (*CoinGeckoClient, error) {
+// WithBaseURL overrides the base URL of the API, e.g. to use a local fake server in tests
+func WithBaseURL(baseURL string) CoinGeckoOption {
+	return func(c *CoinGeckoClient) {
+		c.BaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithProAPI uses the Pro API, its base URL and its API key header
+func WithProAPI() CoinGeckoOption {
+	return func(c *CoinGeckoClient) {
+		c.Pro = true
+	}
+}
+
+// WithRateLimit limits the requests sent to the API to the given quota
+func WithRateLimit(requestsPerMinute int) CoinGeckoOption {
+	return func(c *CoinGeckoClient) {
+		c.RequestsPerMinute = requestsPerMinute
+	}
+}
+
+// WithRetryPolicy overrides the DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) CoinGeckoOption {
+	return func(c *CoinGeckoClient) {
+		c.Retry = policy
+	}
+}
+
+// WithVsCurrencies fetches the prices in each of the given quote currencies (usd, eur...)
+func WithVsCurrencies(currencies ...string) CoinGeckoOption {
+	return func(c *CoinGeckoClient) {
+		c.VsCurrencies = NormalizeCurrencies(currencies)
+	}
+}
+
+// WithResponseCache caches the responses on disk, see ResponseCache
+func WithResponseCache(cache *ResponseCache) CoinGeckoOption {
+	return func(c *CoinGeckoClient) {
+		c.Cache = cache
+	}
+}
+
+// NewCoinGeckoClient creates a new client and verifies connection with ping, unless it is offline
+func NewCoinGeckoClient(ctx context.Context, apiKey secret.Secret, opts ...CoinGeckoOption) (*CoinGeckoClient, error) {
 	client := &CoinGeckoClient{
 		APIKey: apiKey,
 		Client: &http.Client{
 			Timeout: httpTimeout,
 		},
+		Retry: DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	if client.RequestsPerMinute <= 0 {
+		client.RequestsPerMinute = DemoRequestsPerMinute
+		if client.Pro {
+			client.RequestsPerMinute = ProRequestsPerMinute
+		}
+	}
+	// Token bucket refilled at the plan's quota, without bursts to spread the requests over the minute
+	client.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(client.RequestsPerMinute)), 1)
+
+	if len(client.VsCurrencies) == 0 {
+		client.VsCurrencies = []string{DefaultCurrency}
+	}
+
+	if client.BaseURL == "" {
+		client.BaseURL = DemoAPIBaseURL
+		if client.Pro {
+			client.BaseURL = ProAPIBaseURL
+		}
 	}
 
-	if err := client.Ping(); err != nil {
+	if client.Cache != nil && client.Cache.Offline {
+		log.Printf("Offline, the CoinGecko API responses are only served from the cache in %s\n", client.Cache.Dir)
+		return client, nil
+	}
+	if err := client.Ping(ctx); err != nil {
 		return nil, fmt.Errorf("failed to connect to CoinGecko API: %w", err)
 	}
 	return client, nil
 }
 
 // Ping method checks if CoinGecko API is reachable
-func (c *CoinGeckoClient) Ping() error {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/ping", apiBaseURL), nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+func (c *CoinGeckoClient) Ping(ctx context.Context) error {
+	var pong map[string]any
+	// The ping checks the API is reachable now, it is never served from the cache nor cached
+	if err := c.requestJSON(ctx, fmt.Sprintf("%s/ping", c.BaseURL), nil, &pong); err != nil {
+		return fmt.Errorf("failed to ping CoinGecko API: %w", err)
 	}
-	addHeaders(req, c.APIKey)
 
-	res, err := c.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to reach CoinGecko API: %w", err)
-	}
-	defer res.Body.Close()
+	return nil
+}
+
+// FetchCryptoData fetches all available cryptocurrencies with their current prices, in each quote currency
+func (c *CoinGeckoClient) FetchCryptoData(ctx context.Context) ([]Coin, error) {
+	var allCoins []Coin
+	err := c.FetchPages(ctx, nil, func(_ Page, coins []Coin) error {
+		allCoins = append(allCoins, coins...)
+		return nil
+	})
+	return allCoins, err
+}
+
+// FetchPages fetches the current prices page by page, in each quote currency, and calls fn as each page arrives.
+// The pages up to after (included) are skipped, so an interrupted fetch can resume from its last completed page.
+func (c *CoinGeckoClient) FetchPages(ctx context.Context, after *Page, fn func(page Page, coins []Coin) error) error {
+	fetched := 0
+	for i, currency := range c.VsCurrencies {
+		page := 1
+		if after != nil {
+			done, err := c.currencyDone(i, after)
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+			if after.Currency == currency {
+				page = after.Number + 1
+			}
+		}
+
+		for {
+			url := fmt.Sprintf("%s/coins/markets?vs_currency=%s&per_page=%d&page=%d", c.BaseURL, currency, perPage, page)
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to connect to CoinGecko API: %w", err)
+			var coins []Coin
+			if err := c.getJSON(ctx, url, &coins); err != nil {
+				return fmt.Errorf("failed to fetch cryptocurrency data, already fetched %d: %w", fetched, err)
+			}
+
+			for i := range coins {
+				coins[i].Currency = currency
+			}
+			fetched += len(coins)
+			last := len(coins) < perPage
+			if err := fn(Page{Currency: currency, Number: page, Last: last}, coins); err != nil {
+				return err
+			}
+			if last {
+				break
+			}
+
+			// Move to the next page
+			page++
+		}
 	}
 
 	return nil
 }
 
-// FetchCryptoData fetches all available cryptocurrencies with their current USD prices
-func (c *CoinGeckoClient) FetchCryptoData(ctx context.Context) ([]Coin, error) {
+// currencyDone tells whether all the pages of the i-th quote currency were completed before the given page
+func (c *CoinGeckoClient) currencyDone(i int, after *Page) (bool, error) {
+	position := slices.Index(c.VsCurrencies, after.Currency)
+	if position < 0 {
+		return false, fmt.Errorf("cannot resume after page %d of %s, it is not a quote currency of the fetch", after.Number, after.Currency)
+	}
+	return i < position || i == position && after.Last, nil
+}
+
+// FetchCoinList fetches the ID, symbol and name of every coin known by CoinGecko, without prices
+func (c *CoinGeckoClient) FetchCoinList(ctx context.Context) ([]Coin, error) {
+	var coins []Coin
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/coins/list", c.BaseURL), &coins); err != nil {
+		return nil, fmt.Errorf("failed to fetch the coin list: %w", err)
+	}
+	return coins, nil
+}
+
+// FetchCryptoDataForSymbols fetches the current prices of the coins with the given symbols, in each quote currency.
+// Every coin sharing one of the symbols is fetched so that the price table can resolve them.
+// It also returns the symbols without any coin, sorted.
+func (c *CoinGeckoClient) FetchCryptoDataForSymbols(ctx context.Context, symbols []string) ([]Coin, []string, error) {
+	list, err := c.FetchCoinList(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wanted := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		wanted[strings.ToLower(symbol)] = true
+	}
+	var ids []string
+	for _, coin := range list {
+		if wanted[strings.ToLower(coin.Symbol)] {
+			ids = append(ids, coin.ID)
+		}
+	}
+	log.Printf("Found %d coins for %d symbols in the CoinGecko coin list\n", len(ids), len(symbols))
+
 	var allCoins []Coin
-	page := 1
+	for _, currency := range c.VsCurrencies {
+		for start := 0; start < len(ids); start += idsPerRequest {
+			chunk := ids[start:min(start+idsPerRequest, len(ids))]
+			url := fmt.Sprintf("%s/coins/markets?vs_currency=%s&ids=%s&per_page=%d", c.BaseURL, currency, strings.Join(chunk, ","), perPage)
 
-	for {
-		url := fmt.Sprintf("%s/coins/markets?vs_currency=usd&per_page=%d&page=%d", apiBaseURL, perPage, page)
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+			var coins []Coin
+			if err := c.getJSON(ctx, url, &coins); err != nil {
+				return nil, nil, fmt.Errorf("failed to fetch cryptocurrency data, already fetched %d: %w", len(allCoins), err)
+			}
+			for i := range coins {
+				coins[i].Currency = currency
+			}
+			allCoins = append(allCoins, coins...)
 		}
-		addHeaders(req, c.APIKey)
+	}
+
+	return allCoins, unresolvedSymbols(symbols, allCoins), nil
+}
 
-		res, err := c.Client.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch crypto data: %w", err)
+// FetchPriceHistory fetches the daily price of the given coin IDs between from and to (inclusive), in each quote currency.
+// CoinGecko returns several data points per day for short ranges, only the first one of each UTC day is kept,
+// which matches the daily price CoinGecko reports at 00:00 UTC.
+func (c *CoinGeckoClient) FetchPriceHistory(ctx context.Context, ids []string, from, to time.Time) ([]HistoricalPrice, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("at least one coin ID is required to fetch the price history")
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("invalid date range: %s is before %s", to.Format(PriceDateLayout), from.Format(PriceDateLayout))
+	}
+
+	// Resolve the symbol and name of the requested coins
+	var coins []Coin
+	for start := 0; start < len(ids); start += idsPerRequest {
+		chunk := ids[start:min(start+idsPerRequest, len(ids))]
+		url := fmt.Sprintf("%s/coins/markets?vs_currency=%s&ids=%s&per_page=%d", c.BaseURL, c.VsCurrencies[0], strings.Join(chunk, ","), perPage)
+
+		var chunkCoins []Coin
+		if err := c.getJSON(ctx, url, &chunkCoins); err != nil {
+			return nil, fmt.Errorf("failed to fetch coins, already fetched %d: %w", len(coins), err)
 		}
-		defer res.Body.Close()
+		coins = append(coins, chunkCoins...)
+	}
+	if missing := missingIDs(ids, coins); len(missing) > 0 {
+		return nil, fmt.Errorf("CoinGecko does not know %d of the %d requested coins: %s", len(missing), len(ids), strings.Join(missing, ", "))
+	}
 
-		if res.StatusCode == http.StatusUnauthorized {
-			return nil, errors.New("unauthorized access to CoinGecko API")
+	var history []HistoricalPrice
+	for _, coin := range coins {
+		for _, currency := range c.VsCurrencies {
+			coin.Currency = currency
+			prices, err := c.fetchMarketChart(ctx, coin, from, to)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch %s price history for %s: %w", currency, coin.ID, err)
+			}
+			history = append(history, prices...)
 		}
+	}
+
+	return history, nil
+}
 
-		// Handle "Too Many Requests" by waiting and retrying
-		if res.StatusCode == http.StatusTooManyRequests {
-			log.Printf("Too many requests. Waiting for %v before retrying, already fetched %d", throttlingWaitTime, len(allCoins))
-			time.Sleep(throttlingWaitTime)
+// missingIDs returns the requested coin IDs without any fetched coin, sorted
+func missingIDs(ids []string, coins []Coin) []string {
+	fetched := make(map[string]bool, len(coins))
+	for _, coin := range coins {
+		fetched[coin.ID] = true
+	}
+	var missing []string
+	for _, id := range ids {
+		if !fetched[id] {
+			missing = append(missing, id)
+		}
+	}
+	slices.Sort(missing)
+	return slices.Compact(missing)
+}
+
+// fetchMarketChart fetches the market chart of a single coin in its quote currency and reduces it to one price per day.
+func (c *CoinGeckoClient) fetchMarketChart(ctx context.Context, coin Coin, from, to time.Time) ([]HistoricalPrice, error) {
+	// The range is inclusive, so include the whole last day
+	end := to.AddDate(0, 0, 1)
+	endpoint := fmt.Sprintf("%s/coins/%s/market_chart/range?vs_currency=%s&from=%d&to=%d",
+		c.BaseURL, url.PathEscape(coin.ID), coin.Currency, from.Unix(), end.Unix())
+
+	var chart marketChart
+	if err := c.getJSON(ctx, endpoint, &chart); err != nil {
+		return nil, err
+	}
+
+	var prices []HistoricalPrice
+	seen := make(map[string]bool)
+	for i, point := range chart.Prices {
+		if len(point) != 2 {
 			continue
 		}
+		ts := time.UnixMilli(int64(point[0])).UTC()
+		if ts.Before(from) || !ts.Before(end) {
+			continue
+		}
+		date := ts.Format(PriceDateLayout)
+		if seen[date] {
+			continue
+		}
+		seen[date] = true
 
-		if res.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to fetch cryptocurrency data: status code %d", res.StatusCode)
+		price := coin
+		price.Price = point[1]
+		price.MarketCap = 0
+		if i < len(chart.MarketCaps) && len(chart.MarketCaps[i]) == 2 {
+			price.MarketCap = chart.MarketCaps[i][1]
 		}
+		prices = append(prices, HistoricalPrice{Date: date, Coin: price})
+	}
+
+	return prices, nil
+}
 
-		var coins []Coin
-		err = json.NewDecoder(res.Body).Decode(&coins)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+// getJSON sends a GET request to the CoinGecko API and decodes the JSON response into out.
+// Requests are throttled by the rate limiter, "Too Many Requests", server errors and network errors
+// are retried with exponential backoff until the retry policy gives up or the context is cancelled.
+// Fresh cached responses are served without any request.
+func (c *CoinGeckoClient) getJSON(ctx context.Context, url string, out any) error {
+	return c.requestJSON(ctx, url, c.Cache, out)
+}
+
+// requestJSON is getJSON with the given response cache, none when nil
+func (c *CoinGeckoClient) requestJSON(ctx context.Context, url string, cache *ResponseCache, out any) error {
+	var cached *cachedResponse
+	if cache != nil {
+		var err error
+		if cached, err = cache.load(url); err != nil {
+			log.Printf("Ignoring the cached response of %s: %v\n", url, err)
+		}
+		if cached != nil && cache.fresh(cached, time.Now()) {
+			return decodeBody(cached.Body, out)
 		}
+		if cache.Offline {
+			return fmt.Errorf("offline: %w: %s", errNotCached, url)
+		}
+	}
 
-		allCoins = append(allCoins, coins...)
-		if len(coins) < perPage {
-			break
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.Wait(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to wait for the rate limiter: %w", err)
 		}
 
-		// Move to the next page
-		page++
-	}
+		err := c.doGetJSON(ctx, url, cache, cached, out)
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) {
+			return err
+		}
+		if attempt >= c.Retry.MaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
 
-	return allCoins, nil
+		// Honor the Retry-After header when the server sends one, unless it asks to wait longer than the maximum delay
+		delay := c.Retry.Backoff(attempt)
+		if retryable.retryAfter > c.Retry.MaxDelay {
+			return fmt.Errorf("giving up after %d attempts, the server asks to retry in %v (Retry-After), more than the maximum delay of %v: %w",
+				attempt, retryable.retryAfter, c.Retry.MaxDelay, err)
+		}
+		if retryable.retryAfter > 0 {
+			delay = retryable.retryAfter
+		}
+		log.Printf("Request to CoinGecko API failed (attempt %d/%d): %v. Retrying in %v", attempt, c.Retry.MaxAttempts, err, delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
 }
 
-// Save cryptocurrency data as CSV using the provided io.Writer
-func (c *CoinGeckoClient) Save(w io.Writer) error {
-	coins, err := c.FetchCryptoData(context.Background()) // Add context to allow cancellation
+// doGetJSON sends a single GET request and decodes the JSON response into out.
+// With a cached response, the request is conditional and the cached response is served if it did not change.
+// The response is stored in the cache unless it is nil. Errors worth retrying are returned as *retryableError.
+func (c *CoinGeckoClient) doGetJSON(ctx context.Context, url string, cache *ResponseCache, cached *cachedResponse, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	c.addHeaders(req)
+	addConditionalHeaders(req, cached)
+
+	res, err := c.Client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to fetch crypto data: %w", err)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &retryableError{err: fmt.Errorf("failed to reach CoinGecko API: %w", err)}
 	}
+	defer res.Body.Close()
 
-	log.Printf("Fetched %d cryptocurrencies\n", len(coins))
-	if err := gocsv.Marshal(&coins, w); err != nil {
-		return fmt.Errorf("failed to write CSV: %w", err)
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return errors.New("unauthorized access to CoinGecko API: status code 401")
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError:
+		return &retryableError{
+			err:        fmt.Errorf("unexpected status code %d", res.StatusCode),
+			retryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		}
+	case res.StatusCode == http.StatusNotModified && cached != nil:
+		cached.StoredAt = time.Now()
+		storeResponse(cache, cached)
+		return decodeBody(cached.Body, out)
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
 	}
 
+	body, err := stdio.ReadAll(res.Body)
+	if err != nil {
+		return &retryableError{err: fmt.Errorf("failed to read response: %w", err)}
+	}
+	if err := decodeBody(body, out); err != nil {
+		return err
+	}
+	if cache != nil {
+		storeResponse(cache, &cachedResponse{
+			URL:          url,
+			StoredAt:     time.Now(),
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+			Body:         body,
+		})
+	}
 	return nil
 }
 
-// ReadCurrencyValues reads the currency values from the provided io.Reader
-func ReadCurrencyValues(reader io.Reader) (Currency2Values, error) {
-	var coins []Coin
-
-	if err := gocsv.Unmarshal(reader, &coins); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal CSV: %w", err)
+// storeResponse caches the response, a response that cannot be cached is only logged
+func storeResponse(cache *ResponseCache, response *cachedResponse) {
+	if err := cache.store(response); err != nil {
+		log.Printf("Failed to cache the response of %s: %v\n", response.URL, err)
 	}
+}
 
-	currencyMap := make(Currency2Values)
-	for _, coin := range coins {
-		currencyMap[strings.ToUpper(coin.Symbol)] = coin.Price
+func decodeBody(body []byte, out any) error {
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
 	}
+	return nil
+}
 
-	return currencyMap, nil
+// Name identifies CoinGecko as the provider of the prices
+func (c *CoinGeckoClient) Name() string {
+	return "coingecko"
 }
 
-func addHeaders(req *http.Request, apiKey string) {
+func (c *CoinGeckoClient) addHeaders(req *http.Request) {
 	req.Header.Add("accept", "application/json")
-	req.Header.Add("x-cg-api-key", apiKey)
+	if c.Pro {
+		req.Header.Add(proAPIKeyHeader, c.APIKey.Reveal())
+	} else {
+		req.Header.Add(demoAPIKeyHeader, c.APIKey.Reveal())
+	}
 }
@@ -0,0 +1,326 @@
+package io_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+	"hodctl/pkg/io/coingeckotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAPIKey = "CG-test-key"
+
+// testOptions configures the client to use the fake server without the plan's rate limit
+func testOptions(server *coingeckotest.Server) []io.CoinGeckoOption {
+	return []io.CoinGeckoOption{io.WithBaseURL(server.URL), io.WithRateLimit(60_000)}
+}
+
+// generateCoins generates n fake coins, enough coins force the client to fetch several pages
+func generateCoins(n int) []coingeckotest.Coin {
+	coins := make([]coingeckotest.Coin, n)
+	for i := range coins {
+		coins[i] = coingeckotest.Coin{
+			ID:           fmt.Sprintf("coin-%d", i),
+			Symbol:       fmt.Sprintf("c%d", i),
+			Name:         fmt.Sprintf("Coin %d", i),
+			CurrentPrice: float64(i) + 0.5,
+			LastUpdated:  "2024-04-15T10:00:00.000Z",
+		}
+	}
+	return coins
+}
+
+func TestCoinGeckoClient_FetchCryptoData(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(300))
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, testOptions(server)...)
+	assert.NoError(t, err)
+
+	coins, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, coins, 300)
+	assert.Equal(t, io.Coin{ID: "coin-299", Symbol: "c299", Name: "Coin 299", Currency: "usd", Price: 299.5, LastUpdated: "2024-04-15T10:00:00.000Z"}, coins[299])
+
+	// ping + 2 pages
+	requests := server.Requests()
+	assert.Len(t, requests, 3)
+	for _, req := range requests {
+		assert.Equal(t, testAPIKey, req.Header.Get("x-cg-api-key"))
+		assert.Empty(t, req.Header.Get("x-cg-pro-api-key"))
+	}
+}
+
+func TestCoinGeckoClient_ProAPI(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	server.Pro = true
+	defer server.Close()
+
+	// The Demo API header is rejected by the Pro API
+	_, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, testOptions(server)...)
+	assert.Error(t, err)
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithProAPI())...)
+	assert.NoError(t, err)
+
+	coins, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, coins, 10)
+}
+
+func TestCoinGeckoClient_DefaultBaseURL(t *testing.T) {
+	client := &io.CoinGeckoClient{}
+	io.WithProAPI()(client)
+	assert.True(t, client.Pro)
+
+	io.WithBaseURL("http://localhost:8080/api/v3/")(client)
+	assert.Equal(t, "http://localhost:8080/api/v3", client.BaseURL)
+}
+
+func TestCoinGeckoClient_Unauthorized(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	_, err := io.NewCoinGeckoClient(context.Background(), "CG-wrong-key", testOptions(server)...)
+	assert.ErrorContains(t, err, "status code 401")
+}
+
+func TestCoinGeckoClient_PingCanceled(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := io.NewCoinGeckoClient(ctx, testAPIKey, testOptions(server)...)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, server.Requests())
+}
+
+func TestCoinGeckoClient_FetchPriceHistory(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, []coingeckotest.Coin{
+		{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", CurrentPrice: 65000},
+	})
+	defer server.Close()
+
+	day := func(d, h int) float64 {
+		return float64(time.Date(2024, 4, d, h, 0, 0, 0, time.UTC).UnixMilli())
+	}
+	server.History["bitcoin"] = [][]float64{
+		{day(14, 0), 63000},
+		{day(14, 12), 63500}, // only the first price of the day is kept
+		{day(15, 0), 65000},
+		{day(16, 0), 66000}, // out of range
+	}
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, testOptions(server)...)
+	assert.NoError(t, err)
+
+	from := time.Date(2024, 4, 14, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+	history, err := client.FetchPriceHistory(context.Background(), []string{"bitcoin"}, from, to)
+	assert.NoError(t, err)
+
+	bitcoin := io.Coin{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", Currency: "usd"}
+	expected := []io.HistoricalPrice{
+		{Date: "2024-04-14", Coin: bitcoin},
+		{Date: "2024-04-15", Coin: bitcoin},
+	}
+	expected[0].Price = 63000
+	expected[1].Price = 65000
+	assert.Equal(t, expected, history)
+}
+
+func TestCoinGeckoClient_FetchPriceHistory_ManyIDs(t *testing.T) {
+	coins := generateCoins(150)
+	server := coingeckotest.NewServer(testAPIKey, coins)
+	defer server.Close()
+
+	var ids []string
+	for _, coin := range coins {
+		ids = append(ids, coin.ID)
+		server.History[coin.ID] = [][]float64{{float64(time.Date(2024, 4, 14, 0, 0, 0, 0, time.UTC).UnixMilli()), coin.CurrentPrice}}
+	}
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, testOptions(server)...)
+	assert.NoError(t, err)
+
+	day := time.Date(2024, 4, 14, 0, 0, 0, 0, time.UTC)
+	history, err := client.FetchPriceHistory(context.Background(), ids, day, day)
+	assert.NoError(t, err)
+	assert.Len(t, history, 150)
+
+	// The coins are resolved 100 IDs at a time
+	markets := 0
+	for _, req := range server.Requests() {
+		if req.URL.Path == "/coins/markets" {
+			markets++
+			assert.LessOrEqual(t, len(strings.Split(req.URL.Query().Get("ids"), ",")), 100)
+		}
+	}
+	assert.Equal(t, 2, markets)
+
+	_, err = client.FetchPriceHistory(context.Background(), []string{"coin-1", "unknown-coin"}, day, day)
+	assert.ErrorContains(t, err, "CoinGecko does not know 1 of the 2 requested coins: unknown-coin")
+}
+
+func TestCoinGeckoClient_FetchCryptoDataForSymbols(t *testing.T) {
+	coins := append(generateCoins(300),
+		coingeckotest.Coin{ID: "ethereum", Symbol: "eth", Name: "Ethereum", CurrentPrice: 3000},
+		coingeckotest.Coin{ID: "bridged-ether", Symbol: "eth", Name: "Bridged Ether", CurrentPrice: 2990},
+	)
+	server := coingeckotest.NewServer(testAPIKey, coins)
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, testOptions(server)...)
+	assert.NoError(t, err)
+
+	fetched, unresolved, err := client.FetchCryptoDataForSymbols(context.Background(), []string{"ETH", "C7", "XYZ"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"XYZ"}, unresolved)
+	var ids []string
+	for _, coin := range fetched {
+		ids = append(ids, coin.ID)
+	}
+	assert.ElementsMatch(t, []string{"coin-7", "ethereum", "bridged-ether"}, ids)
+
+	// ping, coin list and a single page filtered by ids
+	requests := server.Requests()
+	assert.Len(t, requests, 3)
+	assert.Equal(t, "/coins/list", requests[1].URL.Path)
+	assert.Equal(t, "coin-7,ethereum,bridged-ether", requests[2].URL.Query().Get("ids"))
+}
+
+func TestCoinGeckoClient_VsCurrencies(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, []coingeckotest.Coin{
+		{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", CurrentPrice: 65000},
+	})
+	server.Rates["eur"] = 0.9
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithVsCurrencies("USD", "eur", "usd"))...)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"usd", "eur"}, client.VsCurrencies)
+
+	coins, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []io.Coin{
+		{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", Currency: "usd", Price: 65000},
+		{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", Currency: "eur", Price: 58500},
+	}, coins)
+}
+
+// fastRetries retries quickly to keep the tests fast
+var fastRetries = io.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+func TestCoinGeckoClient_RetryTransientFailures(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRetryPolicy(fastRetries))...)
+	assert.NoError(t, err)
+
+	server.Fail(1, http.StatusTooManyRequests, "")
+	server.Fail(1, http.StatusServiceUnavailable, "")
+	coins, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, coins, 10)
+}
+
+func TestCoinGeckoClient_RetryAfter(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	retries := io.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Second}
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRetryPolicy(retries))...)
+	assert.NoError(t, err)
+
+	server.Fail(1, http.StatusTooManyRequests, "1")
+	start := time.Now()
+	_, err = client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// A Retry-After beyond the maximum delay fails at once instead of waiting a day
+	server.Fail(1, http.StatusTooManyRequests, "86400")
+	start = time.Now()
+	_, err = client.FetchCryptoData(context.Background())
+	assert.ErrorContains(t, err, "the server asks to retry in 24h0m0s (Retry-After), more than the maximum delay of 2s")
+	assert.ErrorContains(t, err, "status code 429")
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCoinGeckoClient_MaxAttempts(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRetryPolicy(fastRetries))...)
+	assert.NoError(t, err)
+
+	server.Fail(fastRetries.MaxAttempts, http.StatusInternalServerError, "")
+	_, err = client.FetchCryptoData(context.Background())
+	assert.ErrorContains(t, err, "giving up after 3 attempts")
+
+	// ping + 3 attempts
+	assert.Len(t, server.Requests(), 4)
+}
+
+func TestCoinGeckoClient_ClientErrorsAreNotRetried(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRetryPolicy(fastRetries))...)
+	assert.NoError(t, err)
+
+	server.Fail(1, http.StatusBadRequest, "")
+	_, err = client.FetchCryptoData(context.Background())
+	assert.ErrorContains(t, err, "status code 400")
+	assert.Len(t, server.Requests(), 2)
+}
+
+func TestCoinGeckoClient_Cancellation(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	slowRetries := io.RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRetryPolicy(slowRetries))...)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	server.Fail(1, http.StatusServiceUnavailable, "")
+	_, err = client.FetchCryptoData(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCoinGeckoClient_RateLimit(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	// One request every 50ms
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRateLimit(1200))...)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, err := client.FetchCryptoData(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := io.RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	for attempt, maxDelay := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 8: 10 * time.Second} {
+		delay := policy.Backoff(attempt)
+		assert.GreaterOrEqual(t, delay, maxDelay/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, delay, maxDelay, "attempt %d", attempt)
+	}
+}
@@ -0,0 +1,244 @@
+// Package coingeckotest provides a local fake of the CoinGecko API for tests.
+package coingeckotest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	demoAPIKeyHeader = "x-cg-api-key"
+	proAPIKeyHeader  = "x-cg-pro-api-key"
+	defaultPerPage   = 100
+)
+
+// Coin is a coin as returned by the /coins/markets endpoint
+type Coin struct {
+	ID           string  `json:"id"`
+	Symbol       string  `json:"symbol"`
+	Name         string  `json:"name"`
+	CurrentPrice float64 `json:"current_price"`
+	MarketCap    float64 `json:"market_cap"`
+	LastUpdated  string  `json:"last_updated,omitempty"`
+}
+
+// Server is a fake CoinGecko API serving /ping, /coins/list, /coins/markets and /coins/{id}/market_chart/range
+type Server struct {
+	*httptest.Server
+
+	APIKey  string                 // Expected API key, any key is accepted when empty
+	Pro     bool                   // Whether the API key is expected in the Pro API header
+	Coins   []Coin                 // Coins served by /coins/markets, prices in USD
+	History map[string][][]float64 // Price points ([timestamp in ms, USD price]) served by /coins/{id}/market_chart/range
+	Rates   map[string]float64     // Conversion rate from USD to the other supported vs_currency (e.g. eur)
+
+	mu       sync.Mutex
+	requests []*http.Request
+	failures []*failure // Injected responses of the next requests, nil serves the request normally
+}
+
+// failure is an injected error response
+type failure struct {
+	status     int
+	retryAfter string
+}
+
+// NewServer starts a fake CoinGecko API serving the given coins. The caller must Close it.
+func NewServer(apiKey string, coins []Coin) *Server {
+	s := &Server{
+		APIKey:  apiKey,
+		Coins:   coins,
+		History: make(map[string][][]float64),
+		Rates:   map[string]float64{"usd": 1},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Fail makes the next n requests fail with the given status code, sending the Retry-After header when not empty
+func (s *Server) Fail(n int, status int, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, &failure{status: status, retryAfter: retryAfter})
+	}
+}
+
+// FailAfter serves the next ok requests normally, then makes the following n requests fail with the given status code
+func (s *Server) FailAfter(ok int, n int, status int) {
+	s.mu.Lock()
+	for i := 0; i < ok; i++ {
+		s.failures = append(s.failures, nil)
+	}
+	s.mu.Unlock()
+	s.Fail(n, status, "")
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	var injected *failure
+	if len(s.failures) > 0 {
+		injected = s.failures[0]
+		s.failures = s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if injected != nil {
+		if injected.retryAfter != "" {
+			w.Header().Set("Retry-After", injected.retryAfter)
+		}
+		http.Error(w, `{"status":{"error_message":"injected failure"}}`, injected.status)
+		return
+	}
+
+	if !s.authorized(r) {
+		http.Error(w, `{"status":{"error_code":401,"error_message":"invalid API key"}}`, http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/ping":
+		writeJSON(w, r, map[string]string{"gecko_says": "(V3) To the Moon!"})
+	case path == "/coins/list":
+		s.handleList(w, r)
+	case path == "/coins/markets":
+		s.handleMarkets(w, r)
+	case strings.HasPrefix(path, "/coins/") && strings.HasSuffix(path, "/market_chart/range"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/coins/"), "/market_chart/range")
+		s.handleMarketChart(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.APIKey == "" {
+		return true
+	}
+	header := demoAPIKeyHeader
+	if s.Pro {
+		header = proAPIKeyHeader
+	}
+	return r.Header.Get(header) == s.APIKey
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	type listedCoin struct {
+		ID     string `json:"id"`
+		Symbol string `json:"symbol"`
+		Name   string `json:"name"`
+	}
+	list := make([]listedCoin, len(s.Coins))
+	for i, coin := range s.Coins {
+		list[i] = listedCoin{ID: coin.ID, Symbol: coin.Symbol, Name: coin.Name}
+	}
+	writeJSON(w, r, list)
+}
+
+func (s *Server) handleMarkets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	rate, ok := s.rate(query.Get("vs_currency"))
+	if !ok {
+		http.Error(w, `{"error":"invalid vs_currency"}`, http.StatusBadRequest)
+		return
+	}
+
+	coins := s.Coins
+	if ids := query.Get("ids"); ids != "" {
+		wanted := make(map[string]bool)
+		for _, id := range strings.Split(ids, ",") {
+			wanted[id] = true
+		}
+		coins = nil
+		for _, coin := range s.Coins {
+			if wanted[coin.ID] {
+				coins = append(coins, coin)
+			}
+		}
+	}
+
+	perPage := intParam(query.Get("per_page"), defaultPerPage)
+	page := intParam(query.Get("page"), 1)
+	start := min((page-1)*perPage, len(coins))
+	end := min(start+perPage, len(coins))
+
+	converted := make([]Coin, 0, end-start)
+	for _, coin := range coins[start:end] {
+		coin.CurrentPrice *= rate
+		coin.MarketCap *= rate
+		converted = append(converted, coin)
+	}
+	writeJSON(w, r, converted)
+}
+
+func (s *Server) handleMarketChart(w http.ResponseWriter, r *http.Request, id string) {
+	points, exists := s.History[id]
+	if !exists {
+		http.Error(w, `{"error":"coin not found"}`, http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	rate, ok := s.rate(query.Get("vs_currency"))
+	if !ok {
+		http.Error(w, `{"error":"invalid vs_currency"}`, http.StatusBadRequest)
+		return
+	}
+	from := int64(intParam(query.Get("from"), 0)) * 1000
+	to := int64(intParam(query.Get("to"), 0)) * 1000
+
+	prices := [][]float64{}
+	for _, point := range points {
+		if ts := int64(point[0]); ts >= from && ts <= to {
+			prices = append(prices, []float64{point[0], point[1] * rate})
+		}
+	}
+	writeJSON(w, r, map[string][][]float64{"prices": prices})
+}
+
+func (s *Server) rate(currency string) (float64, bool) {
+	rate, ok := s.Rates[currency]
+	return rate, ok
+}
+
+func intParam(value string, defaultValue int) int {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return defaultValue
+	}
+	return parsed
+}
+
+// writeJSON writes v as JSON with an ETag, or only "Not Modified" if the request already has the same ETag
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,126 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of a file read by OpenReader
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+// compressionExtensions are the extensions of the compressed files
+var compressionExtensions = map[string]Compression{
+	".gz":   CompressionGzip,
+	".gzip": CompressionGzip,
+	".zst":  CompressionZstd,
+	".zstd": CompressionZstd,
+	".bz2":  CompressionBzip2,
+}
+
+// compressionMagics are the first bytes of the compressed files, for the files without compression extension
+var compressionMagics = []struct {
+	magic       []byte
+	compression Compression
+}{
+	{[]byte{0x1f, 0x8b}, CompressionGzip},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, CompressionZstd},
+	{[]byte("BZh"), CompressionBzip2},
+}
+
+// OpenReader opens a file (gs, s3, local file system) for reading, decompressing it while streaming
+// when it is compressed with gzip, zstd or bzip2, detected by its extension or its first bytes.
+func OpenReader(p string) (io.ReadCloser, error) {
+	file, err := Open(p)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := Decompress(file, p)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Decompress returns a reader decompressing the file read by reader, see OpenReader.
+// Closing the returned reader closes the file reader.
+func Decompress(reader io.ReadCloser, p string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	compression, ok := compressionExtensions[strings.ToLower(path.Ext(p))]
+	if !ok {
+		compression = detectCompression(buffered)
+	}
+
+	var decoder io.ReadCloser
+	switch compression {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip file %s: %w", p, err)
+		}
+		decoder = gzipReader
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd file %s: %w", p, err)
+		}
+		decoder = zstdReader.IOReadCloser()
+	case CompressionBzip2:
+		decoder = io.NopCloser(bzip2.NewReader(buffered))
+	default:
+		return &decompressReader{Reader: buffered, file: reader}, nil
+	}
+	return &decompressReader{Reader: decoder, decoder: decoder, file: reader}, nil
+}
+
+// detectCompression returns the compression of the first bytes of the reader, without consuming them
+func detectCompression(reader *bufio.Reader) Compression {
+	// A shorter file is not compressed, any read error is left to the first read
+	head, _ := reader.Peek(4)
+	for _, m := range compressionMagics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.compression
+		}
+	}
+	return CompressionNone
+}
+
+// TrimCompressionExt returns the path without its compression extension, e.g. events.csv for events.csv.gz
+func TrimCompressionExt(p string) string {
+	if _, ok := compressionExtensions[strings.ToLower(path.Ext(p))]; ok {
+		return strings.TrimSuffix(p, path.Ext(p))
+	}
+	return p
+}
+
+// decompressReader reads the decompressed file, and closes both the decoder and the file
+type decompressReader struct {
+	io.Reader
+	decoder io.Closer
+	file    io.Closer
+}
+
+func (r *decompressReader) Close() error {
+	if r.decoder != nil {
+		if err := r.decoder.Close(); err != nil {
+			_ = r.file.Close()
+			return err
+		}
+	}
+	return r.file.Close()
+}
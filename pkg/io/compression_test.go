@@ -0,0 +1,90 @@
+package io
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const compressionContent = "ts,project_id\n2024-04-15,4974\n"
+
+// bzip2Content is compressionContent compressed with bzip2, the standard library only decompresses it
+var bzip2Content = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x5c, 0x94, 0x90, 0xce, 0x00, 0x00, 0x0e, 0xdb, 0x80, 0x00,
+	0x10, 0x00, 0x06, 0x76, 0xa0, 0x00, 0x00, 0x8e, 0x30, 0xdc, 0x00, 0x20, 0x00, 0x31, 0x40, 0xd3, 0x43, 0x23, 0x26, 0x21,
+	0x10, 0x0c, 0x20, 0x68, 0x7a, 0x98, 0x34, 0xf4, 0x89, 0x4c, 0xac, 0x8d, 0x49, 0x2b, 0x5d, 0x06, 0xdf, 0x8f, 0xd0, 0xc1,
+	0x03, 0xfc, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x41, 0x72, 0x52, 0x43, 0x38,
+}
+
+func gzipContent(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(compressionContent))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func zstdContent(t *testing.T) []byte {
+	encoder, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(compressionContent), nil)
+}
+
+func TestOpenReader(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"transactions.csv", []byte(compressionContent)},
+		{"empty.csv", nil},
+		{"transactions.csv.gz", gzipContent(t)},
+		{"transactions.csv.GZ", gzipContent(t)},
+		{"transactions.csv.zst", zstdContent(t)},
+		{"transactions.csv.bz2", bzip2Content},
+		// Detected by their first bytes
+		{"gzip.csv", gzipContent(t)},
+		{"zstd.csv", zstdContent(t)},
+		{"bzip2", bzip2Content},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			assert.NoError(t, os.WriteFile(path, tt.content, 0o600))
+
+			reader, err := OpenReader(path)
+			assert.NoError(t, err)
+			content, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.NoError(t, reader.Close())
+			if tt.content == nil {
+				assert.Empty(t, content)
+			} else {
+				assert.Equal(t, compressionContent, string(content))
+			}
+		})
+	}
+}
+
+func TestOpenReader_Error(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.csv.gz")
+	assert.NoError(t, os.WriteFile(path, []byte(compressionContent), 0o600))
+
+	_, err := OpenReader(path)
+	assert.ErrorContains(t, err, "failed to read gzip file")
+}
+
+func TestTrimCompressionExt(t *testing.T) {
+	assert.Equal(t, "gs://bucket/events.csv", TrimCompressionExt("gs://bucket/events.csv.gz"))
+	assert.Equal(t, "prices.json", TrimCompressionExt("prices.json.zst"))
+	assert.Equal(t, "events.csv", TrimCompressionExt("events.csv"))
+	assert.True(t, IsStaticPriceFile("prices.yaml.bz2"))
+}
@@ -0,0 +1,42 @@
+package io
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gocarina/gocsv"
+)
+
+// CSVPriceWriter writes prices as the CSV read by ReadPriceTable, with the header before the first prices
+type CSVPriceWriter struct {
+	writer        io.Writer
+	headerWritten bool
+}
+
+// NewCSVPriceWriter creates a CSVPriceWriter writing to the given io.Writer, closed with the price writer if it is an io.Closer
+func NewCSVPriceWriter(w io.Writer) *CSVPriceWriter {
+	return &CSVPriceWriter{writer: w}
+}
+
+// WritePrices writes the prices as CSV rows
+func (w *CSVPriceWriter) WritePrices(prices []HistoricalPrice) (int, error) {
+	var err error
+	if w.headerWritten {
+		err = gocsv.MarshalWithoutHeaders(&prices, w.writer)
+	} else {
+		err = gocsv.Marshal(&prices, w.writer)
+		w.headerWritten = true
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return len(prices), nil
+}
+
+// Close closes the underlying writer
+func (w *CSVPriceWriter) Close() error {
+	if closer, ok := w.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
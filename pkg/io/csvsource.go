@@ -1,9 +1,8 @@
 package io
 
 import (
-	"fmt"
+	"context"
 	"io"
-	"log"
 
 	"github.com/gocarina/gocsv"
 )
@@ -14,51 +13,44 @@ const (
 
 type MicroBatch struct {
 	Data []RawTransaction
+	File string // Path of the file of the transactions, empty for a single reader
 	Err  error
 }
 
+// RawTransaction is a row of the transactions, the csv tags are the columns of every format, columns missing from the file are left empty
 type RawTransaction struct {
-	Timestamp string `csv:"ts"`
-	ProjectID string `csv:"project_id"`
-	Event     string `csv:"event"`
-	Props     string `csv:"props"`
-	Nums      string `csv:"nums"`
+	Timestamp        string `csv:"ts"`
+	ProjectID        string `csv:"project_id"`
+	Event            string `csv:"event"`
+	Props            string `csv:"props"`
+	Nums             string `csv:"nums"`
+	App              string `csv:"app"`
+	Source           string `csv:"source"`
+	Ident            string `csv:"ident"`
+	UserID           string `csv:"user_id"`
+	SessionID        string `csv:"session_id"`
+	Country          string `csv:"country"`
+	DeviceType       string `csv:"device_type"`
+	DeviceOS         string `csv:"device_os"`
+	DeviceOSVer      string `csv:"device_os_ver"`
+	DeviceBrowser    string `csv:"device_browser"`
+	DeviceBrowserVer string `csv:"device_browser_ver"`
 }
 
 // ReadCSV reads the CSV data in batches and returns a channel that emits MicroBatch structs.
 // The function also returns an error if any occurs during reading.
-func ReadCSV(reader io.Reader, microBatchSize int) (<-chan MicroBatch, error) {
-	dataCh := make(chan MicroBatch, DefaultChannelBufferSize)
-
-	go func() {
-		defer close(dataCh)
-
-		var batch []RawTransaction
-		numTransactions := 0
-
-		err := gocsv.UnmarshalToCallbackWithError(reader, func(rt RawTransaction) error {
-			numTransactions++
-			batch = append(batch, rt)
-
-			// If the batch size is reached, send the batch and reset
-			if len(batch) >= microBatchSize {
-				dataCh <- MicroBatch{Data: batch}
-				batch = make([]RawTransaction, 0, microBatchSize) // Reset the batch with capacity
-			}
-			return nil
-		})
+// Reading stops and the channel is closed as soon as the context is done.
+func ReadCSV(ctx context.Context, reader io.Reader, microBatchSize int) (<-chan MicroBatch, error) {
+	return ReadCSVFrom(ctx, reader, microBatchSize, 0)
+}
 
-		if err != nil {
-			dataCh <- MicroBatch{
-				Err: fmt.Errorf("an error occured while reading the CSV, error: %v", err),
-			}
-			fmt.Printf("CSV Parse Error in line %d : %v\n", numTransactions, err)
-		}
-		if len(batch) > 0 {
-			dataCh <- MicroBatch{Data: batch}
-		}
+// ReadCSVFrom reads the CSV data like ReadCSV, but skips the first skip rows, e.g. already processed before a checkpoint
+func ReadCSVFrom(ctx context.Context, reader io.Reader, microBatchSize int, skip int64) (<-chan MicroBatch, error) {
+	sender, dataCh := newBatchSender(ctx, microBatchSize, skip)
 
-		log.Printf("CSV read done, source channel closed after reading %d transactions\n", numTransactions)
+	go func() {
+		err := gocsv.UnmarshalToCallbackWithError(reader, sender.add)
+		sender.close(FormatCSV, err)
 	}()
 
 	return dataCh, nil
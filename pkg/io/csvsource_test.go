@@ -1,6 +1,7 @@
 package io
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -14,7 +15,7 @@ func TestReadCSV_ValidData(t *testing.T) {
 `
 	println("test")
 	reader := strings.NewReader(csvData)
-	ch, err := ReadCSV(reader, 10)
+	ch, err := ReadCSV(context.Background(), reader, 10)
 	println("test")
 	assert.NoError(t, err)
 
@@ -28,9 +29,51 @@ func TestReadCSV_ValidData(t *testing.T) {
 	}
 
 	expected := []RawTransaction{
-		{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "4974", Event: "BUY_ITEMS", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6136203411678249"}`},
-		{Timestamp: "2024-04-15 02:26:37.134", ProjectID: "0", Event: "BUY_ITEMS", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6136203411678249"}`},
+		{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "4974", Event: "BUY_ITEMS", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6136203411678249"}`,
+			App: "seq-market", Ident: "1", UserID: "0896ae95dcaeee38e83fa5c43bef99780d7b2be23bcab36214", SessionID: "5d8afd8fec2fbf3e",
+			Country: "DE", DeviceType: "desktop", DeviceOS: "linux", DeviceOSVer: "x86_64", DeviceBrowser: "chrome", DeviceBrowserVer: "122.0.0.0"},
+		{Timestamp: "2024-04-15 02:26:37.134", ProjectID: "0", Event: "BUY_ITEMS", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6136203411678249"}`,
+			App: "seq-market", Ident: "1", UserID: "0896ae95dcaeee38e83fa5c43bef99780d7b2be23bcab36214", SessionID: "5d8afd8fec2fbf3e",
+			Country: "DE", DeviceType: "desktop", DeviceOS: "linux", DeviceOSVer: "x86_64", DeviceBrowser: "chrome", DeviceBrowserVer: "122.0.0.0"},
 	}
 
 	assert.Equal(t, expected, transactions)
 }
+
+func TestReadCSV_Canceled(t *testing.T) {
+	csvData := "ts,project_id,event,props,nums\n"
+	for i := 0; i < 10_000; i++ {
+		csvData += `2024-04-15 02:15:07.167,4974,BUY_ITEMS,"{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""0.6""}"` + "\n"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := ReadCSV(ctx, strings.NewReader(csvData), 1)
+	assert.NoError(t, err)
+
+	// Nothing reads the batches once canceled, the channel must still be closed
+	<-ch
+	cancel()
+	read := 1
+	for range ch {
+		read++
+	}
+	assert.Less(t, read, 10_000)
+}
+
+func TestReadCSVFrom(t *testing.T) {
+	csvData := "ts,project_id,event,props,nums\n"
+	for _, project := range []string{"1", "2", "3", "4", "5"} {
+		csvData += "2024-04-15 02:15:07.167," + project + ",BUY_ITEMS,{},{}\n"
+	}
+
+	ch, err := ReadCSVFrom(context.Background(), strings.NewReader(csvData), 2, 3)
+	assert.NoError(t, err)
+
+	var projects []string
+	for microBatch := range ch {
+		for _, transaction := range microBatch.Data {
+			projects = append(projects, transaction.ProjectID)
+		}
+	}
+	assert.Equal(t, []string{"4", "5"}, projects)
+}
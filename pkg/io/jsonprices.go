@@ -0,0 +1,101 @@
+package io
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode"
+)
+
+// jsonPrice is a price as written by the JSON price sink of fetch, in an array (.json) or as JSON lines (.jsonl, .ndjson)
+type jsonPrice struct {
+	Date         string     `json:"date"`
+	ID           string     `json:"id"`
+	Symbol       string     `json:"symbol"`
+	Name         string     `json:"name"`
+	Currency     string     `json:"currency"`
+	Price        float64    `json:"price"`
+	MarketCap    float64    `json:"market_cap"`
+	LastUpdated  *time.Time `json:"last_updated"`
+	Source       string     `json:"source"`
+	SnapshotTime time.Time  `json:"snapshot_time"`
+}
+
+// historicalPrice converts the JSON price into the price rows of the CSV saved by fetch
+func (p jsonPrice) historicalPrice() HistoricalPrice {
+	price := HistoricalPrice{Date: p.Date, Coin: Coin{
+		ID:        p.ID,
+		Symbol:    p.Symbol,
+		Name:      p.Name,
+		Currency:  p.Currency,
+		Price:     p.Price,
+		MarketCap: p.MarketCap,
+		Source:    p.Source,
+	}}
+	if p.LastUpdated != nil {
+		price.LastUpdated = p.LastUpdated.UTC().Format(time.RFC3339)
+	}
+	if !p.SnapshotTime.IsZero() {
+		price.SnapshotTime = p.SnapshotTime.UTC().Format(time.RFC3339)
+	}
+	return price
+}
+
+// ReadJSONPrices reads the prices written by fetch to a JSON file, either an array or JSON lines of prices
+func ReadJSONPrices(reader io.Reader) ([]HistoricalPrice, error) {
+	buffered := bufio.NewReader(reader)
+	first, err := firstNonSpace(buffered)
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON prices: %w", err)
+	}
+	decoder := json.NewDecoder(buffered)
+
+	array := first == '['
+	if array {
+		if _, err := decoder.Token(); err != nil {
+			return nil, fmt.Errorf("failed to read JSON prices: %w", err)
+		}
+	}
+
+	var prices []HistoricalPrice
+	for {
+		if array && !decoder.More() {
+			break
+		}
+		var price jsonPrice
+		if err := decoder.Decode(&price); err != nil {
+			if !array && errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to read JSON prices: %w", err)
+		}
+		prices = append(prices, price.historicalPrice())
+	}
+	return prices, nil
+}
+
+// isJSONPriceArray returns true if the JSON file is an array of prices rather than a symbol->price map, without consuming it
+func isJSONPriceArray(reader *bufio.Reader) bool {
+	first, err := firstNonSpace(reader)
+	return err == nil && first == '['
+}
+
+// firstNonSpace returns the first byte of the reader that is not a space, without consuming it
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		next, err := reader.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(next[0])) {
+			return next[0], nil
+		}
+		_, _ = reader.Discard(1)
+	}
+}
@@ -0,0 +1,53 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// NDJSONSource reads the transactions as JSON lines, or as a JSON array of them (e.g. a .json export), one object each
+// with the columns of the transactions CSV. The nested values, e.g. "props": {"currencySymbol": "SFL"}, are read as their JSON.
+type NDJSONSource struct{}
+
+func (NDJSONSource) Read(ctx context.Context, reader io.Reader, microBatchSize int, skip int64) (<-chan MicroBatch, error) {
+	sender, dataCh := newBatchSender(ctx, microBatchSize, skip)
+
+	go func() {
+		buffered := bufio.NewReader(reader)
+		first, err := firstNonSpace(buffered)
+		if errors.Is(err, io.EOF) {
+			sender.close(FormatNDJSON, nil)
+			return
+		}
+		decoder := json.NewDecoder(buffered)
+		decoder.UseNumber()
+
+		array := err == nil && first == '['
+		if array {
+			_, err = decoder.Token()
+		}
+		for err == nil {
+			if array && !decoder.More() {
+				break
+			}
+			var row map[string]any
+			if err = decoder.Decode(&row); err != nil {
+				if !array && errors.Is(err, io.EOF) {
+					err = nil
+				}
+				break
+			}
+			var rt RawTransaction
+			for column, value := range row {
+				rt.setColumn(column, value)
+			}
+			err = sender.add(rt)
+		}
+		sender.close(FormatNDJSON, err)
+	}()
+
+	return dataCh, nil
+}
@@ -0,0 +1,118 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// ParquetSource reads the transactions of a Parquet file, with the columns of the transactions CSV.
+// Only those columns are read, or only the given Columns, and the row groups before the skipped rows are not read at all.
+// The reader must be a parquet.ReaderAtSeeker, e.g. a VfsReaderWriter.
+type ParquetSource struct {
+	Columns []string // Columns of the transactions read, e.g. those of AggSpec.InputColumns, all of them when empty
+}
+
+func (s ParquetSource) Read(ctx context.Context, reader io.Reader, microBatchSize int, skip int64) (<-chan MicroBatch, error) {
+	readerAt, ok := reader.(parquet.ReaderAtSeeker)
+	if !ok {
+		return nil, errors.New("the Parquet transactions must be read from a file with random access")
+	}
+	parquetReader, err := file.NewParquetReader(readerAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Parquet file: %w", err)
+	}
+	fileReader, err := pqarrow.NewFileReader(parquetReader, pqarrow.ArrowReadProperties{BatchSize: int64(microBatchSize)}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Parquet file: %w", err)
+	}
+
+	// The leaf columns of the transaction columns read, e.g. props.currencySymbol for a props struct
+	var columns []int
+	schema := parquetReader.MetaData().Schema
+	for i := 0; i < schema.NumColumns(); i++ {
+		name := schema.Column(i).ColumnPath()[0]
+		if _, ok := rawTransactionFields[name]; ok && (len(s.Columns) == 0 || slices.Contains(s.Columns, name)) {
+			columns = append(columns, i)
+		}
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("the Parquet file has none of the transaction columns")
+	}
+
+	sender, dataCh := newBatchSender(ctx, microBatchSize, skip)
+	var rowGroups []int
+	for i := 0; i < parquetReader.NumRowGroups(); i++ {
+		rows := parquetReader.MetaData().RowGroup(i).NumRows()
+		if len(rowGroups) == 0 && sender.skipped+rows <= skip {
+			sender.skipped += rows
+			continue
+		}
+		rowGroups = append(rowGroups, i)
+	}
+
+	go func() {
+		var err error
+		if len(rowGroups) > 0 {
+			err = readParquetRows(ctx, fileReader, columns, rowGroups, sender)
+		}
+		sender.close(FormatParquet, err)
+	}()
+
+	return dataCh, nil
+}
+
+// readParquetRows sends the rows of the row groups
+func readParquetRows(ctx context.Context, fileReader *pqarrow.FileReader, columns []int, rowGroups []int, sender *batchSender) error {
+	records, err := fileReader.GetRecordReader(ctx, columns, rowGroups)
+	if err != nil {
+		return err
+	}
+	defer records.Release()
+
+	for records.Next() {
+		record := records.Record()
+		for row := 0; row < int(record.NumRows()); row++ {
+			var rt RawTransaction
+			for i, column := range record.Columns() {
+				rt.setColumn(record.ColumnName(i), arrowValue(column, row))
+			}
+			if err := sender.add(rt); err != nil {
+				return err
+			}
+		}
+	}
+	if err := records.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// arrowValue returns the value of the row of the Arrow column, see rawValue
+func arrowValue(column arrow.Array, row int) any {
+	if column.IsNull(row) {
+		return nil
+	}
+	// The strings share the memory of the record, released by the next one
+	switch c := column.(type) {
+	case *array.String:
+		return strings.Clone(c.Value(row))
+	case *array.LargeString:
+		return strings.Clone(c.Value(row))
+	case *array.Binary:
+		return c.Value(row)
+	case *array.Timestamp:
+		return c.Value(row).ToTime(c.DataType().(*arrow.TimestampType).Unit)
+	}
+	return column.GetOneForMarshal(row)
+}
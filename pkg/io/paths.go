@@ -0,0 +1,109 @@
+package io
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/c2fo/vfs/v6/utils"
+	"github.com/c2fo/vfs/v6/vfssimple"
+)
+
+// ExpandPaths returns the files of the paths (gs, s3, local file system), in order and without duplicates.
+// A path is either a file, a directory or bucket prefix (all the files directly under it), or a glob in the file name,
+// e.g. gs://bucket/events/2024-04-15/*.csv.
+func ExpandPaths(paths []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		matches, err := expandPath(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no file found at %s", p)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// expandPath returns the files of a single path, sorted
+func expandPath(p string) ([]string, error) {
+	slash := strings.LastIndex(p, "/")
+	dir, name := p[:slash+1], p[slash+1:]
+	if hasGlob(dir) {
+		return nil, fmt.Errorf("invalid path %s: globs are only supported in the file name", p)
+	}
+
+	switch {
+	case name == "":
+		return listFiles(dir, "*")
+	case hasGlob(name):
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %w", p, err)
+		}
+		return listFiles(dir, name)
+	}
+
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		return listFiles(p+"/", "*")
+	}
+	file, err := Open(p)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := file.Exists()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %w", p, err)
+	}
+	if exists {
+		return []string{p}, nil
+	}
+	// Not a file, the directory or prefix of the files
+	return listFiles(p+"/", "*")
+}
+
+// listFiles returns the files directly under the directory or bucket prefix dir matching the glob, sorted
+func listFiles(dir string, glob string) ([]string, error) {
+	uri := dir
+	if dir == "" {
+		uri = "./"
+	}
+	uri, err := utils.PathToURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert path to URI: %w", err)
+	}
+	location, err := vfssimple.NewLocation(utils.EnsureTrailingSlash(uri))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open location %s: %w", dir, err)
+	}
+	exists, err := location.Exists()
+	if err != nil || !exists {
+		return nil, err
+	}
+	names, err := location.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	var files []string
+	for _, name := range names {
+		if match, _ := path.Match(glob, name); match {
+			files = append(files, dir+name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
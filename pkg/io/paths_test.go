@@ -0,0 +1,50 @@
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.csv", "a.csv", "notes.txt", "sub/c.csv"} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("ts\n"), 0o600))
+	}
+	a, b, c, notes := filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv"), filepath.Join(dir, "sub", "c.csv"), filepath.Join(dir, "notes.txt")
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"file", []string{b}, []string{b}},
+		{"glob", []string{filepath.Join(dir, "*.csv")}, []string{a, b}},
+		{"directory", []string{dir}, []string{a, b, notes}},
+		{"directory with trailing slash", []string{dir + "/"}, []string{a, b, notes}},
+		{"repeated paths without duplicates", []string{c, filepath.Join(dir, "*.csv"), a}, []string{c, a, b}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := ExpandPaths(tt.paths)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, files)
+		})
+	}
+}
+
+func TestExpandPaths_Error(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := ExpandPaths([]string{filepath.Join(dir, "missing.csv")})
+	assert.ErrorContains(t, err, "no file found at")
+
+	_, err = ExpandPaths([]string{filepath.Join(dir, "*.csv")})
+	assert.ErrorContains(t, err, "no file found at")
+
+	_, err = ExpandPaths([]string{filepath.Join(dir, "*", "a.csv")})
+	assert.ErrorContains(t, err, "globs are only supported in the file name")
+}
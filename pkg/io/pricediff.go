@@ -0,0 +1,139 @@
+package io
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// PriceChange is a price present in only one of the compared files, or that moved between them
+type PriceChange struct {
+	ID       string  `json:"id"`
+	Symbol   string  `json:"symbol"`
+	Currency string  `json:"currency"`
+	Date     string  `json:"date,omitempty"` // Empty for the current prices
+	OldPrice float64 `json:"old_price"`
+	NewPrice float64 `json:"new_price"`
+	Change   float64 `json:"change"` // Relative change of the price, e.g. 0.25 for +25%
+}
+
+// SymbolRemap is a symbol priced by a different coin in the new file
+type SymbolRemap struct {
+	Symbol string `json:"symbol"`
+	OldID  string `json:"old_id"`
+	NewID  string `json:"new_id"`
+}
+
+// PriceDiff is the difference between two price files
+type PriceDiff struct {
+	Added     []PriceChange `json:"added"`     // Prices only in the new file
+	Removed   []PriceChange `json:"removed"`   // Prices only in the old file
+	Remaps    []SymbolRemap `json:"remaps"`    // Symbols priced by another coin, as resolved by the resolver
+	Moves     []PriceChange `json:"moves"`     // Prices that moved more than the threshold
+	Threshold float64       `json:"threshold"` // Relative change above which a price move is reported
+}
+
+// priceKey identifies a price row: a coin, in a quote currency, on a day
+type priceKey struct {
+	id       string
+	currency string
+	date     string
+}
+
+// DiffPrices compares the old and new prices. Prices are matched by coin ID, quote currency and date,
+// moves are reported when the relative change is above the threshold (prices that were 0 have no relative change).
+// Symbols are resolved with the resolver (DefaultSymbolResolver if nil) to report the remapped ones.
+func DiffPrices(oldPrices, newPrices []HistoricalPrice, threshold float64, resolver *SymbolResolver) (*PriceDiff, error) {
+	if resolver == nil {
+		resolver = &DefaultSymbolResolver
+	}
+	oldChosen, _, err := resolver.Resolve(pricedCoins(oldPrices))
+	if err != nil {
+		return nil, err
+	}
+	newChosen, _, err := resolver.Resolve(pricedCoins(newPrices))
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &PriceDiff{Threshold: threshold}
+	oldIndex := indexPrices(oldPrices)
+	newIndex := indexPrices(newPrices)
+
+	for key, price := range newIndex {
+		old, exists := oldIndex[key]
+		if !exists {
+			diff.Added = append(diff.Added, newPriceChange(key, price, HistoricalPrice{}, price))
+			continue
+		}
+		if old.Price == 0 {
+			continue
+		}
+		change := newPriceChange(key, price, old, price)
+		change.Change = (price.Price - old.Price) / old.Price
+		if math.Abs(change.Change) > threshold {
+			diff.Moves = append(diff.Moves, change)
+		}
+	}
+	for key, price := range oldIndex {
+		if _, exists := newIndex[key]; !exists {
+			diff.Removed = append(diff.Removed, newPriceChange(key, price, price, HistoricalPrice{}))
+		}
+	}
+
+	for symbol, oldID := range oldChosen {
+		if newID, exists := newChosen[symbol]; exists && newID != oldID {
+			diff.Remaps = append(diff.Remaps, SymbolRemap{Symbol: symbol, OldID: oldID, NewID: newID})
+		}
+	}
+
+	sortPriceChanges(diff.Added)
+	sortPriceChanges(diff.Removed)
+	sortPriceChanges(diff.Moves)
+	sort.Slice(diff.Remaps, func(i, j int) bool { return diff.Remaps[i].Symbol < diff.Remaps[j].Symbol })
+	return diff, nil
+}
+
+func pricedCoins(prices []HistoricalPrice) []Coin {
+	coins := make([]Coin, len(prices))
+	for i, price := range prices {
+		coins[i] = price.Coin
+	}
+	return coins
+}
+
+func indexPrices(prices []HistoricalPrice) map[priceKey]HistoricalPrice {
+	index := make(map[priceKey]HistoricalPrice, len(prices))
+	for _, price := range prices {
+		currency := strings.ToLower(price.Currency)
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+		index[priceKey{id: price.ID, currency: currency, date: price.Date}] = price
+	}
+	return index
+}
+
+func newPriceChange(key priceKey, price, old, new HistoricalPrice) PriceChange {
+	return PriceChange{
+		ID:       key.id,
+		Symbol:   strings.ToUpper(price.Symbol),
+		Currency: key.currency,
+		Date:     key.date,
+		OldPrice: old.Price,
+		NewPrice: new.Price,
+	}
+}
+
+func sortPriceChanges(changes []PriceChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.Date < b.Date
+	})
+}
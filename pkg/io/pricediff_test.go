@@ -0,0 +1,43 @@
+package io
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPrices(t *testing.T) {
+	oldPrices, err := ReadPrices(strings.NewReader(`ID,Symbol,Name,Currency,Price,Market Cap
+bitcoin,btc,Bitcoin,usd,60000,1200000000000
+ethereum,eth,Ethereum,usd,3000,360000000000
+bridged-ether,eth,Bridged Ether,usd,2990,1000000
+sunflower-land,sfl,Sunflower Land,usd,0.06,1000000
+`))
+	assert.NoError(t, err)
+	newPrices, err := ReadPrices(strings.NewReader(`ID,Symbol,Name,Currency,Price,Market Cap
+bitcoin,btc,Bitcoin,usd,66000,1300000000000
+ethereum,eth,Ethereum,usd,3000,360000000
+bridged-ether,eth,Bridged Ether,usd,2990,400000000
+sunflower-land,sfl,Sunflower Land,usd,0.6,10000000
+solana,sol,Solana,usd,150,70000000000
+`))
+	assert.NoError(t, err)
+
+	diff, err := DiffPrices(oldPrices, newPrices, 0.5, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []PriceChange{{ID: "solana", Symbol: "SOL", Currency: "usd", NewPrice: 150}}, diff.Added)
+	assert.Empty(t, diff.Removed)
+	// Bitcoin moved 10%, below the threshold
+	assert.Len(t, diff.Moves, 1)
+	assert.Equal(t, "sunflower-land", diff.Moves[0].ID)
+	assert.InDelta(t, 9, diff.Moves[0].Change, 1e-9)
+	// The market cap of the bridged token is now higher
+	assert.Equal(t, []SymbolRemap{{Symbol: "ETH", OldID: "ethereum", NewID: "bridged-ether"}}, diff.Remaps)
+
+	diff, err = DiffPrices(newPrices, oldPrices, 0.05, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []PriceChange{{ID: "solana", Symbol: "SOL", Currency: "usd", OldPrice: 150}}, diff.Removed)
+	assert.Len(t, diff.Moves, 2)
+	assert.Equal(t, "bitcoin", diff.Moves[0].ID)
+}
@@ -0,0 +1,302 @@
+package io
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+const (
+	// PriceDateLayout is the layout of the dates used to key the daily prices
+	PriceDateLayout = "2006-01-02"
+
+	// DefaultCurrency is the quote currency of the prices without currency
+	DefaultCurrency = "usd"
+)
+
+// Currency2Values In-memory holder for currency values
+type Currency2Values map[string]float64
+
+// HistoricalPrice represents the price of a cryptocurrency on a given day
+type HistoricalPrice struct {
+	Date string `csv:"Date"`
+	Coin
+}
+
+// QuotePrices In-memory holder for the currency values of each day in a single quote currency.
+// Prices without a date (current price snapshot) are used for any day without a daily price.
+type QuotePrices struct {
+	Daily    map[string]Currency2Values // Prices by date (PriceDateLayout) and currency symbol
+	Snapshot Currency2Values            // Prices by currency symbol, valid for any date
+}
+
+// PriceTable In-memory holder for the currency values of each day, in each quote currency
+type PriceTable struct {
+	Quotes       map[string]*QuotePrices // Prices by quote currency (usd, eur)
+	Resolutions  []SymbolResolution      // Coins chosen for the symbols shared by several coins
+	SnapshotTime time.Time               // Time the oldest snapshot price was fetched, zero when not recorded
+	Sources      []string                // Providers of the prices, sorted
+}
+
+// NewSnapshotPriceTable creates a PriceTable that uses the same currency values for any date, in the given quote currency
+func NewSnapshotPriceTable(quote string, values Currency2Values) *PriceTable {
+	return &PriceTable{
+		Quotes: map[string]*QuotePrices{
+			quote: newQuotePrices(values),
+		},
+	}
+}
+
+func newQuotePrices(values Currency2Values) *QuotePrices {
+	return &QuotePrices{
+		Daily:    make(map[string]Currency2Values),
+		Snapshot: values,
+	}
+}
+
+// NormalizeCurrencies lower cases and de-duplicates the quote currencies, keeping their order
+func NormalizeCurrencies(currencies []string) []string {
+	var normalized []string
+	seen := make(map[string]bool)
+	for _, currency := range currencies {
+		currency = strings.ToLower(strings.TrimSpace(currency))
+		if currency == "" || seen[currency] {
+			continue
+		}
+		seen[currency] = true
+		normalized = append(normalized, currency)
+	}
+	return normalized
+}
+
+// Price returns the price of the currency symbol on the given date (PriceDateLayout).
+func (q *QuotePrices) Price(symbol string, date string) (float64, bool) {
+	if prices, exists := q.Daily[date]; exists {
+		if price, exists := prices[symbol]; exists {
+			return price, true
+		}
+	}
+
+	price, exists := q.Snapshot[symbol]
+	return price, exists
+}
+
+// Price returns the price of the currency symbol in the quote currency on the given date (PriceDateLayout).
+func (t *PriceTable) Price(quote string, symbol string, date string) (float64, bool) {
+	prices, exists := t.Quotes[quote]
+	if !exists {
+		return 0, false
+	}
+	return prices.Price(symbol, date)
+}
+
+// QuoteCurrencies returns the sorted quote currencies with prices in the table
+func (t *PriceTable) QuoteCurrencies() []string {
+	quotes := make([]string, 0, len(t.Quotes))
+	for quote := range t.Quotes {
+		quotes = append(quotes, quote)
+	}
+	sort.Strings(quotes)
+	return quotes
+}
+
+// Days returns the number of days with historical prices
+func (t *PriceTable) Days() int {
+	days := make(map[string]bool)
+	for _, prices := range t.Quotes {
+		for date := range prices.Daily {
+			days[date] = true
+		}
+	}
+	return len(days)
+}
+
+// AsOf returns the time the prices are valid up to: the end of the last day with historical prices,
+// or the snapshot time if later. It is zero when the price file records neither.
+func (t *PriceTable) AsOf() time.Time {
+	asOf := t.SnapshotTime
+	for _, prices := range t.Quotes {
+		for date := range prices.Daily {
+			day, err := time.Parse(PriceDateLayout, date)
+			if err != nil {
+				continue
+			}
+			if end := day.AddDate(0, 0, 1); end.After(asOf) {
+				asOf = end
+			}
+		}
+	}
+	return asOf
+}
+
+// Len returns the number of prices held in the table
+func (t *PriceTable) Len() int {
+	size := 0
+	for _, prices := range t.Quotes {
+		size += len(prices.Snapshot)
+		for _, daily := range prices.Daily {
+			size += len(daily)
+		}
+	}
+	return size
+}
+
+// ReadPrices reads the price rows saved by fetch from the provided io.Reader
+func ReadPrices(reader io.Reader) ([]HistoricalPrice, error) {
+	var prices []HistoricalPrice
+	if err := gocsv.Unmarshal(reader, &prices); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CSV: %w", err)
+	}
+	return prices, nil
+}
+
+// LoadPrices loads the price rows from the given path: a static price file (JSON, YAML), the CSV saved by fetch,
+// or the JSON array (.json) and JSON lines (.jsonl, .ndjson) saved by fetch
+func LoadPrices(_ context.Context, path string) ([]HistoricalPrice, error) {
+	prices, _, err := loadPriceFile(path)
+	return prices, err
+}
+
+// loadPriceFile reads the price rows of the file, and whether it is a static price file pricing each symbol in USD
+func loadPriceFile(p string) ([]HistoricalPrice, bool, error) {
+	reader, err := OpenReader(p)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open currency value file: %w", err)
+	}
+	defer reader.Close()
+
+	switch ext := strings.ToLower(path.Ext(TrimCompressionExt(p))); {
+	case ext == ".jsonl" || ext == ".ndjson":
+		prices, err := ReadJSONPrices(reader)
+		return prices, false, err
+	case IsStaticPriceFile(p):
+		buffered := bufio.NewReader(reader)
+		if ext == ".json" && isJSONPriceArray(buffered) {
+			prices, err := ReadJSONPrices(buffered)
+			return prices, false, err
+		}
+		values, err := decodeStaticPrices(buffered, p)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read price file %s: %w", p, err)
+		}
+		return snapshotPrices(coinsFromPrices(values)), true, nil
+	}
+
+	prices, err := ReadPrices(reader)
+	return prices, false, err
+}
+
+// ReadPriceTable reads the currency values from the provided io.Reader.
+// It accepts both the current price snapshot saved by SavePrices and the daily prices saved by SavePriceHistory.
+// When several coins share a symbol, the resolver (DefaultSymbolResolver if nil) decides which coin prices it.
+func ReadPriceTable(reader io.Reader, resolver *SymbolResolver) (*PriceTable, error) {
+	prices, err := ReadPrices(reader)
+	if err != nil {
+		return nil, err
+	}
+	return newPriceTable(prices, resolver)
+}
+
+// newPriceTable creates the price table of the price rows, see ReadPriceTable
+func newPriceTable(prices []HistoricalPrice, resolver *SymbolResolver) (*PriceTable, error) {
+	if resolver == nil {
+		resolver = &DefaultSymbolResolver
+	}
+	coins := make([]Coin, len(prices))
+	for i, price := range prices {
+		coins[i] = price.Coin
+	}
+	chosen, resolutions, err := resolver.Resolve(coins)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &PriceTable{
+		Quotes:      make(map[string]*QuotePrices),
+		Resolutions: resolutions,
+	}
+	sources := make(map[string]bool)
+	for _, price := range prices {
+		symbol := strings.ToUpper(price.Symbol)
+		if chosen[symbol] != price.ID {
+			continue
+		}
+		if price.Source != "" {
+			sources[price.Source] = true
+		}
+
+		quote := strings.ToLower(price.Currency)
+		if quote == "" {
+			quote = DefaultCurrency
+		}
+		quotePrices, exists := table.Quotes[quote]
+		if !exists {
+			quotePrices = newQuotePrices(make(Currency2Values))
+			table.Quotes[quote] = quotePrices
+		}
+
+		if price.Date == "" {
+			quotePrices.Snapshot[symbol] = price.Price
+			if err := table.recordSnapshotTime(price.Coin); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if _, err := time.Parse(PriceDateLayout, price.Date); err != nil {
+			return nil, fmt.Errorf("invalid price date for %s: %w", price.Symbol, err)
+		}
+
+		daily, exists := quotePrices.Daily[price.Date]
+		if !exists {
+			daily = make(Currency2Values)
+			quotePrices.Daily[price.Date] = daily
+		}
+		daily[symbol] = price.Price
+	}
+
+	for source := range sources {
+		table.Sources = append(table.Sources, source)
+	}
+	sort.Strings(table.Sources)
+	return table, nil
+}
+
+// recordSnapshotTime keeps the oldest snapshot time of the snapshot prices
+func (t *PriceTable) recordSnapshotTime(coin Coin) error {
+	if coin.SnapshotTime == "" {
+		return nil
+	}
+	snapshotTime, err := time.Parse(time.RFC3339, coin.SnapshotTime)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot time for %s: %w", coin.Symbol, err)
+	}
+	if t.SnapshotTime.IsZero() || snapshotTime.Before(t.SnapshotTime) {
+		t.SnapshotTime = snapshotTime
+	}
+	return nil
+}
+
+// LoadPriceTable loads the currency values from the given path.
+// Static price files (JSON, YAML) price each symbol in USD, any other file holds the price rows saved by fetch, see LoadPrices.
+func LoadPriceTable(_ context.Context, path string, resolver *SymbolResolver) (*PriceTable, error) {
+	prices, static, err := loadPriceFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if static {
+		values := make(Currency2Values, len(prices))
+		for _, price := range prices {
+			values[strings.ToUpper(price.Symbol)] = price.Price
+		}
+		return NewSnapshotPriceTable(DefaultCurrency, values), nil
+	}
+	return newPriceTable(prices, resolver)
+}
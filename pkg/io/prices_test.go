@@ -0,0 +1,87 @@
+package io
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPriceTable_Snapshot(t *testing.T) {
+	csvData := `ID,Symbol,Name,Price (USD)
+bitcoin,btc,Bitcoin,60000
+ethereum,eth,Ethereum,2500
+`
+	table, err := ReadPriceTable(strings.NewReader(csvData), nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, Currency2Values{"BTC": 60000, "ETH": 2500}, table.Quotes["usd"].Snapshot)
+	assert.Empty(t, table.Quotes["usd"].Daily)
+
+	price, exists := table.Price("usd", "BTC", "2024-04-15")
+	assert.True(t, exists)
+	assert.Equal(t, 60000.0, price)
+}
+
+func TestReadPriceTable_Historical(t *testing.T) {
+	csvData := `Date,ID,Symbol,Name,Price (USD)
+2024-04-14,bitcoin,btc,Bitcoin,63000
+2024-04-15,bitcoin,btc,Bitcoin,65000
+2024-04-15,ethereum,eth,Ethereum,3000
+`
+	table, err := ReadPriceTable(strings.NewReader(csvData), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, table.Len())
+
+	price, exists := table.Price("usd", "BTC", "2024-04-14")
+	assert.True(t, exists)
+	assert.Equal(t, 63000.0, price)
+
+	price, exists = table.Price("usd", "BTC", "2024-04-15")
+	assert.True(t, exists)
+	assert.Equal(t, 65000.0, price)
+
+	_, exists = table.Price("usd", "ETH", "2024-04-14")
+	assert.False(t, exists)
+}
+
+func TestReadPriceTable_InvalidDate(t *testing.T) {
+	csvData := `Date,ID,Symbol,Name,Price (USD)
+15/04/2024,bitcoin,btc,Bitcoin,65000
+`
+	_, err := ReadPriceTable(strings.NewReader(csvData), nil)
+	assert.Error(t, err)
+}
+
+func TestReadPriceTable_QuoteCurrencies(t *testing.T) {
+	csvData := `ID,Symbol,Name,Currency,Price,Market Cap
+bitcoin,btc,Bitcoin,usd,65000,0
+bitcoin,btc,Bitcoin,eur,58500,0
+`
+	table, err := ReadPriceTable(strings.NewReader(csvData), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"eur", "usd"}, table.QuoteCurrencies())
+
+	price, exists := table.Price("eur", "BTC", "2024-04-15")
+	assert.True(t, exists)
+	assert.Equal(t, 58500.0, price)
+
+	_, exists = table.Price("gbp", "BTC", "2024-04-15")
+	assert.False(t, exists)
+}
+
+func TestPriceTable_AsOf(t *testing.T) {
+	csv := `Date,ID,Symbol,Name,Price,Source,Snapshot Time
+2024-04-14,bitcoin,btc,Bitcoin,64000,coingecko,2024-04-20T08:00:00Z
+2024-04-15,bitcoin,btc,Bitcoin,63000,coingecko,2024-04-20T08:00:00Z
+,ethereum,eth,Ethereum,3000,coingecko,2024-04-15T10:00:00Z
+,sunflower-land,sfl,Sunflower Land,0.06,file:pinned.json,2024-04-15T09:00:00Z
+`
+	table, err := ReadPriceTable(strings.NewReader(csv), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"coingecko", "file:pinned.json"}, table.Sources)
+	assert.Equal(t, time.Date(2024, 4, 15, 9, 0, 0, 0, time.UTC), table.SnapshotTime)
+	// The daily prices cover the whole last day
+	assert.Equal(t, time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC), table.AsOf())
+}
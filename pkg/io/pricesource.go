@@ -0,0 +1,216 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PriceSource is a provider of the current USD price of the cryptocurrencies
+type PriceSource interface {
+	// Name identifies the provider of the prices
+	Name() string
+	// FetchCryptoData fetches the available cryptocurrencies with their current USD prices
+	FetchCryptoData(ctx context.Context) ([]Coin, error)
+}
+
+// HistoricalPriceSource is a PriceSource that also provides the daily price history of the cryptocurrencies
+type HistoricalPriceSource interface {
+	PriceSource
+	// FetchPriceHistory fetches the daily USD price of the given coin IDs between from and to (inclusive)
+	FetchPriceHistory(ctx context.Context, ids []string, from, to time.Time) ([]HistoricalPrice, error)
+}
+
+// SymbolPriceSource is a PriceSource that can fetch only the coins with the given symbols
+type SymbolPriceSource interface {
+	PriceSource
+	// FetchCryptoDataForSymbols fetches the coins with the given symbols and returns the symbols without any coin
+	FetchCryptoDataForSymbols(ctx context.Context, symbols []string) ([]Coin, []string, error)
+}
+
+// PagedPriceSource is a PriceSource that fetches the current prices page by page
+type PagedPriceSource interface {
+	PriceSource
+	// FetchPages fetches the current prices page by page, skipping the pages up to after (included), and calls fn as each page arrives
+	FetchPages(ctx context.Context, after *Page, fn func(page Page, coins []Coin) error) error
+}
+
+// Page identifies a page of current prices in a quote currency
+type Page struct {
+	Currency string `json:"currency"`
+	Number   int    `json:"number"`
+	Last     bool   `json:"last"` // Whether it is the last page of the quote currency
+}
+
+// PriceWriter writes fetched prices to their destination, WritePrices can be called several times
+type PriceWriter interface {
+	WritePrices(prices []HistoricalPrice) (int, error)
+	Close() error
+}
+
+// SavePrices fetches the current prices from the source and writes them to the provided PriceWriter
+func SavePrices(ctx context.Context, source PriceSource, w PriceWriter) error {
+	coins, err := source.FetchCryptoData(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch crypto data from %s: %w", source.Name(), err)
+	}
+
+	log.Printf("Fetched %d cryptocurrencies from %s\n", len(coins), source.Name())
+	stampCoins(coins, source.Name(), time.Now())
+	if _, err := w.WritePrices(snapshotPrices(coins)); err != nil {
+		return fmt.Errorf("failed to write prices: %w", err)
+	}
+
+	return nil
+}
+
+// SaveStagedPrices fetches the current prices from the source and writes each page to the staged output as it arrives,
+// resuming after the last page of its checkpoint. Once every page is written, they are published to the destination
+// opened by open.
+func SaveStagedPrices(ctx context.Context, source PriceSource, output *StagedOutput, open func() (PriceWriter, error)) error {
+	after := output.Checkpoint.LastPage
+	if after != nil {
+		log.Printf("Resuming fetch from %s after page %d of %s\n", source.Name(), after.Number, after.Currency)
+	}
+
+	// Every page of a fetch shares the snapshot time of its first attempt
+	writePage := func(page Page, coins []Coin) error {
+		stampCoins(coins, source.Name(), output.Checkpoint.SnapshotTime)
+		return output.WritePage(page, coins)
+	}
+
+	paged, ok := source.(PagedPriceSource)
+	if ok {
+		if err := paged.FetchPages(ctx, after, writePage); err != nil {
+			return fmt.Errorf("failed to fetch crypto data from %s: %w", source.Name(), err)
+		}
+	} else if after == nil {
+		// Sources without pages are written as a single page
+		coins, err := source.FetchCryptoData(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to fetch crypto data from %s: %w", source.Name(), err)
+		}
+		if err := writePage(Page{Currency: DefaultCurrency, Number: 1, Last: true}, coins); err != nil {
+			return err
+		}
+	}
+
+	return output.Publish(open)
+}
+
+// SaveSymbolPrices fetches the current prices of the coins with the given symbols and writes them to the provided PriceWriter.
+// Sources that cannot filter the coins by symbol fetch all of them, the coins of other symbols are then dropped.
+// It returns the symbols without any coin, sorted.
+func SaveSymbolPrices(ctx context.Context, source PriceSource, w PriceWriter, symbols []string) ([]string, error) {
+	var coins []Coin
+	var unresolved []string
+	var err error
+	if symbolSource, ok := source.(SymbolPriceSource); ok {
+		coins, unresolved, err = symbolSource.FetchCryptoDataForSymbols(ctx, symbols)
+	} else {
+		coins, err = source.FetchCryptoData(ctx)
+		coins = filterCoins(coins, symbols)
+		unresolved = unresolvedSymbols(symbols, coins)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch crypto data from %s: %w", source.Name(), err)
+	}
+
+	log.Printf("Fetched %d cryptocurrencies for %d symbols from %s\n", len(coins), len(symbols), source.Name())
+	stampCoins(coins, source.Name(), time.Now())
+	if _, err := w.WritePrices(snapshotPrices(coins)); err != nil {
+		return nil, fmt.Errorf("failed to write prices: %w", err)
+	}
+
+	return unresolved, nil
+}
+
+// filterCoins keeps the coins with one of the given symbols
+func filterCoins(coins []Coin, symbols []string) []Coin {
+	wanted := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		wanted[strings.ToUpper(symbol)] = true
+	}
+	var filtered []Coin
+	for _, coin := range coins {
+		if wanted[strings.ToUpper(coin.Symbol)] {
+			filtered = append(filtered, coin)
+		}
+	}
+	return filtered
+}
+
+// unresolvedSymbols returns the sorted symbols without any of the coins
+func unresolvedSymbols(symbols []string, coins []Coin) []string {
+	found := make(map[string]bool, len(coins))
+	for _, coin := range coins {
+		found[strings.ToUpper(coin.Symbol)] = true
+	}
+	var unresolved []string
+	for _, symbol := range symbols {
+		if !found[strings.ToUpper(symbol)] {
+			unresolved = append(unresolved, strings.ToUpper(symbol))
+		}
+	}
+	sort.Strings(unresolved)
+	return unresolved
+}
+
+// SavePriceHistory fetches the daily price history of the given coin IDs and writes it to the provided PriceWriter
+func SavePriceHistory(ctx context.Context, source HistoricalPriceSource, w PriceWriter, ids []string, from, to time.Time) error {
+	history, err := source.FetchPriceHistory(ctx, ids, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to fetch price history from %s: %w", source.Name(), err)
+	}
+
+	log.Printf("Fetched %d daily prices from %s\n", len(history), source.Name())
+	now := time.Now()
+	for i := range history {
+		stampCoin(&history[i].Coin, source.Name(), now)
+	}
+	if _, err := w.WritePrices(history); err != nil {
+		return fmt.Errorf("failed to write prices: %w", err)
+	}
+
+	return nil
+}
+
+// snapshotPrices converts the current prices of the coins into prices without date
+func snapshotPrices(coins []Coin) []HistoricalPrice {
+	prices := make([]HistoricalPrice, len(coins))
+	for i, coin := range coins {
+		prices[i] = HistoricalPrice{Coin: coin}
+	}
+	return prices
+}
+
+// stampCoins records the source and the snapshot time of the fetched coins
+func stampCoins(coins []Coin, source string, snapshotTime time.Time) {
+	for i := range coins {
+		stampCoin(&coins[i], source, snapshotTime)
+	}
+}
+
+func stampCoin(coin *Coin, source string, snapshotTime time.Time) {
+	coin.Source = source
+	coin.SnapshotTime = snapshotTime.UTC().Format(time.RFC3339)
+}
+
+// coinsFromPrices converts a symbol->price map into coins, sorted by symbol to keep the output stable
+func coinsFromPrices(prices map[string]float64) []Coin {
+	coins := make([]Coin, 0, len(prices))
+	for symbol, price := range prices {
+		coins = append(coins, Coin{
+			ID:       strings.ToLower(symbol),
+			Symbol:   strings.ToLower(symbol),
+			Name:     strings.ToUpper(symbol),
+			Currency: DefaultCurrency,
+			Price:    price,
+		})
+	}
+	sort.Slice(coins, func(i, j int) bool { return coins[i].Symbol < coins[j].Symbol })
+	return coins
+}
@@ -0,0 +1,76 @@
+package io
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var pinnedCoins = []Coin{
+	{ID: "btc", Symbol: "btc", Name: "BTC", Currency: "usd", Price: 60000},
+	{ID: "eth", Symbol: "eth", Name: "ETH", Currency: "usd", Price: 2500},
+}
+
+func TestStaticPriceSource(t *testing.T) {
+	files := map[string]string{
+		"prices.json": `{"BTC": 60000, "ETH": 2500}`,
+		"prices.yaml": "BTC: 60000\nETH: 2500\n",
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+			source, err := NewStaticPriceSource(path)
+			assert.NoError(t, err)
+
+			coins, err := source.FetchCryptoData(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, pinnedCoins, coins)
+		})
+	}
+}
+
+func TestStaticPriceSource_UnsupportedFile(t *testing.T) {
+	_, err := NewStaticPriceSource("prices.csv")
+	assert.Error(t, err)
+}
+
+func TestRestPriceSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ETH": 2500, "BTC": 60000}`))
+	}))
+	defer server.Close()
+
+	coins, err := NewRestPriceSource(server.URL).FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, pinnedCoins, coins)
+}
+
+func TestSavePrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"BTC": 60000, "ETH": 2500}`), 0o644))
+	source, err := NewStaticPriceSource(path)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	before := time.Now().Truncate(time.Second)
+	assert.NoError(t, SavePrices(context.Background(), source, NewCSVPriceWriter(&buf)))
+
+	// The saved CSV can be read back by agg, with the snapshot metadata
+	table, err := ReadPriceTable(&buf, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Currency2Values{"BTC": 60000, "ETH": 2500}, table.Quotes["usd"].Snapshot)
+	assert.Equal(t, []string{"file:" + path}, table.Sources)
+	assert.False(t, table.SnapshotTime.Before(before))
+	assert.Equal(t, table.SnapshotTime, table.AsOf())
+}
@@ -0,0 +1,110 @@
+package io
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ResponseCache is an on-disk cache of the API responses, keyed by request URL.
+// Fresh responses (younger than TTL) are served without requests, stale ones are revalidated
+// with their ETag or Last-Modified. In Offline mode, responses are only served from the cache, whatever their age.
+type ResponseCache struct {
+	Dir     string        // Directory of the cached responses
+	TTL     time.Duration // Time a cached response is served without revalidation
+	Offline bool          // Never send requests, fail when a response is not cached
+}
+
+// cachedResponse is a cached response, only successful responses are cached
+type cachedResponse struct {
+	URL          string          `json:"url"`
+	StoredAt     time.Time       `json:"stored_at"` // Time the response was received or last revalidated
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	Body         json.RawMessage `json:"body"`
+}
+
+// errNotCached is returned in Offline mode for the responses that are not in the cache
+var errNotCached = errors.New("response not in the cache")
+
+// DefaultResponseCacheDir returns the directory of the cached CoinGecko responses in the user cache directory
+func DefaultResponseCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate the user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "hodctl", "coingecko"), nil
+}
+
+// fresh tells whether the response can be served without revalidation
+func (c *ResponseCache) fresh(response *cachedResponse, now time.Time) bool {
+	return c.Offline || now.Sub(response.StoredAt) < c.TTL
+}
+
+// load returns the cached response of the URL, nil if it is not cached
+func (c *ResponseCache) load(url string) (*cachedResponse, error) {
+	data, err := os.ReadFile(c.path(url))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached response: %w", err)
+	}
+
+	var response cachedResponse
+	if err := json.Unmarshal(data, &response); err != nil || response.URL != url {
+		// Corrupted entries are fetched again
+		return nil, nil
+	}
+	return &response, nil
+}
+
+// store saves the response of the URL, replacing the file atomically so that readers never see partial entries
+func (c *ResponseCache) store(response *cachedResponse) error {
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode cached response: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(c.Dir, "response-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cached response: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.path(response.URL))
+}
+
+// addConditionalHeaders asks the server to only send the response if it changed since it was cached
+func addConditionalHeaders(req *http.Request, response *cachedResponse) {
+	if response == nil {
+		return
+	}
+	if response.ETag != "" {
+		req.Header.Set("If-None-Match", response.ETag)
+	}
+	if response.LastModified != "" {
+		req.Header.Set("If-Modified-Since", response.LastModified)
+	}
+}
+
+func (c *ResponseCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,88 @@
+package io_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+	"hodctl/pkg/io/coingeckotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoinGeckoClient_ResponseCache(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	cache := &io.ResponseCache{Dir: t.TempDir(), TTL: time.Hour}
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithResponseCache(cache))...)
+	assert.NoError(t, err)
+
+	coins, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, coins, 10)
+	requests := len(server.Requests())
+
+	// Fresh responses are served from the cache
+	cached, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, coins, cached)
+	assert.Len(t, server.Requests(), requests)
+
+	// Stale responses are revalidated with their ETag
+	cache.TTL = 0
+	revalidated, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, coins, revalidated)
+	last := server.Requests()[requests]
+	assert.NotEmpty(t, last.Header.Get("If-None-Match"))
+}
+
+func TestCoinGeckoClient_PingNotCached(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	// Each client pings the API, even with a fresh cache
+	cache := &io.ResponseCache{Dir: t.TempDir(), TTL: time.Hour}
+	for i := 0; i < 2; i++ {
+		_, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithResponseCache(cache))...)
+		assert.NoError(t, err)
+	}
+	pings := 0
+	for _, req := range server.Requests() {
+		if req.URL.Path == "/ping" {
+			pings++
+			assert.Empty(t, req.Header.Get("If-None-Match"))
+		}
+	}
+	assert.Equal(t, 2, pings)
+	entries, err := os.ReadDir(cache.Dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestCoinGeckoClient_Offline(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(10))
+	defer server.Close()
+
+	dir := t.TempDir()
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithResponseCache(&io.ResponseCache{Dir: dir, TTL: time.Minute}))...)
+	assert.NoError(t, err)
+	coins, err := client.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	requests := len(server.Requests())
+
+	// Offline, even stale responses are served and no request is sent, not even the ping
+	offline, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithResponseCache(&io.ResponseCache{Dir: dir, Offline: true}))...)
+	assert.NoError(t, err)
+	cached, err := offline.FetchCryptoData(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, coins, cached)
+	assert.Len(t, server.Requests(), requests)
+
+	// Responses that were never fetched fail
+	_, err = offline.FetchCoinList(context.Background())
+	assert.ErrorContains(t, err, "offline: response not in the cache")
+}
@@ -0,0 +1,56 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// RestPriceSource is a PriceSource fetching the prices from a generic REST endpoint.
+// The endpoint must answer a GET request with a JSON map of currency symbol to USD price, e.g. {"BTC": 60000}.
+type RestPriceSource struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewRestPriceSource creates a price source for the given endpoint
+func NewRestPriceSource(url string) *RestPriceSource {
+	return &RestPriceSource{
+		URL: url,
+		Client: &http.Client{
+			Timeout: httpTimeout,
+		},
+	}
+}
+
+// Name identifies the endpoint as the provider of the prices
+func (s *RestPriceSource) Name() string {
+	return "rest:" + s.URL
+}
+
+// FetchCryptoData fetches the prices from the endpoint
+func (s *RestPriceSource) FetchCryptoData(ctx context.Context) ([]Coin, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("accept", "application/json")
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prices: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch prices: status code %d", res.StatusCode)
+	}
+
+	prices := make(map[string]float64)
+	if err := json.NewDecoder(res.Body).Decode(&prices); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return coinsFromPrices(prices), nil
+}
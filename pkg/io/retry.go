@@ -0,0 +1,68 @@
+package io
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried, using exponential backoff with jitter
+type RetryPolicy struct {
+	MaxAttempts int           // Maximum number of attempts of a request, including the first one
+	BaseDelay   time.Duration // Delay before the first retry, doubled on every attempt
+	MaxDelay    time.Duration // Upper bound of the delay between two attempts, a longer Retry-After of the server fails the request
+}
+
+// DefaultRetryPolicy retries for about 5 minutes, enough to get over the Demo API rate limit window several times
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 8,
+	BaseDelay:   2 * time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// Backoff returns the delay to wait after the given failed attempt (starting at 1).
+// Half of the exponential delay is randomized so concurrent clients don't retry in lockstep.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(half+1)
+}
+
+// retryableError is a transient error, the request can be retried
+type retryableError struct {
+	err        error
+	retryAfter time.Duration // Delay requested by the server, 0 if none
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// parseRetryAfter parses the Retry-After header, either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
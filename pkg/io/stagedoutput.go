@@ -0,0 +1,145 @@
+package io
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gocarina/gocsv"
+)
+
+const (
+	partSuffix       = ".part-%05d"
+	checkpointSuffix = ".checkpoint.json"
+)
+
+// FetchCheckpoint records the progress of an incremental fetch
+type FetchCheckpoint struct {
+	Source       string    `json:"source"`        // Name of the price source
+	SnapshotTime time.Time `json:"snapshot_time"` // Time the fetch started
+	Parts        int       `json:"parts"`         // Number of part files written
+	Coins        int       `json:"coins"`         // Number of coins written
+	LastPage     *Page     `json:"last_page"`     // Last completed page, nil before the first one
+}
+
+// StagedOutput writes the pages of a fetch to part files under a staging path (<path>.part-00001, ...)
+// and keeps a checkpoint of the last completed page in <path>.checkpoint.json.
+// The output itself is only written by Publish, once every page is there.
+type StagedOutput struct {
+	Checkpoint FetchCheckpoint
+
+	path string
+}
+
+// NewStagedOutput creates the staged output of the given staging path for a fetch from the named source.
+// When resume is true, the checkpoint left by an interrupted fetch is loaded, otherwise any staged pages are discarded.
+func NewStagedOutput(path string, source string, resume bool) (*StagedOutput, error) {
+	s := &StagedOutput{
+		Checkpoint: FetchCheckpoint{Source: source, SnapshotTime: time.Now().UTC()},
+		path:       path,
+	}
+
+	var checkpoint FetchCheckpoint
+	found, err := ReadJSON(s.path+checkpointSuffix, &checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if resume {
+			log.Printf("No checkpoint found in %s, fetching from the first page\n", path)
+		}
+		return s, nil
+	}
+
+	if !resume {
+		log.Printf("Discarding the %d pages staged by a previous fetch in %s\n", checkpoint.Parts, path)
+		s.Checkpoint = checkpoint
+		if err := s.clean(); err != nil {
+			return nil, err
+		}
+		s.Checkpoint = FetchCheckpoint{Source: source, SnapshotTime: time.Now().UTC()}
+		return s, nil
+	}
+
+	if checkpoint.Source != source {
+		return nil, fmt.Errorf("cannot resume the fetch staged in %s from %s, it was started from %s", path, source, checkpoint.Source)
+	}
+	s.Checkpoint = checkpoint
+	return s, nil
+}
+
+// WritePage writes the coins of a page to a new part file, then records the page as completed in the checkpoint
+func (s *StagedOutput) WritePage(page Page, coins []Coin) error {
+	part, err := Open(s.partPath(s.Checkpoint.Parts + 1))
+	if err != nil {
+		return fmt.Errorf("failed to open part file: %w", err)
+	}
+	if err := gocsv.Marshal(&coins, part); err != nil {
+		_ = part.Close()
+		return fmt.Errorf("failed to write page %d of %s: %w", page.Number, page.Currency, err)
+	}
+	if err := part.Close(); err != nil {
+		return fmt.Errorf("failed to write page %d of %s: %w", page.Number, page.Currency, err)
+	}
+
+	s.Checkpoint.Parts++
+	s.Checkpoint.Coins += len(coins)
+	s.Checkpoint.LastPage = &page
+	if err := WriteJSON(s.path+checkpointSuffix, s.Checkpoint); err != nil {
+		return err
+	}
+
+	log.Printf("Saved page %d of %s prices, %d cryptocurrencies so far\n", page.Number, page.Currency, s.Checkpoint.Coins)
+	return nil
+}
+
+// Publish writes the prices of every part file to the destination opened by open, then removes the part files and the checkpoint
+func (s *StagedOutput) Publish(open func() (PriceWriter, error)) error {
+	writer, err := open()
+	if err != nil {
+		return err
+	}
+	if err := s.copyParts(writer); err != nil {
+		_ = writer.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to publish prices: %w", err)
+	}
+
+	log.Printf("Published %d cryptocurrencies\n", s.Checkpoint.Coins)
+	return s.clean()
+}
+
+func (s *StagedOutput) copyParts(w PriceWriter) error {
+	for i := 1; i <= s.Checkpoint.Parts; i++ {
+		part, err := Open(s.partPath(i))
+		if err != nil {
+			return fmt.Errorf("failed to open part file: %w", err)
+		}
+		var coins []Coin
+		err = gocsv.Unmarshal(part, &coins)
+		_ = part.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read part %d: %w", i, err)
+		}
+		if _, err := w.WritePrices(snapshotPrices(coins)); err != nil {
+			return fmt.Errorf("failed to write part %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// clean removes the part files and the checkpoint
+func (s *StagedOutput) clean() error {
+	for i := 1; i <= s.Checkpoint.Parts; i++ {
+		if err := DeleteIfExists(s.partPath(i)); err != nil {
+			return err
+		}
+	}
+	return DeleteIfExists(s.path + checkpointSuffix)
+}
+
+func (s *StagedOutput) partPath(i int) string {
+	return s.path + fmt.Sprintf(partSuffix, i)
+}
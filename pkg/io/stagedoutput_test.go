@@ -0,0 +1,80 @@
+package io_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hodctl/pkg/io"
+	"hodctl/pkg/io/coingeckotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveStagedPrices_Resume(t *testing.T) {
+	server := coingeckotest.NewServer(testAPIKey, generateCoins(600))
+	defer server.Close()
+
+	client, err := io.NewCoinGeckoClient(context.Background(), testAPIKey, append(testOptions(server), io.WithRetryPolicy(io.RetryPolicy{MaxAttempts: 1}))...)
+	assert.NoError(t, err)
+
+	// The first page is fetched, the second one fails
+	output := filepath.Join(t.TempDir(), "currencies.csv")
+	staged, err := io.NewStagedOutput(output, client.Name(), false)
+	assert.NoError(t, err)
+	server.FailAfter(1, 1, http.StatusInternalServerError)
+	err = io.SaveStagedPrices(context.Background(), client, staged, openCSV(output))
+	assert.Error(t, err)
+	assert.NoFileExists(t, output)
+	assert.FileExists(t, output+".part-00001")
+	assert.FileExists(t, output+".checkpoint.json")
+
+	// Resuming only fetches the remaining pages
+	requests := len(server.Requests())
+	staged, err = io.NewStagedOutput(output, client.Name(), true)
+	assert.NoError(t, err)
+	assert.Equal(t, &io.Page{Currency: "usd", Number: 1}, staged.Checkpoint.LastPage)
+	err = io.SaveStagedPrices(context.Background(), client, staged, openCSV(output))
+	assert.NoError(t, err)
+
+	resumed := server.Requests()[requests:]
+	assert.Len(t, resumed, 2)
+	assert.Equal(t, "2", resumed[0].URL.Query().Get("page"))
+	assert.NoFileExists(t, output+".part-00001")
+	assert.NoFileExists(t, output+".checkpoint.json")
+
+	reader, err := os.Open(output)
+	assert.NoError(t, err)
+	defer reader.Close()
+	table, err := io.ReadPriceTable(reader, nil)
+	assert.NoError(t, err)
+	assert.Len(t, table.Quotes["usd"].Snapshot, 600)
+}
+
+func TestNewStagedOutput_SourceMismatch(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "currencies.csv")
+	staged, err := io.NewStagedOutput(output, "coingecko", false)
+	assert.NoError(t, err)
+	assert.NoError(t, staged.WritePage(io.Page{Currency: "usd", Number: 1}, []io.Coin{{ID: "bitcoin", Symbol: "btc", Price: 65000}}))
+
+	_, err = io.NewStagedOutput(output, "rest:http://localhost", true)
+	assert.ErrorContains(t, err, "it was started from coingecko")
+
+	// Without resume, the staged pages are discarded
+	_, err = io.NewStagedOutput(output, "rest:http://localhost", false)
+	assert.NoError(t, err)
+	assert.NoFileExists(t, output+".part-00001")
+}
+
+// openCSV opens the CSV price file of the given path
+func openCSV(path string) func() (io.PriceWriter, error) {
+	return func() (io.PriceWriter, error) {
+		writer, err := io.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return io.NewCSVPriceWriter(writer), nil
+	}
+}
@@ -0,0 +1,77 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticPriceSource is a PriceSource reading pinned prices from a JSON or YAML file.
+// The file holds a map of currency symbol to USD price, e.g. {"BTC": 60000, "ETH": 2500}.
+type StaticPriceSource struct {
+	Path string // Path to the price file (gs, s3, local file system)
+}
+
+// NewStaticPriceSource creates a price source for the given JSON (.json) or YAML (.yaml, .yml) file, possibly compressed (e.g. .json.gz)
+func NewStaticPriceSource(path string) (*StaticPriceSource, error) {
+	if !IsStaticPriceFile(path) {
+		return nil, fmt.Errorf("unsupported price file %s, expected a .json, .yaml or .yml file", path)
+	}
+	return &StaticPriceSource{Path: path}, nil
+}
+
+// IsStaticPriceFile returns true if the path has the extension of a static price file
+func IsStaticPriceFile(p string) bool {
+	switch strings.ToLower(path.Ext(TrimCompressionExt(p))) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// Name identifies the price file as the provider of the prices
+func (s *StaticPriceSource) Name() string {
+	return "file:" + s.Path
+}
+
+// FetchCryptoData reads the prices from the file
+func (s *StaticPriceSource) FetchCryptoData(_ context.Context) ([]Coin, error) {
+	reader, err := OpenReader(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price file: %w", err)
+	}
+	defer reader.Close()
+
+	prices, err := decodeStaticPrices(reader, s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price file %s: %w", s.Path, err)
+	}
+
+	return coinsFromPrices(prices), nil
+}
+
+// decodeStaticPrices decodes the symbol->price map
+func decodeStaticPrices(reader io.Reader, p string) (map[string]float64, error) {
+	prices := make(map[string]float64)
+	if err := decodeFile(reader, p, &prices); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// decodeFile decodes a JSON or YAML file into out, the format is selected by the file extension
+func decodeFile(reader io.Reader, p string, out any) error {
+	if strings.ToLower(path.Ext(TrimCompressionExt(p))) == ".json" {
+		return json.NewDecoder(reader).Decode(out)
+	}
+
+	if err := yaml.NewDecoder(reader).Decode(out); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
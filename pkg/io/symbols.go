@@ -0,0 +1,182 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SymbolPolicy decides which coin is used when several coins share the same symbol
+type SymbolPolicy string
+
+const (
+	SymbolPolicyMarketCap SymbolPolicy = "market-cap" // Use the coin with the highest market cap
+	SymbolPolicyFail      SymbolPolicy = "fail"       // Ambiguous symbols are an error
+)
+
+// SymbolResolver resolves the coin used to price each currency symbol
+type SymbolResolver struct {
+	Policy    SymbolPolicy      // Policy for the ambiguous symbols without override
+	Overrides map[string]string // Coin ID to use for a symbol, takes precedence over the policy
+}
+
+// DefaultSymbolResolver picks the coin with the highest market cap
+var DefaultSymbolResolver = SymbolResolver{Policy: SymbolPolicyMarketCap}
+
+// SymbolResolution reports the coin chosen for an ambiguous or overridden symbol
+type SymbolResolution struct {
+	Symbol     string `csv:"Symbol"`
+	ChosenID   string `csv:"Chosen ID"`
+	Candidates string `csv:"Candidates"` // IDs of all the coins sharing the symbol, by decreasing market cap
+	Reason     string `csv:"Reason"`
+}
+
+// ParseSymbolPolicy parses the name of a SymbolPolicy
+func ParseSymbolPolicy(name string) (SymbolPolicy, error) {
+	switch policy := SymbolPolicy(name); policy {
+	case SymbolPolicyMarketCap, SymbolPolicyFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported symbol policy %q, expected %s or %s", name, SymbolPolicyMarketCap, SymbolPolicyFail)
+}
+
+// LoadSymbolOverrides loads a JSON or YAML file mapping currency symbols to coin IDs, e.g. {"ETH": "ethereum"}
+func LoadSymbolOverrides(path string) (map[string]string, error) {
+	reader, err := OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open symbol overrides file: %w", err)
+	}
+	defer reader.Close()
+
+	raw := make(map[string]string)
+	if err := decodeFile(reader, path, &raw); err != nil {
+		return nil, fmt.Errorf("failed to read symbol overrides file %s: %w", path, err)
+	}
+
+	overrides := make(map[string]string, len(raw))
+	for symbol, id := range raw {
+		overrides[strings.ToUpper(symbol)] = id
+	}
+	return overrides, nil
+}
+
+// symbolCandidate is a coin sharing a symbol with other coins
+type symbolCandidate struct {
+	id        string
+	marketCap float64
+	known     bool // Whether the market cap is known in the quote currency compared
+}
+
+// Resolve returns the coin ID to use for each symbol (upper case) and the report of the ambiguous or overridden symbols.
+// The market caps are in the quote currency of their price, so they are only compared within one quote currency:
+// the one most candidates are priced in, USD first. Candidates with the same market cap are ordered by ID so the choice
+// is always deterministic.
+func (r SymbolResolver) Resolve(coins []Coin) (map[string]string, []SymbolResolution, error) {
+	// Collect the distinct coins of each symbol, with their highest known market cap in each quote currency
+	bySymbol := make(map[string]map[string]bool)
+	marketCaps := make(map[string]map[string]map[string]float64) // By symbol, then quote currency, then coin ID
+	for _, coin := range coins {
+		symbol := strings.ToUpper(coin.Symbol)
+		if bySymbol[symbol] == nil {
+			bySymbol[symbol] = make(map[string]bool)
+			marketCaps[symbol] = make(map[string]map[string]float64)
+		}
+		bySymbol[symbol][coin.ID] = true
+
+		currency := strings.ToLower(coin.Currency)
+		if currency == "" {
+			currency = DefaultCurrency
+		}
+		caps, exists := marketCaps[symbol][currency]
+		if !exists {
+			caps = make(map[string]float64)
+			marketCaps[symbol][currency] = caps
+		}
+		if marketCap, exists := caps[coin.ID]; !exists || coin.MarketCap > marketCap {
+			caps[coin.ID] = coin.MarketCap
+		}
+	}
+
+	symbols := make([]string, 0, len(bySymbol))
+	for symbol := range bySymbol {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	chosen := make(map[string]string, len(bySymbol))
+	var report []SymbolResolution
+	var errs []error
+	for _, symbol := range symbols {
+		currency := comparedCurrency(marketCaps[symbol])
+		caps := marketCaps[symbol][currency]
+		candidates := make([]symbolCandidate, 0, len(bySymbol[symbol]))
+		for id := range bySymbol[symbol] {
+			marketCap, known := caps[id]
+			candidates = append(candidates, symbolCandidate{id: id, marketCap: marketCap, known: known})
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			if candidates[i].known != candidates[j].known {
+				return candidates[i].known
+			}
+			if candidates[i].marketCap != candidates[j].marketCap {
+				return candidates[i].marketCap > candidates[j].marketCap
+			}
+			return candidates[i].id < candidates[j].id
+		})
+
+		ids := make([]string, len(candidates))
+		for i, candidate := range candidates {
+			ids[i] = candidate.id
+		}
+		resolution := SymbolResolution{Symbol: symbol, Candidates: strings.Join(ids, ",")}
+
+		if override, exists := r.Overrides[symbol]; exists {
+			if !bySymbol[symbol][override] {
+				errs = append(errs, fmt.Errorf("symbol %s is overridden with unknown coin %s, candidates: %s", symbol, override, resolution.Candidates))
+				continue
+			}
+			resolution.ChosenID = override
+			resolution.Reason = "override"
+			chosen[symbol] = override
+			report = append(report, resolution)
+			continue
+		}
+
+		if len(candidates) == 1 {
+			chosen[symbol] = candidates[0].id
+			continue
+		}
+
+		if r.Policy == SymbolPolicyFail {
+			errs = append(errs, fmt.Errorf("symbol %s is shared by several coins: %s", symbol, resolution.Candidates))
+			continue
+		}
+
+		resolution.ChosenID = candidates[0].id
+		resolution.Reason = fmt.Sprintf("highest market cap (%.0f %s)", candidates[0].marketCap, strings.ToUpper(currency))
+		chosen[symbol] = candidates[0].id
+		report = append(report, resolution)
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("failed to resolve currency symbols: %w", errors.Join(errs...))
+	}
+	return chosen, report, nil
+}
+
+// comparedCurrency returns the quote currency the market caps of the candidates of a symbol are compared in:
+// the one with the most candidates, USD first then by name when several have as many
+func comparedCurrency(marketCaps map[string]map[string]float64) string {
+	var compared string
+	for currency, caps := range marketCaps {
+		switch {
+		case compared == "", len(caps) > len(marketCaps[compared]):
+			compared = currency
+		case len(caps) < len(marketCaps[compared]), compared == DefaultCurrency:
+		case currency == DefaultCurrency || currency < compared:
+			compared = currency
+		}
+	}
+	return compared
+}
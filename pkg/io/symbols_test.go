@@ -0,0 +1,87 @@
+package io
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Three coins share the ETH symbol
+const ambiguousPrices = `ID,Symbol,Name,Price (USD),Market Cap (USD)
+ethereum,eth,Ethereum,3000,360000000000
+ethereum-wormhole,eth,Ethereum (Wormhole),2990,1000000
+bridged-ether,eth,Bridged Ether,3,1000000
+bitcoin,btc,Bitcoin,65000,1200000000000
+`
+
+func TestReadPriceTable_HighestMarketCap(t *testing.T) {
+	table, err := ReadPriceTable(strings.NewReader(ambiguousPrices), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Currency2Values{"ETH": 3000, "BTC": 65000}, table.Quotes["usd"].Snapshot)
+
+	assert.Equal(t, []SymbolResolution{{
+		Symbol:     "ETH",
+		ChosenID:   "ethereum",
+		Candidates: "ethereum,bridged-ether,ethereum-wormhole", // same market cap ordered by ID
+		Reason:     "highest market cap (360000000000 USD)",
+	}}, table.Resolutions)
+}
+
+func TestReadPriceTable_MarketCapCurrency(t *testing.T) {
+	// The market caps are compared in EUR, the quote currency of both coins, not with the JPY market cap of alpha
+	csvData := `Currency,ID,Symbol,Name,Price,Market Cap
+eur,alpha,abc,Alpha,1,2000000000
+jpy,alpha,abc,Alpha,160,300000000000
+eur,beta,abc,Beta,1.1,3000000000
+`
+	table, err := ReadPriceTable(strings.NewReader(csvData), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Currency2Values{"ABC": 1.1}, table.Quotes["eur"].Snapshot)
+	assert.Equal(t, []SymbolResolution{{
+		Symbol:     "ABC",
+		ChosenID:   "beta",
+		Candidates: "beta,alpha",
+		Reason:     "highest market cap (3000000000 EUR)",
+	}}, table.Resolutions)
+}
+
+func TestReadPriceTable_Override(t *testing.T) {
+	resolver := &SymbolResolver{
+		Policy:    SymbolPolicyFail,
+		Overrides: map[string]string{"ETH": "ethereum-wormhole"},
+	}
+	table, err := ReadPriceTable(strings.NewReader(ambiguousPrices), resolver)
+	assert.NoError(t, err)
+	assert.Equal(t, 2990.0, table.Quotes["usd"].Snapshot["ETH"])
+	assert.Len(t, table.Resolutions, 1)
+	assert.Equal(t, "override", table.Resolutions[0].Reason)
+
+	// An override must reference one of the coins sharing the symbol
+	resolver.Overrides["ETH"] = "bitcoin"
+	_, err = ReadPriceTable(strings.NewReader(ambiguousPrices), resolver)
+	assert.ErrorContains(t, err, "symbol ETH is overridden with unknown coin bitcoin")
+}
+
+func TestReadPriceTable_FailOnAmbiguousSymbol(t *testing.T) {
+	_, err := ReadPriceTable(strings.NewReader(ambiguousPrices), &SymbolResolver{Policy: SymbolPolicyFail})
+	assert.ErrorContains(t, err, "symbol ETH is shared by several coins")
+}
+
+func TestReadPriceTable_AmbiguousHistoricalSymbol(t *testing.T) {
+	csvData := `Date,ID,Symbol,Name,Price (USD),Market Cap (USD)
+2024-04-14,ethereum,eth,Ethereum,3100,370000000000
+2024-04-14,bridged-ether,eth,Bridged Ether,3,1000000
+2024-04-15,ethereum,eth,Ethereum,3000,360000000000
+2024-04-15,bridged-ether,eth,Bridged Ether,3,1000000
+`
+	table, err := ReadPriceTable(strings.NewReader(csvData), nil)
+	assert.NoError(t, err)
+
+	// The same coin is used for every day
+	price, _ := table.Price("usd", "ETH", "2024-04-14")
+	assert.Equal(t, 3100.0, price)
+	price, _ = table.Price("usd", "ETH", "2024-04-15")
+	assert.Equal(t, 3000.0, price)
+	assert.Len(t, table.Resolutions, 1)
+}
@@ -0,0 +1,49 @@
+package io
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+)
+
+// transactionProps are the props of a transaction that reference a currency
+type transactionProps struct {
+	CurrencySymbol string `json:"currencySymbol"`
+}
+
+// ScanCurrencySymbols reads the transactions CSV and returns the distinct currency symbols of their props, upper cased and sorted.
+// Rows with invalid props are skipped, as agg sends them to the error output.
+func ScanCurrencySymbols(reader io.Reader) ([]string, error) {
+	symbols := make(map[string]bool)
+	rows, invalid := 0, 0
+
+	err := gocsv.UnmarshalToCallbackWithError(reader, func(rt RawTransaction) error {
+		rows++
+		var props transactionProps
+		if err := json.Unmarshal([]byte(rt.Props), &props); err != nil {
+			invalid++
+			return nil
+		}
+		if symbol := strings.ToUpper(strings.TrimSpace(props.CurrencySymbol)); symbol != "" {
+			symbols[symbol] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan transactions: %w", err)
+	}
+
+	sorted := make([]string, 0, len(symbols))
+	for symbol := range symbols {
+		sorted = append(sorted, symbol)
+	}
+	sort.Strings(sorted)
+
+	log.Printf("Scanned %d transactions: %d currency symbols, %d rows with invalid props\n", rows, len(sorted), invalid)
+	return sorted, nil
+}
@@ -0,0 +1,21 @@
+package io
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanCurrencySymbols(t *testing.T) {
+	csvData := `"ts","event","project_id","props","nums"
+"2024-04-15 02:15:07.167","BUY_ITEMS","4974","{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""0.6""}"
+"2024-04-15 02:26:37.134","BUY_ITEMS","0","{""currencySymbol"":""usdc""}","{""currencyValueDecimal"":""7""}"
+"2024-04-15 02:27:37.134","BUY_ITEMS","0","{""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1""}"
+"2024-04-15 02:28:37.134","BUY_ITEMS","0","not json","{""currencyValueDecimal"":""1""}"
+"2024-04-15 02:29:37.134","BUY_ITEMS","0","{}","{""currencyValueDecimal"":""1""}"
+`
+	symbols, err := ScanCurrencySymbols(strings.NewReader(csvData))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SFL", "USDC"}, symbols)
+}
@@ -0,0 +1,132 @@
+package io
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// TransactionFiles are the transaction files of an aggregation, read concurrently and decompressed, see OpenReader
+type TransactionFiles struct {
+	Paths       []string    // Paths of the files (gs, s3, local file system), see ExpandPaths
+	Parallelism int         // Maximum number of files read at the same time, 1 when 0
+	Format      InputFormat // Format of the files, detected from the extension of each file when empty
+	Columns     []string    // Columns of the transactions read from the Parquet files, all of them when empty
+}
+
+// FileError is the error of a transactions file that could not be opened or read, unlike the errors parsing its rows
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// Read reads the files, at most Parallelism at a time, and returns a channel of their MicroBatch tagged with their file.
+// A file that cannot be opened or read is sent as a MicroBatch with a FileError.
+// The first skip[path] rows of each file are skipped, e.g. already processed before a checkpoint.
+// Reading stops and the channel is closed as soon as the context is done.
+func (f TransactionFiles) Read(ctx context.Context, microBatchSize int, skip map[string]int64) (<-chan MicroBatch, error) {
+	dataCh := make(chan MicroBatch, DefaultChannelBufferSize)
+	parallelism := max(f.Parallelism, 1)
+	files := make(chan string, len(f.Paths))
+	for _, path := range f.Paths {
+		files <- path
+	}
+	close(files)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(parallelism, len(f.Paths)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range files {
+				if err := f.readFile(ctx, path, microBatchSize, skip[path], dataCh); err != nil {
+					select {
+					case dataCh <- MicroBatch{File: path, Err: &FileError{Path: path, Err: err}}:
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(dataCh)
+	}()
+	return dataCh, nil
+}
+
+// readFile sends the batches of the file to out
+func (f TransactionFiles) readFile(ctx context.Context, path string, microBatchSize int, skip int64, out chan<- MicroBatch) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	format := f.Format
+	if format == "" {
+		format = DetectInputFormat(path)
+	}
+	source, err := NewTransactionSource(format, f.Columns)
+	if err != nil {
+		return err
+	}
+
+	// The Parquet files are read with random access, and compressed by their own codecs
+	var file io.ReadCloser
+	var stream *streamReader
+	if format == FormatParquet {
+		file, err = Open(path)
+	} else {
+		file, err = OpenReader(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open transactions file %s: %w", path, err)
+	}
+	defer file.Close()
+	if format != FormatParquet {
+		stream = &streamReader{ReadCloser: file}
+		file = stream
+	}
+
+	log.Printf("Reading %s transactions file %s\n", format, path)
+	batches, err := source.Read(ctx, file, microBatchSize, skip)
+	if err != nil {
+		return fmt.Errorf("failed to read transactions file %s: %w", path, err)
+	}
+	for batch := range batches {
+		batch.File = path
+		select {
+		case out <- batch:
+		case <-ctx.Done():
+		}
+	}
+	if stream != nil && stream.err != nil {
+		return fmt.Errorf("failed to read transactions file %s: %w", path, stream.err)
+	}
+	return nil
+}
+
+// streamReader records the error reading the file, e.g. a network or decompression error,
+// so it is told apart from the errors parsing the rows, reported by the source
+type streamReader struct {
+	io.ReadCloser
+	err error
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+	}
+	return n, err
+}
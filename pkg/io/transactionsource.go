@@ -0,0 +1,192 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// TransactionSource reads the transactions of a file format as RawTransaction micro batches
+type TransactionSource interface {
+	// Read reads the transactions in batches of microBatchSize, skipping the first skip rows, e.g. already processed before a checkpoint.
+	// Reading stops and the channel is closed as soon as the context is done.
+	Read(ctx context.Context, reader io.Reader, microBatchSize int, skip int64) (<-chan MicroBatch, error)
+}
+
+// InputFormat is the file format of the transactions
+type InputFormat string
+
+const (
+	FormatCSV     InputFormat = "csv"
+	FormatNDJSON  InputFormat = "ndjson"
+	FormatParquet InputFormat = "parquet"
+	FormatAvro    InputFormat = "avro"
+)
+
+// formatExtensions are the extensions of the transaction formats, any other extension is read as CSV
+var formatExtensions = map[string]InputFormat{
+	".csv":     FormatCSV,
+	".jsonl":   FormatNDJSON,
+	".ndjson":  FormatNDJSON,
+	".json":    FormatNDJSON,
+	".parquet": FormatParquet,
+	".pq":      FormatParquet,
+	".avro":    FormatAvro,
+}
+
+// ParseInputFormat returns the format of the name, the empty format when name is empty (detected from each file)
+func ParseInputFormat(name string) (InputFormat, error) {
+	switch format := InputFormat(strings.ToLower(name)); format {
+	case "", FormatCSV, FormatNDJSON, FormatParquet, FormatAvro:
+		return format, nil
+	case "jsonl", "json":
+		return FormatNDJSON, nil
+	}
+	return "", fmt.Errorf("unsupported input format %q, expected %s, %s, %s or %s", name, FormatCSV, FormatNDJSON, FormatParquet, FormatAvro)
+}
+
+// DetectInputFormat returns the format of the file from its extension, without its compression extension (e.g. events.jsonl.gz)
+func DetectInputFormat(p string) InputFormat {
+	if format, ok := formatExtensions[strings.ToLower(path.Ext(TrimCompressionExt(p)))]; ok {
+		return format
+	}
+	return FormatCSV
+}
+
+// NewTransactionSource returns the source reading the transactions of the format.
+// The Parquet files only read the given columns of the transactions, all of them when empty.
+func NewTransactionSource(format InputFormat, columns []string) (TransactionSource, error) {
+	switch format {
+	case FormatCSV, "":
+		return CSVSource{}, nil
+	case FormatNDJSON:
+		return NDJSONSource{}, nil
+	case FormatParquet:
+		return ParquetSource{Columns: columns}, nil
+	case FormatAvro:
+		return AvroSource{}, nil
+	}
+	return nil, fmt.Errorf("unsupported input format %q", format)
+}
+
+// CSVSource reads the transactions CSV, see ReadCSVFrom
+type CSVSource struct{}
+
+func (CSVSource) Read(ctx context.Context, reader io.Reader, microBatchSize int, skip int64) (<-chan MicroBatch, error) {
+	return ReadCSVFrom(ctx, reader, microBatchSize, skip)
+}
+
+// rawTransactionFields are the indexes of the RawTransaction fields, by column name
+var rawTransactionFields = func() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(RawTransaction{})
+	for i := 0; i < t.NumField(); i++ {
+		fields[t.Field(i).Tag.Get("csv")] = i
+	}
+	return fields
+}()
+
+// setColumn sets the field of the column, other columns are ignored
+func (rt *RawTransaction) setColumn(column string, value any) {
+	if i, ok := rawTransactionFields[column]; ok {
+		reflect.ValueOf(rt).Elem().Field(i).SetString(rawValue(value))
+	}
+}
+
+// rawTimeLayout is the layout of the timestamps of the transactions CSV, for the typed timestamps of the other formats
+const rawTimeLayout = "2006-01-02 15:04:05.999999999"
+
+// rawValue returns the value of a column as in the transactions CSV: strings as is,
+// and the nested values, e.g. the props and nums objects, as JSON
+func rawValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case json.Number:
+		return v.String()
+	case time.Time:
+		return v.UTC().Format(rawTimeLayout)
+	case *big.Rat:
+		return v.FloatString(18)
+	case map[string]any, []any:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(raw)
+	}
+	return fmt.Sprint(value)
+}
+
+// batchSender sends the rows of a source in micro batches, after skipping the first skip rows
+type batchSender struct {
+	ctx     context.Context
+	out     chan<- MicroBatch
+	size    int
+	skip    int64
+	skipped int64
+	batch   []RawTransaction
+	rows    int
+}
+
+func newBatchSender(ctx context.Context, microBatchSize int, skip int64) (*batchSender, <-chan MicroBatch) {
+	out := make(chan MicroBatch, DefaultChannelBufferSize)
+	return &batchSender{ctx: ctx, out: out, size: microBatchSize, skip: skip}, out
+}
+
+// add adds a row to the batch and sends it once full, it fails when the context is done
+func (s *batchSender) add(rt RawTransaction) error {
+	if s.skipped < s.skip {
+		s.skipped++
+		return nil
+	}
+	s.rows++
+	s.batch = append(s.batch, rt)
+	if len(s.batch) >= s.size {
+		if err := s.send(MicroBatch{Data: s.batch}); err != nil {
+			return err
+		}
+		s.batch = make([]RawTransaction, 0, s.size)
+	}
+	return nil
+}
+
+func (s *batchSender) send(batch MicroBatch) error {
+	select {
+	case s.out <- batch:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// close sends the last batch, or the error of the source, and closes the channel
+func (s *batchSender) close(format InputFormat, err error) {
+	defer close(s.out)
+	if s.ctx.Err() != nil {
+		log.Printf("%s read interrupted after reading %d transactions: %v\n", strings.ToUpper(string(format)), s.rows, s.ctx.Err())
+		return
+	}
+	if err != nil {
+		log.Printf("%s parse error after reading %d transactions: %v\n", strings.ToUpper(string(format)), s.rows, err)
+		_ = s.send(MicroBatch{Err: fmt.Errorf("an error occured while reading the %s transactions, error: %v", strings.ToUpper(string(format)), err)})
+	}
+	if len(s.batch) > 0 {
+		_ = s.send(MicroBatch{Data: s.batch})
+	}
+	if s.skipped > 0 {
+		log.Printf("Skipped the %d transactions read before the checkpoint\n", s.skipped)
+	}
+	log.Printf("%s read done, source channel closed after reading %d transactions\n", strings.ToUpper(string(format)), s.rows)
+}
@@ -0,0 +1,212 @@
+package io
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/hamba/avro/v2/ocf"
+	"github.com/stretchr/testify/assert"
+)
+
+// readSource reads every transaction of the source, failing on a batch error
+func readSource(t *testing.T, source TransactionSource, data []byte, microBatchSize int, skip int64) []RawTransaction {
+	ch, err := source.Read(context.Background(), bytes.NewReader(data), microBatchSize, skip)
+	assert.NoError(t, err)
+
+	var transactions []RawTransaction
+	for microBatch := range ch {
+		assert.NoError(t, microBatch.Err)
+		assert.LessOrEqual(t, len(microBatch.Data), microBatchSize)
+		transactions = append(transactions, microBatch.Data...)
+	}
+	return transactions
+}
+
+func TestInputFormat(t *testing.T) {
+	assert.Equal(t, FormatCSV, DetectInputFormat("gs://bucket/events/part-1.csv"))
+	assert.Equal(t, FormatNDJSON, DetectInputFormat("events.jsonl.gz"))
+	assert.Equal(t, FormatNDJSON, DetectInputFormat("events.ndjson"))
+	assert.Equal(t, FormatNDJSON, DetectInputFormat("events.json"))
+	assert.Equal(t, FormatParquet, DetectInputFormat("s3://bucket/events.parquet"))
+	assert.Equal(t, FormatAvro, DetectInputFormat("events.avro"))
+	assert.Equal(t, FormatCSV, DetectInputFormat("events"))
+
+	format, err := ParseInputFormat("JSONL")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatNDJSON, format)
+	format, err = ParseInputFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, InputFormat(""), format)
+	_, err = ParseInputFormat("xml")
+	assert.ErrorContains(t, err, "unsupported input format")
+}
+
+func TestNDJSONSource(t *testing.T) {
+	data := `{"ts": "2024-04-15 02:15:07.167", "project_id": "1", "event": "BUY_ITEMS", "props": {"currencySymbol": "SFL"}, "nums": {"currencyValueDecimal": "0.6"}}
+{"ts": "2024-04-15 02:15:08.167", "project_id": 2, "props": "{\"currencySymbol\":\"ETH\"}", "nums": {"currencyValueDecimal": 1.5}, "extra": [1]}
+
+{"ts": "2024-04-15 02:15:09.167", "project_id": "3", "country": null}
+`
+	transactions := readSource(t, NDJSONSource{}, []byte(data), 2, 0)
+	assert.Equal(t, []RawTransaction{
+		{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "1", Event: "BUY_ITEMS", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6"}`},
+		{Timestamp: "2024-04-15 02:15:08.167", ProjectID: "2", Props: `{"currencySymbol":"ETH"}`, Nums: `{"currencyValueDecimal":1.5}`},
+		{Timestamp: "2024-04-15 02:15:09.167", ProjectID: "3"},
+	}, transactions)
+
+	transactions = readSource(t, NDJSONSource{}, []byte(data), 2, 2)
+	assert.Equal(t, []RawTransaction{{Timestamp: "2024-04-15 02:15:09.167", ProjectID: "3"}}, transactions)
+}
+
+func TestNDJSONSource_Array(t *testing.T) {
+	data := ` [{"ts": "2024-04-15 02:15:07.167", "project_id": "1", "props": {"currencySymbol": "SFL"}},
+ {"ts": "2024-04-15 02:15:08.167", "project_id": 2},
+ {"ts": "2024-04-15 02:15:09.167", "project_id": "3"}]
+`
+	transactions := readSource(t, NDJSONSource{}, []byte(data), 2, 1)
+	assert.Equal(t, []RawTransaction{
+		{Timestamp: "2024-04-15 02:15:08.167", ProjectID: "2"},
+		{Timestamp: "2024-04-15 02:15:09.167", ProjectID: "3"},
+	}, transactions)
+
+	assert.Empty(t, readSource(t, NDJSONSource{}, []byte("[]"), 2, 0))
+	assert.Empty(t, readSource(t, NDJSONSource{}, []byte("\n"), 2, 0))
+}
+
+func TestNDJSONSource_Error(t *testing.T) {
+	ch, err := NDJSONSource{}.Read(context.Background(), strings.NewReader(`{"project_id": "1"}`+"\n{\"project_id\": \n"), 10, 0)
+	assert.NoError(t, err)
+
+	var batches []MicroBatch
+	for microBatch := range ch {
+		batches = append(batches, microBatch)
+	}
+	assert.Len(t, batches, 2)
+	assert.ErrorContains(t, batches[0].Err, "an error occured while reading the NDJSON transactions")
+	assert.Equal(t, []RawTransaction{{ProjectID: "1"}}, batches[1].Data)
+}
+
+// parquetTransactions writes the transactions of the projects as a Parquet file, one row group of two rows each
+func parquetTransactions(t *testing.T, projects []string) []byte {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Millisecond}},
+		{Name: "project_id", Type: arrow.BinaryTypes.String},
+		{Name: "props", Type: arrow.StructOf(arrow.Field{Name: "currencySymbol", Type: arrow.BinaryTypes.String})},
+		{Name: "nums", Type: arrow.BinaryTypes.String},
+		{Name: "country", Type: arrow.BinaryTypes.String, Nullable: true},
+		{Name: "extra", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+
+	var buf bytes.Buffer
+	writer, err := pqarrow.NewFileWriter(schema, &buf, nil, pqarrow.DefaultWriterProps())
+	assert.NoError(t, err)
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 167_000_000, time.UTC)
+	for i := 0; i < len(projects); i += 2 {
+		builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+		for _, project := range projects[i:min(i+2, len(projects))] {
+			builder.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(ts.UnixMilli()))
+			builder.Field(1).(*array.StringBuilder).Append(project)
+			props := builder.Field(2).(*array.StructBuilder)
+			props.Append(true)
+			props.FieldBuilder(0).(*array.StringBuilder).Append("SFL")
+			builder.Field(3).(*array.StringBuilder).Append(`{"currencyValueDecimal":"0.6"}`)
+			builder.Field(4).(*array.StringBuilder).AppendNull()
+			builder.Field(5).(*array.Int64Builder).Append(42)
+		}
+		record := builder.NewRecord()
+		assert.NoError(t, writer.Write(record))
+		record.Release()
+		builder.Release()
+	}
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestParquetSource(t *testing.T) {
+	data := parquetTransactions(t, []string{"1", "2", "3", "4", "5"})
+
+	transactions := readSource(t, ParquetSource{}, data, 10, 0)
+	assert.Len(t, transactions, 5)
+	assert.Equal(t, RawTransaction{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "1", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6"}`}, transactions[0])
+
+	// The first row group is not read, then one row of the second is skipped
+	var projects []string
+	for _, transaction := range readSource(t, ParquetSource{}, data, 1, 3) {
+		projects = append(projects, transaction.ProjectID)
+	}
+	assert.Equal(t, []string{"4", "5"}, projects)
+	assert.Empty(t, readSource(t, ParquetSource{}, data, 1, 5))
+
+	// Only the given columns are read
+	transactions = readSource(t, ParquetSource{Columns: []string{"ts", "project_id", "nums"}}, data, 10, 4)
+	assert.Equal(t, []RawTransaction{{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "5", Nums: `{"currencyValueDecimal":"0.6"}`}}, transactions)
+
+	_, err := ParquetSource{}.Read(context.Background(), bufio.NewReader(bytes.NewReader(data)), 10, 0)
+	assert.ErrorContains(t, err, "random access")
+	_, err = ParquetSource{}.Read(context.Background(), bytes.NewReader([]byte("not parquet")), 10, 0)
+	assert.ErrorContains(t, err, "failed to read Parquet file")
+}
+
+func TestAvroSource(t *testing.T) {
+	schema := `{"type": "record", "name": "Transaction", "fields": [
+		{"name": "ts", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "project_id", "type": "string"},
+		{"name": "props", "type": {"type": "map", "values": "string"}},
+		{"name": "nums", "type": "string"},
+		{"name": "country", "type": ["null", "string"], "default": null},
+		{"name": "extra", "type": "int"}
+	]}`
+	var buf bytes.Buffer
+	encoder, err := ocf.NewEncoder(schema, &buf)
+	assert.NoError(t, err)
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 167_000_000, time.UTC)
+	for _, project := range []string{"1", "2", "3"} {
+		var country any
+		if project == "2" {
+			country = "DE"
+		}
+		assert.NoError(t, encoder.Encode(map[string]any{"ts": ts, "project_id": project, "props": map[string]any{"currencySymbol": "SFL"},
+			"nums": `{"currencyValueDecimal":"0.6"}`, "country": country, "extra": 42}))
+	}
+	assert.NoError(t, encoder.Close())
+
+	transactions := readSource(t, AvroSource{}, buf.Bytes(), 2, 1)
+	assert.Equal(t, []RawTransaction{
+		{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "2", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6"}`, Country: "DE"},
+		{Timestamp: "2024-04-15 02:15:07.167", ProjectID: "3", Props: `{"currencySymbol":"SFL"}`, Nums: `{"currencyValueDecimal":"0.6"}`},
+	}, transactions)
+
+	_, err = AvroSource{}.Read(context.Background(), strings.NewReader("not avro"), 10, 0)
+	assert.ErrorContains(t, err, "failed to read Avro file")
+}
+
+func TestTransactionFiles_Formats(t *testing.T) {
+	dir := t.TempDir()
+	parquetFile := filepath.Join(dir, "events.parquet")
+	assert.NoError(t, os.WriteFile(parquetFile, parquetTransactions(t, []string{"1", "2", "3"}), 0o600))
+	ndjsonFile := filepath.Join(dir, "events.ndjson")
+	assert.NoError(t, os.WriteFile(ndjsonFile, []byte(`{"project_id": "4"}`+"\n"), 0o600))
+
+	files := TransactionFiles{Paths: []string{parquetFile, ndjsonFile}, Parallelism: 2}
+	ch, err := files.Read(context.Background(), 10, map[string]int64{parquetFile: 1})
+	assert.NoError(t, err)
+
+	projects := make(map[string][]string)
+	for microBatch := range ch {
+		assert.NoError(t, microBatch.Err)
+		for _, transaction := range microBatch.Data {
+			projects[microBatch.File] = append(projects[microBatch.File], transaction.ProjectID)
+		}
+	}
+	assert.Equal(t, map[string][]string{parquetFile: {"2", "3"}, ndjsonFile: {"4"}}, projects)
+}
@@ -1,7 +1,13 @@
 package io
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
+	"time"
+
 	"github.com/c2fo/vfs/v6/utils"
 
 	"github.com/c2fo/vfs/v6"
@@ -12,6 +18,7 @@ import (
 type VfsReaderWriter struct {
 	file vfs.File
 	path string
+	mu   sync.Mutex // Guards the position of the file for ReadAt
 }
 
 // Open initializes a VfsReader for both GCS and local files.
@@ -28,8 +35,8 @@ func Open(path string) (*VfsReaderWriter, error) {
 	}
 
 	return &VfsReaderWriter{
-		file,
-		path,
+		file: file,
+		path: path,
 	}, nil
 }
 
@@ -38,12 +45,113 @@ func (r *VfsReaderWriter) Read(p []byte) (int, error) {
 	return r.file.Read(p)
 }
 
+// Seek implements the io.Seeker interface by seeking the Vfs file.
+func (r *VfsReaderWriter) Seek(offset int64, whence int) (int64, error) {
+	return r.file.Seek(offset, whence)
+}
+
+// ReadAt implements the io.ReaderAt interface by seeking the Vfs file then reading it, e.g. for the Parquet files.
+func (r *VfsReaderWriter) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.file, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
+
 // Write implements the io.Writer interface by writing data to the Vfs file.
 func (r *VfsReaderWriter) Write(p []byte) (int, error) {
 	return r.file.Write(p)
 }
 
+// Exists returns true if the file exists.
+func (r *VfsReaderWriter) Exists() (bool, error) {
+	return r.file.Exists()
+}
+
+// Delete deletes the file.
+func (r *VfsReaderWriter) Delete() error {
+	return r.file.Delete()
+}
+
 // Close closes the file after operations are done.
 func (r *VfsReaderWriter) Close() error {
 	return r.file.Close()
 }
+
+// DeleteIfExists deletes the file of the path, nothing is done when it does not exist.
+func DeleteIfExists(path string) error {
+	file, err := Open(path)
+	if err != nil {
+		return err
+	}
+	exists, err := file.Exists()
+	if err != nil || !exists {
+		return err
+	}
+	if err := file.Delete(); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", path, err)
+	}
+	return nil
+}
+
+// ReadJSON decodes the JSON file of the path into v, e.g. a checkpoint, and returns false when it does not exist.
+func ReadJSON(path string, v any) (bool, error) {
+	reader, err := Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	exists, err := reader.Exists()
+	if err != nil || !exists {
+		return false, err
+	}
+	if err := json.NewDecoder(reader).Decode(v); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return true, nil
+}
+
+// WriteJSON writes v as JSON to the file of the path, replacing it.
+func WriteJSON(path string, v any) error {
+	writer, err := Open(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return writer.Close()
+}
+
+// FileVersion identifies the content of a file by its size and last modification time, e.g. to detect a replaced input.
+type FileVersion struct {
+	Size         uint64    `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// StatFile returns the size and last modification time of the file of the path.
+func StatFile(path string) (FileVersion, error) {
+	file, err := Open(path)
+	if err != nil {
+		return FileVersion{}, err
+	}
+	defer file.Close()
+
+	size, err := file.file.Size()
+	if err != nil {
+		return FileVersion{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	modified, err := file.file.LastModified()
+	if err != nil {
+		return FileVersion{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return FileVersion{Size: size, LastModified: modified.UTC()}, nil
+}
@@ -1,67 +1,275 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"hodctl/pkg/io"
 	"hodctl/pkg/sink"
 	"hodctl/pkg/worker"
-	stdio "io"
 	"log"
+	"maps"
+	"slices"
+	"time"
 )
 
-type Do = func(batch io.MicroBatch, currencyValues *io.Currency2Values) ([]worker.Agg, <-chan worker.Outlier, error)
+type Do = func(batch io.MicroBatch, prices *io.PriceTable, spec *worker.AggSpec) ([]worker.Agg, <-chan worker.Outlier, error)
 
 const ChannelBufferSize = 100
 
-func DoAgg(currencyReader stdio.Reader, transactionsReader stdio.Reader, aggSink sink.AggSink, errSink sink.ErrSink, parallelism int, microBatchSize int) error {
-	currencyValues, err := io.ReadCurrencyValues(currencyReader)
+// SinkBatchSize is the number of aggregates written to the sink at once
+const SinkBatchSize = 10_000
+
+// DoAgg reads the transactions, aggregates them with the prices and writes the aggregates to the sink, and the outliers to the error sink.
+// When the context is done the work stops, nothing is written to the aggregation sink and the context error is returned.
+// The error sink always receives every outlier found before DoAgg returns.
+// The groups are reduced by the partitions of the reduce options within their memory budget, spilling to disk beyond it.
+// With checkpoints, the transactions are processed in segments of checkpoints.Every rows, a checkpoint being saved after each of them,
+// and a resumed aggregation skips the rows of each file recorded by the last checkpoint. Checkpoints can be nil.
+func DoAgg(ctx context.Context, prices *io.PriceTable, transactions io.TransactionFiles, aggSink sink.AggSink, errSink sink.ErrSink, spec *worker.AggSpec, parallelism int, microBatchSize int, reduce worker.ReduceOptions, checkpoints *Checkpoints) error {
+	log.Printf("Read %d currency values (%d days of historical prices) in %v\n", prices.Len(), prices.Days(), prices.QuoteCurrencies())
+	if !prices.SnapshotTime.IsZero() {
+		log.Printf("Currency values fetched at %s from %v\n", prices.SnapshotTime.Format(time.RFC3339), prices.Sources)
+	}
+	if err := spec.Validate(prices); err != nil {
+		return fmt.Errorf("invalid currency values: %v", err)
+	}
+
+	reducer, err := worker.NewPartitionedReducer(spec, reduce)
 	if err != nil {
-		return fmt.Errorf("failed to read currency values: %v", err)
+		return err
+	}
+	defer func() {
+		if err := reducer.Close(); err != nil {
+			log.Printf("Error removing the spilled aggregates: %v", err)
+		}
+	}()
+
+	stats := &worker.RunStats{}
+	var rows int64
+	var files map[string]int64
+	if checkpoints != nil {
+		defer checkpoints.release()
+		if rows, files, err = checkpoints.start(reducer, stats); err != nil {
+			return err
+		}
 	}
-	log.Printf("Read %d currency values\n", len(currencyValues))
 
-	sourceTransactionCh, err := io.ReadCSV(transactionsReader, microBatchSize)
+	// Stops the reader and the workers when the reduce fails
+	workCtx, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+
+	sourceTransactionCh, err := transactions.Read(workCtx, microBatchSize, files)
 	if err != nil {
 		return fmt.Errorf("failed to read transactions: %v", err)
 	}
 
-	// Create the outlier channel
+	// Create the outlier channel, the outliers saved by the checkpoints come first
 	outlierCh := make(chan worker.Outlier, ChannelBufferSize)
-	go errSink.WriteError(outlierCh)
+	outlierDone := make(chan error, 1)
+	go func() {
+		outlierDone <- errSink.WriteError(outlierCh)
+	}()
+	if checkpoints != nil {
+		err = checkpoints.replayOutliers(workCtx, outlierCh)
+	}
+
+	doAggBatch := func(batch io.MicroBatch, outlierChan chan worker.Outlier, prices *io.PriceTable, spec *worker.AggSpec) ([]worker.Agg, error) {
+		return DoAggBatch(batch, outlierChan, prices, spec, stats)
+	}
 
-	partialAgg := worker.ParallelProcessing(sourceTransactionCh, outlierCh, DoAggBatch, &currencyValues, parallelism)
+	// map and reduce each segment
+	var checkpointErr error
+	segments := &segmenter{source: sourceTransactionCh, every: checkpoints.every(), files: maps.Clone(files)}
+	for err == nil && checkpointErr == nil {
+		segmentOutliers := outlierCh
+		var spool *outlierSpool
+		spoolDone := make(chan error, 1)
+		if checkpoints != nil {
+			// The outliers of the segment are spooled for its checkpoint
+			if spool, checkpointErr = checkpoints.newSpool(); checkpointErr != nil {
+				break
+			}
+			segmentOutliers = make(chan worker.Outlier, ChannelBufferSize)
+			go func() {
+				spoolDone <- spoolOutliers(segmentOutliers, outlierCh, spool)
+			}()
+		}
+
+		// The partitions are only closed once every worker is done
+		batches, segmentRows := segments.next(workCtx)
+		partialAggs := worker.ParallelProcessing(workCtx, batches, segmentOutliers, doAggBatch, prices, spec, parallelism, reducer.Partitions())
+		err = reducer.Reduce(partialAggs, stopWork)
+		rows += <-segmentRows
+		if checkpoints == nil {
+			break
+		}
+
+		close(segmentOutliers)
+		checkpointErr = <-spoolDone
+		if err == nil && checkpointErr == nil && ctx.Err() == nil && !segments.done {
+			checkpointErr = checkpoints.save(reducer, rows, maps.Clone(segments.files), stats, spool)
+		}
+		spool.remove()
+		if segments.done {
+			break
+		}
+	}
+
+	// Then the error sink is flushed
+	stopWork()
+	close(outlierCh)
+	outlierErr := <-outlierDone
 
-	// reduce
-	agg, err := worker.DoAggReducer(partialAgg)
 	if err != nil {
 		return fmt.Errorf("failed to reduce aggregated transactions: %v", err)
 	}
+	if checkpointErr != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", checkpointErr)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("aggregation interrupted: %w", ctx.Err())
+	}
+	if outlierErr != nil {
+		return fmt.Errorf("failed to write outliers: %v", outlierErr)
+	}
 
-	log.Printf("Generated %d aggregated transactions\n", len(agg))
-	size, err := aggSink.WriteAgg(agg)
+	log.Printf("Run stats: %s\n", stats)
+	fileCounts := stats.FileCounts()
+	if len(fileCounts) > 1 {
+		for _, file := range slices.Sorted(maps.Keys(fileCounts)) {
+			log.Printf("Run stats of %s: %s\n", file, fileCounts[file])
+		}
+	}
+	if reducer.Spills() > 0 {
+		log.Printf("Spilled the aggregates to disk %d times within the memory budget\n", reducer.Spills())
+	}
+	if err := spec.CheckPriceAgeAt(prices, reducer.WindowEnd()); err != nil {
+		return err
+	}
+	size := 0
+	count, err := reducer.Emit(SinkBatchSize, func(aggs []worker.Agg) error {
+		written, err := aggSink.WriteAgg(aggs)
+		size += written
+		return err
+	})
+	if err == nil && count == 0 {
+		// The sink still writes its empty output, e.g. the CSV header
+		_, err = aggSink.WriteAgg(nil)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to write aggregated transactions: %v", err)
 	}
+	log.Printf("Generated %d aggregated transactions\n", count)
 	log.Printf("Wrote %d Agg to sink\n", size)
-	close(outlierCh)
 
 	return nil
 }
 
-// DoAggBatch processes a batch of transactions and returns the aggregated results.
-func DoAggBatch(batch io.MicroBatch, outlierChan chan worker.Outlier, currencyValues *io.Currency2Values) ([]worker.Agg, error) {
+// DoAggBatch processes a batch of transactions, counts them in the run stats, and returns the aggregated results.
+func DoAggBatch(batch io.MicroBatch, outlierChan chan worker.Outlier, prices *io.PriceTable, spec *worker.AggSpec, stats *worker.RunStats) ([]worker.Agg, error) {
+	// A file that cannot be opened or read fails the aggregation, the rows that cannot be parsed are only logged by the source
+	var fileErr *io.FileError
+	if errors.As(batch.Err, &fileErr) {
+		return nil, batch.Err
+	}
 
 	// Clean up the batch
-	cleanedBatch, err := worker.DoCleanup(batch, outlierChan)
+	cleanedBatch, err := worker.DoCleanup(batch, outlierChan, spec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to clean up batch: %v", err)
 	}
 
+	// Handle the transactions in a currency without price
+	cleanedBatch, err = worker.DoPriceCheck(cleanedBatch, outlierChan, prices, spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate batch: %v", err)
+	}
+	stats.AddBatch(len(batch.Data), cleanedBatch)
+
 	// Do Aggregation
-	agg, err := worker.DoAgg(cleanedBatch, currencyValues)
+	agg, err := worker.DoAgg(cleanedBatch, prices, spec)
 	if err != nil {
 		return nil, fmt.Errorf("failed to aggregate batch: %v", err)
 	}
 
 	return agg, nil
 }
+
+// spoolOutliers forwards the outliers to the error sink and writes them to the spool, until the input is closed
+func spoolOutliers(in <-chan worker.Outlier, out chan<- worker.Outlier, spool *outlierSpool) error {
+	var err error
+	for outlier := range in {
+		if err == nil {
+			err = spool.write(outlier)
+		}
+		out <- outlier
+	}
+	return err
+}
+
+// segmenter splits the batches of transactions into segments of at least every rows, all of them when every is 0
+type segmenter struct {
+	source  <-chan io.MicroBatch
+	every   int64
+	pending *io.MicroBatch   // First batch of the next segment
+	files   map[string]int64 // Rows sent of each file, once the rows of the segment are received
+	done    bool             // Whether the source is exhausted, once the rows of the segment are received
+}
+
+// next returns the batches of the next segment, and a channel receiving its number of rows once it ends
+func (s *segmenter) next(ctx context.Context) (<-chan io.MicroBatch, <-chan int64) {
+	batches := make(chan io.MicroBatch, ChannelBufferSize)
+	segmentRows := make(chan int64, 1)
+
+	go func() {
+		var rows int64
+		defer func() { segmentRows <- rows }()
+
+		for s.every <= 0 || rows < s.every {
+			batch, ok := s.pending, true
+			if batch == nil {
+				select {
+				case next, more := <-s.source:
+					batch, ok = &next, more
+				case <-ctx.Done():
+					ok = false
+				}
+			}
+			s.pending = nil
+			if !ok {
+				s.done = true
+				close(batches)
+				return
+			}
+			select {
+			case batches <- *batch:
+				rows += int64(len(batch.Data))
+				if batch.File != "" {
+					if s.files == nil {
+						s.files = make(map[string]int64)
+					}
+					s.files[batch.File] += int64(len(batch.Data))
+				}
+			case <-ctx.Done():
+				s.done = true
+				close(batches)
+				return
+			}
+		}
+		close(batches)
+
+		// Only a segment followed by other rows is checkpointed
+		select {
+		case next, more := <-s.source:
+			if more {
+				s.pending = &next
+			} else {
+				s.done = true
+			}
+		case <-ctx.Done():
+			s.done = true
+		}
+	}()
+	return batches, segmentRows
+}
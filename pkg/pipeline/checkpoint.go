@@ -0,0 +1,309 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hodctl/pkg/io"
+	"hodctl/pkg/worker"
+	stdio "io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	checkpointFile         = "checkpoint.json"
+	runName                = "reducer-%03d-%s"
+	outlierPartName        = "outliers-%05d.gob"
+	DefaultCheckpointEvery = 1_000_000 // Default number of rows between two checkpoints
+)
+
+// AggCheckpoint records the progress of an aggregation
+type AggCheckpoint struct {
+	Fingerprint  string                      `json:"fingerprint"`   // Fingerprint of the aggregation, only the same aggregation can be resumed
+	Rows         int64                       `json:"rows"`          // Rows of the transactions processed
+	Files        map[string]int64            `json:"files"`         // Rows processed of each transactions file, by path
+	Stats        worker.RunCounts            `json:"stats"`         // Run stats of the rows processed
+	FileStats    map[string]worker.RunCounts `json:"file_stats"`    // Run stats of the rows processed of each file, by path
+	Runs         [][]string                  `json:"runs"`          // Names of the runs of each reducer partition
+	WindowEnd    time.Time                   `json:"window_end"`    // End of the latest time bucket of the aggregates
+	OutlierParts int                         `json:"outlier_parts"` // Number of part files of the outliers found
+	SavedAt      time.Time                   `json:"saved_at"`      // Time of the checkpoint
+}
+
+// Checkpoints periodically saves the state of an aggregation to a local or VFS location: the number of rows processed,
+// the runs of the reducer with every group combined so far, and the outliers found. An aggregation resumed from the
+// last checkpoint skips the rows processed and produces the same output as an uninterrupted one.
+type Checkpoints struct {
+	Path        string // Location of the checkpoint files, e.g. gs://bucket/checkpoints/daily-agg
+	Every       int64  // Number of rows between two checkpoints, DefaultCheckpointEvery when 0
+	Resume      bool   // Resume from the last checkpoint, otherwise any checkpoint left is discarded
+	Fingerprint string // Fingerprint of the aggregation arguments
+
+	checkpoint AggCheckpoint
+	names      map[string]string // Names of the runs saved, by local path
+	localDir   string            // Local copies of the saved runs and the outliers spooled since the last checkpoint
+}
+
+// every returns the number of rows between two checkpoints, all of them without checkpoints
+func (c *Checkpoints) every() int64 {
+	if c == nil {
+		return 0
+	}
+	if c.Every <= 0 {
+		return DefaultCheckpointEvery
+	}
+	return c.Every
+}
+
+// start loads the last checkpoint when resuming, restores the reducer and the run stats,
+// and returns the rows processed in total and of each file
+func (c *Checkpoints) start(reducer *worker.PartitionedReducer, stats *worker.RunStats) (int64, map[string]int64, error) {
+	c.checkpoint = AggCheckpoint{Fingerprint: c.Fingerprint}
+	c.names = make(map[string]string)
+	var err error
+	if c.localDir, err = os.MkdirTemp("", "hodctl-checkpoint-"); err != nil {
+		return 0, nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	var checkpoint AggCheckpoint
+	found, err := io.ReadJSON(c.path(checkpointFile), &checkpoint)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !found {
+		if c.Resume {
+			log.Printf("No checkpoint found in %s, aggregating from the first row\n", c.Path)
+		}
+		return 0, nil, nil
+	}
+
+	if !c.Resume {
+		log.Printf("Discarding the checkpoint of a previous aggregation in %s, after %d rows\n", c.Path, checkpoint.Rows)
+		c.checkpoint = checkpoint
+		if err := c.Clean(); err != nil {
+			return 0, nil, err
+		}
+		c.checkpoint = AggCheckpoint{Fingerprint: c.Fingerprint}
+		return 0, nil, nil
+	}
+
+	if checkpoint.Fingerprint != c.Fingerprint {
+		return 0, nil, fmt.Errorf("cannot resume the aggregation checkpointed in %s, it was started with other arguments or input files", c.Path)
+	}
+	state := worker.ReducerState{WindowEnd: checkpoint.WindowEnd}
+	for _, names := range checkpoint.Runs {
+		var runs []string
+		for _, name := range names {
+			run := filepath.Join(c.localDir, name)
+			if err := download(c.path(name), run); err != nil {
+				return 0, nil, err
+			}
+			c.names[run] = name
+			runs = append(runs, run)
+		}
+		state.Runs = append(state.Runs, runs)
+	}
+	if err := reducer.Restore(state); err != nil {
+		return 0, nil, fmt.Errorf("cannot resume the aggregation checkpointed in %s: %w", c.Path, err)
+	}
+	stats.Restore(checkpoint.Stats, checkpoint.FileStats)
+	c.checkpoint = checkpoint
+
+	log.Printf("Resuming the aggregation after %d rows, checkpointed at %s\n", checkpoint.Rows, checkpoint.SavedAt.Format(time.RFC3339))
+	return checkpoint.Rows, checkpoint.Files, nil
+}
+
+// replayOutliers sends the outliers saved by the checkpoints to the error sink
+func (c *Checkpoints) replayOutliers(ctx context.Context, outliers chan<- worker.Outlier) error {
+	for i := 1; i <= c.checkpoint.OutlierParts; i++ {
+		part, err := io.Open(c.path(fmt.Sprintf(outlierPartName, i)))
+		if err != nil {
+			return fmt.Errorf("failed to open outlier part: %w", err)
+		}
+		err = decodeOutliers(ctx, part, outliers)
+		_ = part.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read outlier part %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func decodeOutliers(ctx context.Context, reader stdio.Reader, outliers chan<- worker.Outlier) error {
+	decoder := gob.NewDecoder(bufio.NewReader(reader))
+	for {
+		var outlier worker.Outlier
+		if err := decoder.Decode(&outlier); err != nil {
+			if errors.Is(err, stdio.EOF) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case outliers <- outlier:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// save saves the new runs of the reducer and the spooled outliers, then records the checkpoint
+func (c *Checkpoints) save(reducer *worker.PartitionedReducer, rows int64, files map[string]int64, stats *worker.RunStats, spool *outlierSpool) error {
+	state, err := reducer.Checkpoint()
+	if err != nil {
+		return err
+	}
+
+	c.checkpoint.Runs = nil
+	for p, runs := range state.Runs {
+		var names []string
+		for _, run := range runs {
+			name, saved := c.names[run]
+			if !saved {
+				name = fmt.Sprintf(runName, p, filepath.Base(run))
+				if err := upload(run, c.path(name)); err != nil {
+					return err
+				}
+				c.names[run] = name
+			}
+			names = append(names, name)
+		}
+		c.checkpoint.Runs = append(c.checkpoint.Runs, names)
+	}
+
+	if spool.count > 0 {
+		if err := spool.close(); err != nil {
+			return err
+		}
+		if err := upload(spool.path, c.path(fmt.Sprintf(outlierPartName, c.checkpoint.OutlierParts+1))); err != nil {
+			return err
+		}
+		c.checkpoint.OutlierParts++
+	}
+
+	c.checkpoint.Rows = rows
+	c.checkpoint.Files = files
+	c.checkpoint.Stats = stats.Counts()
+	c.checkpoint.FileStats = stats.FileCounts()
+	c.checkpoint.WindowEnd = state.WindowEnd
+	c.checkpoint.SavedAt = time.Now().UTC()
+	if err := io.WriteJSON(c.path(checkpointFile), c.checkpoint); err != nil {
+		return err
+	}
+
+	log.Printf("Checkpoint saved after %d rows in %s\n", rows, c.Path)
+	return nil
+}
+
+// Clean removes the checkpoint and the files it records, once the aggregation output is complete
+func (c *Checkpoints) Clean() error {
+	for _, names := range c.checkpoint.Runs {
+		for _, name := range names {
+			if err := io.DeleteIfExists(c.path(name)); err != nil {
+				return err
+			}
+		}
+	}
+	for i := 1; i <= c.checkpoint.OutlierParts; i++ {
+		if err := io.DeleteIfExists(c.path(fmt.Sprintf(outlierPartName, i))); err != nil {
+			return err
+		}
+	}
+	return io.DeleteIfExists(c.path(checkpointFile))
+}
+
+// release removes the local copies of the saved runs
+func (c *Checkpoints) release() {
+	if err := os.RemoveAll(c.localDir); err != nil {
+		log.Printf("Error removing the checkpoint directory: %v", err)
+	}
+}
+
+// newSpool creates the local file spooling the outliers until the next checkpoint
+func (c *Checkpoints) newSpool() (*outlierSpool, error) {
+	file, err := os.CreateTemp(c.localDir, "outliers-*.gob")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outlier spool: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	return &outlierSpool{file: file, writer: writer, encoder: gob.NewEncoder(writer), path: file.Name()}, nil
+}
+
+func (c *Checkpoints) path(name string) string {
+	return strings.TrimSuffix(c.Path, "/") + "/" + name
+}
+
+// outlierSpool writes the outliers found since the last checkpoint to a local file
+type outlierSpool struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *gob.Encoder
+	path    string
+	count   int
+}
+
+func (s *outlierSpool) write(outlier worker.Outlier) error {
+	s.count++
+	return s.encoder.Encode(outlier)
+}
+
+func (s *outlierSpool) close() error {
+	if err := s.writer.Flush(); err != nil {
+		_ = s.file.Close()
+		return fmt.Errorf("failed to write outlier spool: %w", err)
+	}
+	return s.file.Close()
+}
+
+// remove closes and removes the spool file
+func (s *outlierSpool) remove() {
+	_ = s.file.Close()
+	_ = os.Remove(s.path)
+}
+
+// upload copies the local file to the checkpoint location
+func upload(localPath string, path string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", localPath, err)
+	}
+	defer file.Close()
+
+	writer, err := io.Open(path)
+	if err != nil {
+		return err
+	}
+	if _, err := stdio.Copy(writer, file); err != nil {
+		_ = writer.Close()
+		return fmt.Errorf("failed to save %s: %w", path, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to save %s: %w", path, err)
+	}
+	return nil
+}
+
+// download copies the file of the checkpoint location to a local file
+func download(path string, localPath string) error {
+	reader, err := io.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", localPath, err)
+	}
+	if _, err := stdio.Copy(file, reader); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,159 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Provider resolves a secret from its reference, e.g. the name of an environment variable
+type Provider interface {
+	Resolve(ctx context.Context, ref string) (Secret, error)
+}
+
+// ProviderFunc adapts a function to a Provider
+type ProviderFunc func(ctx context.Context, ref string) (Secret, error)
+
+// Resolve calls f(ctx, ref)
+func (f ProviderFunc) Resolve(ctx context.Context, ref string) (Secret, error) {
+	return f(ctx, ref)
+}
+
+// Schemes of the built-in providers
+const (
+	SchemeEnv      = "env"      // env:NAME reads the environment variable NAME
+	SchemeFile     = "file"     // file:/path reads the first line of the file
+	SchemeCommand  = "cmd"      // cmd:command runs the command with sh -c and reads the first line of its output
+	SchemeKeychain = "keychain" // keychain:service/account reads the OS keychain (macOS Keychain, Linux Secret Service)
+)
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		SchemeEnv:      ProviderFunc(resolveEnv),
+		SchemeFile:     ProviderFunc(resolveFile),
+		SchemeCommand:  ProviderFunc(resolveCommand),
+		SchemeKeychain: ProviderFunc(resolveKeychain),
+	}
+)
+
+// Register makes a provider available for the references of the given scheme (scheme:ref), replacing any previous one
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = provider
+}
+
+// Schemes returns the sorted schemes of the registered providers
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(providers))
+	for scheme := range providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Resolve resolves a secret reference of the form scheme:ref, e.g. env:COINGECKO_API_KEY, with the provider of its scheme.
+// Errors never contain the secret.
+func Resolve(ctx context.Context, reference string) (Secret, error) {
+	scheme, ref, found := strings.Cut(reference, ":")
+	if !found || ref == "" {
+		return "", fmt.Errorf("invalid secret reference, expected scheme:ref with scheme one of %s", strings.Join(Schemes(), ", "))
+	}
+
+	mu.RLock()
+	provider, exists := providers[scheme]
+	mu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("unknown secret provider %q, expected one of %s", scheme, strings.Join(Schemes(), ", "))
+	}
+
+	// The reference is not part of the errors as it could be a command line with credentials
+	secret, err := provider.Resolve(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret with the %s provider: %w", scheme, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("the secret resolved by the %s provider is empty", scheme)
+	}
+	return secret, nil
+}
+
+func resolveEnv(_ context.Context, name string) (Secret, error) {
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return Secret(strings.TrimSpace(value)), nil
+}
+
+func resolveFile(_ context.Context, path string) (Secret, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		log.Printf("WARNING: the secret file %s can be read by other users (mode %s), restrict it with chmod 600\n", path, info.Mode().Perm())
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return firstLine(content), nil
+}
+
+func resolveCommand(ctx context.Context, command string) (Secret, error) {
+	return run(ctx, "sh", "-c", command)
+}
+
+func resolveKeychain(ctx context.Context, ref string) (Secret, error) {
+	service, account, _ := strings.Cut(ref, "/")
+	switch runtime.GOOS {
+	case "darwin":
+		args := []string{"find-generic-password", "-w", "-s", service}
+		if account != "" {
+			args = append(args, "-a", account)
+		}
+		return run(ctx, "security", args...)
+	case "linux":
+		args := []string{"lookup", "service", service}
+		if account != "" {
+			args = append(args, "account", account)
+		}
+		return run(ctx, "secret-tool", args...)
+	default:
+		return "", fmt.Errorf("the keychain provider is not supported on %s", runtime.GOOS)
+	}
+}
+
+// run runs the command and returns the first line of its output. The output is never part of the errors.
+func run(ctx context.Context, name string, args ...string) (Secret, error) {
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("%s exited with code %d", name, exitErr.ExitCode())
+		}
+		return "", fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return firstLine(stdout.Bytes()), nil
+}
+
+func firstLine(content []byte) Secret {
+	line, _, _ := strings.Cut(string(content), "\n")
+	return Secret(strings.TrimSpace(line))
+}
@@ -0,0 +1,62 @@
+// Package secret holds credentials so they never appear in logs or error messages,
+// and resolves them from the environment, files or pluggable secret providers.
+package secret
+
+import (
+	"fmt"
+	"io"
+)
+
+// masked replaces the value of a secret wherever it is printed
+const masked = "****"
+
+// minRevealLength is the shortest secret whose first characters can be shown,
+// shorter secrets are fully masked so that the visible part never gives most of them away
+const minRevealLength = 12
+
+// revealedPrefix is the number of characters shown of the secrets of at least minRevealLength
+const revealedPrefix = 3
+
+// Secret is a credential, e.g. an API key. It is masked when formatted with any fmt verb or marshalled,
+// Reveal must be called explicitly to get its value.
+type Secret string
+
+// Reveal returns the value of the secret
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// Mask returns the masked secret: the first characters of long secrets followed by ****, **** otherwise
+func (s Secret) Mask() string {
+	if s == "" {
+		return ""
+	}
+	if len(s) < minRevealLength {
+		return masked
+	}
+	return string(s[:revealedPrefix]) + masked
+}
+
+// String implements fmt.Stringer with the masked secret
+func (s Secret) String() string {
+	return s.Mask()
+}
+
+// GoString implements fmt.GoStringer with the masked secret
+func (s Secret) GoString() string {
+	return fmt.Sprintf("secret.Secret(%q)", s.Mask())
+}
+
+// Format implements fmt.Formatter so that every verb (%s, %v, %q, %x...) prints the masked secret
+func (s Secret) Format(f fmt.State, verb rune) {
+	if verb == 'v' && f.Flag('#') {
+		_, _ = io.WriteString(f, s.GoString())
+		return
+	}
+	_, _ = io.WriteString(f, s.Mask())
+}
+
+// MarshalText implements encoding.TextMarshaler with the masked secret, it is also used by encoding/json
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.Mask()), nil
+}
@@ -0,0 +1,103 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecret_Mask(t *testing.T) {
+	tests := []struct {
+		secret Secret
+		masked string
+	}{
+		{"", ""},
+		{"a", "****"},
+		{"abcd", "****"},
+		{"CG-12345", "****"},
+		{"CG-12345678", "****"},
+		{"CG-123456789", "CG-****"},
+		{"CG-abcdefghijklmnopqrstuvwxyz", "CG-****"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.masked, tt.secret.Mask(), "length %d", len(tt.secret))
+	}
+}
+
+func TestSecret_NeverFormatted(t *testing.T) {
+	const key = "CG-very-secret-api-key"
+	holder := struct {
+		Name   string
+		APIKey Secret
+	}{"coingecko", key}
+
+	for _, verb := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x", "%X", "%10s"} {
+		assert.NotContains(t, fmt.Sprintf(verb, Secret(key)), "very-secret", verb)
+		assert.NotContains(t, fmt.Sprintf(verb, holder), "very-secret", verb)
+		assert.NotContains(t, fmt.Sprintf(verb, &holder), "very-secret", verb)
+	}
+	assert.NotContains(t, fmt.Errorf("request failed with %v", Secret(key)).Error(), "very-secret")
+
+	data, err := json.Marshal(holder)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Name":"coingecko","APIKey":"CG-****"}`, string(data))
+	assert.Equal(t, key, Secret(key).Reveal())
+}
+
+func TestResolve_Env(t *testing.T) {
+	t.Setenv("HODCTL_TEST_KEY", " CG-from-env \n")
+	key, err := Resolve(context.Background(), "env:HODCTL_TEST_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, "CG-from-env", key.Reveal())
+
+	_, err = Resolve(context.Background(), "env:HODCTL_TEST_MISSING_KEY")
+	assert.ErrorContains(t, err, "environment variable HODCTL_TEST_MISSING_KEY is not set")
+}
+
+func TestResolve_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coingecko.key")
+	assert.NoError(t, os.WriteFile(path, []byte("CG-from-file\nignored\n"), 0o600))
+
+	key, err := Resolve(context.Background(), "file:"+path)
+	assert.NoError(t, err)
+	assert.Equal(t, "CG-from-file", key.Reveal())
+}
+
+func TestResolve_Command(t *testing.T) {
+	key, err := Resolve(context.Background(), "cmd:echo CG-from-command")
+	assert.NoError(t, err)
+	assert.Equal(t, "CG-from-command", key.Reveal())
+
+	// The output of a failed command is not part of the error
+	_, err = Resolve(context.Background(), "cmd:echo CG-leaked-key; exit 3")
+	assert.ErrorContains(t, err, "sh exited with code 3")
+	assert.NotContains(t, err.Error(), "CG-leaked-key")
+}
+
+func TestResolve_Errors(t *testing.T) {
+	_, err := Resolve(context.Background(), "CG-pasted-key")
+	assert.ErrorContains(t, err, "invalid secret reference")
+
+	_, err = Resolve(context.Background(), "vault:coingecko")
+	assert.ErrorContains(t, err, `unknown secret provider "vault"`)
+
+	_, err = Resolve(context.Background(), "cmd:true")
+	assert.ErrorContains(t, err, "is empty")
+}
+
+func TestRegister(t *testing.T) {
+	Register("test", ProviderFunc(func(_ context.Context, ref string) (Secret, error) {
+		return Secret(strings.ToUpper(ref)), nil
+	}))
+
+	key, err := Resolve(context.Background(), "test:cg-registered")
+	assert.NoError(t, err)
+	assert.Equal(t, "CG-REGISTERED", key.Reveal())
+	assert.Contains(t, Schemes(), "test")
+}
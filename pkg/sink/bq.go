@@ -18,53 +18,94 @@ type BigQuerySink struct {
 	client    *bigquery.Client
 	datasetID string
 	tableID   string
+	schema    bigquery.Schema
+	spec      *worker.AggSpec
 	ctx       context.Context
 }
 
-// Constants for schema fields
-const (
-	fieldDate                 = "Date"
-	fieldProjectID            = "ProjectId"
-	fieldNumberOfTransactions = "NumberOfTransactions"
-	fieldTotalVolumeUsd       = "TotalVolumeUsd"
-)
+// maxClusteringFields is the maximum number of clustering fields of a BigQuery table
+const maxClusteringFields = 4
+
+// aggSchema defines the schema of the aggregation table, with a field for each dimension and a total volume field for each reporting currency.
+// Hourly buckets are a TIMESTAMP, the other buckets the DATE they start on.
+func aggSchema(spec *worker.AggSpec) bigquery.Schema {
+	bucketType := bigquery.DateFieldType
+	if spec.TimeBucket() == worker.BucketHour {
+		bucketType = bigquery.TimestampFieldType
+	}
+	schema := bigquery.Schema{
+		{Name: spec.BucketColumn(), Type: bucketType, Required: true},
+	}
+	for _, dimension := range spec.Dimensions {
+		schema = append(schema, &bigquery.FieldSchema{Name: worker.DimensionColumn(dimension), Type: bigquery.StringFieldType, Required: true})
+	}
+	schema = append(schema, &bigquery.FieldSchema{Name: worker.ColumnNumberOfTransactions, Type: bigquery.IntegerFieldType, Required: true})
+	for _, currency := range spec.Currencies {
+		schema = append(schema, &bigquery.FieldSchema{Name: worker.VolumeColumn(currency), Type: bigquery.FloatFieldType, Required: true})
+	}
+	for _, metric := range spec.MetricColumns() {
+		fieldType := bigquery.FloatFieldType
+		if metric.Integer {
+			fieldType = bigquery.IntegerFieldType
+		}
+		schema = append(schema, &bigquery.FieldSchema{Name: metric.Name, Type: fieldType, Required: true})
+	}
+	return schema
+}
 
-// Define the schema for the new table
-var schema = bigquery.Schema{
-	{Name: fieldDate, Type: bigquery.DateFieldType, Required: true},
-	{Name: fieldProjectID, Type: bigquery.StringFieldType, Required: true},
-	{Name: fieldNumberOfTransactions, Type: bigquery.IntegerFieldType, Required: true},
-	{Name: fieldTotalVolumeUsd, Type: bigquery.FloatFieldType, Required: true},
+// aggTableMetadata defines the metadata of the aggregation table
+func aggTableMetadata(schema bigquery.Schema, spec *worker.AggSpec) *bigquery.TableMetadata {
+	metadata := &bigquery.TableMetadata{
+		Schema: schema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Field: spec.BucketColumn(), // Partition by the time bucket field
+			Type:  partitioningType(spec.TimeBucket()),
+		},
+	}
+
+	// Cluster by the first dimensions
+	var clustering []string
+	for _, dimension := range spec.Dimensions[:min(len(spec.Dimensions), maxClusteringFields)] {
+		clustering = append(clustering, worker.DimensionColumn(dimension))
+	}
+	if len(clustering) > 0 {
+		metadata.Clustering = &bigquery.Clustering{Fields: clustering}
+	}
+	return metadata
 }
 
-var tableMetadata = bigquery.TableMetadata{
-	Schema: schema,
-	TimePartitioning: &bigquery.TimePartitioning{
-		Field: fieldDate, // Partition by Date field
-	},
-	Clustering: &bigquery.Clustering{
-		Fields: []string{fieldProjectID}, // Cluster by ProjectId
-	},
+// partitioningType returns the partitioning granularity of the time bucket.
+// BigQuery has no weekly partitioning, each daily partition holds one week.
+func partitioningType(bucket worker.TimeBucket) bigquery.TimePartitioningType {
+	switch bucket {
+	case worker.BucketHour:
+		return bigquery.HourPartitioningType
+	case worker.BucketMonth:
+		return bigquery.MonthPartitioningType
+	default:
+		return bigquery.DayPartitioningType
+	}
 }
 
 // NewBigQuerySinkFromPath creates a new BigQuery sink from a URI in the format bq://projectid/datasetid/tableid.
-func NewBigQuerySinkFromPath(ctx context.Context, uri string) (*BigQuerySink, error) {
+func NewBigQuerySinkFromPath(ctx context.Context, uri string, spec *worker.AggSpec) (*BigQuerySink, error) {
 	projectID, datasetID, tableID, err := parseBigQueryURI(uri)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse BigQuery URI: %w", err)
 	}
-	return NewBigQuerySink(ctx, projectID, datasetID, tableID)
+	return NewBigQuerySink(ctx, projectID, datasetID, tableID, spec)
 }
 
 // NewBigQuerySink creates a new BigQuery sink with the specified project, dataset, and table.
-func NewBigQuerySink(ctx context.Context, projectID, datasetID, tableID string) (*BigQuerySink, error) {
+func NewBigQuerySink(ctx context.Context, projectID, datasetID, tableID string, spec *worker.AggSpec) (*BigQuerySink, error) {
 	client, err := bigquery.NewClient(ctx, projectID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create BigQuery client: %w", err)
 	}
 
 	// Ensure the table exists or create it
-	if err := ensureTableExists(ctx, client, datasetID, tableID); err != nil {
+	schema := aggSchema(spec)
+	if err := ensureTableExists(ctx, client, datasetID, tableID, aggTableMetadata(schema, spec)); err != nil {
 		return nil, fmt.Errorf("failed to ensure table exists: %w", err)
 	}
 
@@ -72,12 +113,14 @@ func NewBigQuerySink(ctx context.Context, projectID, datasetID, tableID string)
 		client:    client,
 		datasetID: datasetID,
 		tableID:   tableID,
+		schema:    schema,
+		spec:      spec,
 		ctx:       ctx,
 	}, nil
 }
 
 // ensureTableExists checks if the table exists and creates it if it doesn't.
-func ensureTableExists(ctx context.Context, client *bigquery.Client, datasetID, tableID string) error {
+func ensureTableExists(ctx context.Context, client *bigquery.Client, datasetID, tableID string, tableMetadata *bigquery.TableMetadata) error {
 	table := client.Dataset(datasetID).Table(tableID)
 
 	_, err := table.Metadata(ctx)
@@ -92,7 +135,7 @@ func ensureTableExists(ctx context.Context, client *bigquery.Client, datasetID,
 		fmt.Printf("Table %s does not exist, creating table...\n", tableID)
 
 		// Create the table with partitioning and clustering
-		return table.Create(ctx, &tableMetadata)
+		return table.Create(ctx, tableMetadata)
 	}
 
 	return fmt.Errorf("failed to get table metadata: %w", err)
@@ -105,7 +148,11 @@ func (s *BigQuerySink) WriteAgg(aggs []worker.Agg) (int, error) {
 	// Convert worker.Agg to BigQuery rows
 	rows := make([]*bigquery.ValuesSaver, len(aggs))
 	for i, agg := range aggs {
-		rows[i] = aggToRow(agg)
+		row, err := aggToRow(agg, s.schema, s.spec)
+		if err != nil {
+			return 0, err
+		}
+		rows[i] = row
 	}
 
 	// Stream the data to BigQuery
@@ -121,22 +168,53 @@ func (s *BigQuerySink) Close() error {
 	return s.client.Close()
 }
 
+// Abort closes the BigQuery client, the rows already streamed cannot be removed
+// but the pipeline only streams them once the aggregation is complete.
+func (s *BigQuerySink) Abort() error {
+	return s.client.Close()
+}
+
 // aggToRow converts a worker.Agg to a BigQuery row (ValuesSaver).
-func aggToRow(agg worker.Agg) *bigquery.ValuesSaver {
+func aggToRow(agg worker.Agg, schema bigquery.Schema, spec *worker.AggSpec) (*bigquery.ValuesSaver, error) {
+	var bucket bigquery.Value = agg.Date
+	if spec.TimeBucket() == worker.BucketHour {
+		start, err := spec.ParseBucket(agg.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aggregate hour %s: %w", agg.Date, err)
+		}
+		bucket = start
+	}
+
+	row := []bigquery.Value{bucket}
+	for _, value := range agg.Dimensions {
+		row = append(row, value)
+	}
+	row = append(row, agg.NumberOfTransactions)
+	for _, volume := range agg.TotalVolume {
+		row = append(row, volume)
+	}
+	for _, metric := range spec.MetricColumns() {
+		if metric.Integer {
+			row = append(row, int64(metric.Value(agg)))
+		} else {
+			row = append(row, metric.Value(agg))
+		}
+	}
 	return &bigquery.ValuesSaver{
 		Schema: schema,
-		Row: []bigquery.Value{
-			agg.Date,
-			agg.ProjectId,
-			agg.NumberOfTransactions,
-			agg.TotalVolumeUsd,
-		},
-	}
+		Row:    row,
+	}, nil
+}
+
+// IsBigQueryPath returns true if the path is a BigQuery table URI, bq://projectid/datasetid/tableid,
+// rather than a file path, e.g. bq_prices.csv.
+func IsBigQueryPath(path string) bool {
+	return strings.HasPrefix(path, "bq://")
 }
 
 // parseBigQueryURI parses a URI of the form bq://projectid/datasetid/tableid.
 func parseBigQueryURI(uri string) (string, string, string, error) {
-	if !strings.HasPrefix(uri, "bq://") {
+	if !IsBigQueryPath(uri) {
 		return "", "", "", fmt.Errorf("URI must start with 'bq://'")
 	}
 
@@ -0,0 +1,79 @@
+package sink
+
+import (
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+	"hodctl/pkg/worker"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggTableMetadata_TimeBucket(t *testing.T) {
+	spec, err := worker.NewAggSpec([]string{"usd"})
+	assert.NoError(t, err)
+
+	// Daily buckets are a DATE, partitioned by day and clustered by project
+	metadata := aggTableMetadata(aggSchema(spec), spec)
+	assert.Equal(t, worker.ColumnDate, metadata.Schema[0].Name)
+	assert.Equal(t, bigquery.DateFieldType, metadata.Schema[0].Type)
+	assert.Equal(t, bigquery.DayPartitioningType, metadata.TimePartitioning.Type)
+	assert.Equal(t, []string{"ProjectId"}, metadata.Clustering.Fields)
+
+	// Hourly buckets are a TIMESTAMP, partitioned by hour
+	spec.Bucket = worker.BucketHour
+	spec.Location, err = time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	metadata = aggTableMetadata(aggSchema(spec), spec)
+	assert.Equal(t, worker.ColumnHour, metadata.Schema[0].Name)
+	assert.Equal(t, bigquery.TimestampFieldType, metadata.Schema[0].Type)
+	assert.Equal(t, worker.ColumnHour, metadata.TimePartitioning.Field)
+	assert.Equal(t, bigquery.HourPartitioningType, metadata.TimePartitioning.Type)
+
+	row, err := aggToRow(worker.Agg{Date: "2024-04-15T02:00:00+02:00", Dimensions: []string{"4974"}, NumberOfTransactions: 1, TotalVolume: []float64{2}}, metadata.Schema, spec)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC).Equal(row.Row[0].(time.Time)))
+
+	// Monthly buckets are the DATE of their first day, partitioned by month
+	spec.Bucket = worker.BucketMonth
+	metadata = aggTableMetadata(aggSchema(spec), spec)
+	assert.Equal(t, worker.ColumnMonth, metadata.Schema[0].Name)
+	assert.Equal(t, bigquery.DateFieldType, metadata.Schema[0].Type)
+	assert.Equal(t, bigquery.MonthPartitioningType, metadata.TimePartitioning.Type)
+}
+
+func TestIsBigQueryPath(t *testing.T) {
+	assert.True(t, IsBigQueryPath("bq://project/dataset/table"))
+	assert.False(t, IsBigQueryPath("bq_prices.csv"))
+	assert.False(t, IsBigQueryPath("bq/prices.json"))
+	assert.False(t, IsBigQueryPath("gs://bucket/bq/prices.csv"))
+}
+
+func TestPriceToRow_InsertID(t *testing.T) {
+	price := io.HistoricalPrice{Date: "2024-04-15", Coin: io.Coin{ID: "bitcoin", Symbol: "btc", Currency: "usd", Price: 65000, Source: "coingecko", SnapshotTime: "2024-04-16T08:00:00Z"}}
+	insertID := func(price io.HistoricalPrice) string {
+		record, err := newPriceRecord(price)
+		assert.NoError(t, err)
+		return priceToRow(record).InsertID
+	}
+
+	// The same price inserted again by a resumed fetch has the same insert ID
+	id := insertID(price)
+	assert.Len(t, id, 64)
+	price.Price = 65100
+	assert.Equal(t, id, insertID(price))
+
+	// Another day, quote currency, coin or snapshot is another row
+	for _, other := range []func(p *io.HistoricalPrice){
+		func(p *io.HistoricalPrice) { p.Date = "2024-04-14" },
+		func(p *io.HistoricalPrice) { p.Currency = "eur" },
+		func(p *io.HistoricalPrice) { p.ID = "ethereum" },
+		func(p *io.HistoricalPrice) { p.SnapshotTime = "2024-04-17T08:00:00Z" },
+	} {
+		changed := price
+		other(&changed)
+		assert.NotEqual(t, id, insertID(changed))
+	}
+}
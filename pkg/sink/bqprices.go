@@ -0,0 +1,137 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hodctl/pkg/io"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// BigQueryPriceSink handles streaming inserts to BigQuery for the fetched prices.
+type BigQueryPriceSink struct {
+	client    *bigquery.Client
+	datasetID string
+	tableID   string
+	ctx       context.Context
+}
+
+// priceSchema defines the schema of the price table
+var priceSchema = bigquery.Schema{
+	{Name: ColumnSnapshotDate, Type: bigquery.DateFieldType, Required: true},
+	{Name: ColumnPriceDate, Type: bigquery.DateFieldType},
+	{Name: ColumnID, Type: bigquery.StringFieldType, Required: true},
+	{Name: ColumnSymbol, Type: bigquery.StringFieldType, Required: true},
+	{Name: ColumnName, Type: bigquery.StringFieldType},
+	{Name: ColumnCurrency, Type: bigquery.StringFieldType, Required: true},
+	{Name: ColumnPrice, Type: bigquery.FloatFieldType, Required: true},
+	{Name: ColumnMarketCap, Type: bigquery.FloatFieldType},
+	{Name: ColumnLastUpdated, Type: bigquery.TimestampFieldType},
+	{Name: ColumnSource, Type: bigquery.StringFieldType, Required: true},
+	{Name: ColumnSnapshotTime, Type: bigquery.TimestampFieldType, Required: true},
+}
+
+// priceTableMetadata defines the metadata of the price table
+func priceTableMetadata() *bigquery.TableMetadata {
+	return &bigquery.TableMetadata{
+		Schema: priceSchema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Field: ColumnSnapshotDate, // Partition by SnapshotDate field
+		},
+		Clustering: &bigquery.Clustering{
+			Fields: []string{ColumnCurrency, ColumnSymbol}, // Cluster by Currency and Symbol
+		},
+	}
+}
+
+// NewBigQueryPriceSinkFromPath creates a new BigQuery price sink from a URI in the format bq://projectid/datasetid/tableid.
+func NewBigQueryPriceSinkFromPath(ctx context.Context, uri string) (*BigQueryPriceSink, error) {
+	projectID, datasetID, tableID, err := parseBigQueryURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse BigQuery URI: %w", err)
+	}
+
+	client, err := bigquery.NewClient(ctx, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %w", err)
+	}
+
+	// Ensure the table exists or create it
+	if err := ensureTableExists(ctx, client, datasetID, tableID, priceTableMetadata()); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("failed to ensure table exists: %w", err)
+	}
+
+	return &BigQueryPriceSink{
+		client:    client,
+		datasetID: datasetID,
+		tableID:   tableID,
+		ctx:       ctx,
+	}, nil
+}
+
+// WritePrices writes the prices into BigQuery using streaming inserts. Each row has the insert ID of its price,
+// so BigQuery drops the rows inserted again by a resumed fetch, on a best-effort basis.
+func (s *BigQueryPriceSink) WritePrices(prices []io.HistoricalPrice) (int, error) {
+	inserter := s.client.Dataset(s.datasetID).Table(s.tableID).Inserter()
+
+	rows := make([]*bigquery.ValuesSaver, len(prices))
+	for i, price := range prices {
+		record, err := newPriceRecord(price)
+		if err != nil {
+			return 0, err
+		}
+		rows[i] = priceToRow(record)
+	}
+
+	if err := inserter.Put(s.ctx, rows); err != nil {
+		return 0, fmt.Errorf("failed to insert data into BigQuery: %w", err)
+	}
+
+	return len(prices), nil
+}
+
+// Close closes the BigQuery client.
+func (s *BigQueryPriceSink) Close() error {
+	return s.client.Close()
+}
+
+// priceToRow converts a price to a BigQuery row (ValuesSaver).
+func priceToRow(record priceRecord) *bigquery.ValuesSaver {
+	var date, lastUpdated bigquery.Value
+	if record.Date != "" {
+		date = record.Date
+	}
+	if record.LastUpdated != nil {
+		lastUpdated = *record.LastUpdated
+	}
+	return &bigquery.ValuesSaver{
+		Schema:   priceSchema,
+		InsertID: priceInsertID(record),
+		Row: []bigquery.Value{
+			record.SnapshotDate,
+			date,
+			record.ID,
+			record.Symbol,
+			record.Name,
+			record.Currency,
+			record.Price,
+			record.MarketCap,
+			lastUpdated,
+			record.Source,
+			record.SnapshotTime,
+		},
+	}
+}
+
+// priceInsertID identifies the price of a coin in a quote currency on a day of the snapshot, within the 128 characters
+// of a BigQuery insert ID
+func priceInsertID(record priceRecord) string {
+	key := strings.Join([]string{record.SnapshotTime.UTC().Format(time.RFC3339Nano), record.ID, record.Currency, record.Date}, "|")
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"fmt"
+	"hodctl/pkg/io"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet"
+	"github.com/apache/arrow/go/v15/parquet/compress"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// priceArrowSchema defines the schema of the Parquet price files
+var priceArrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "snapshot_date", Type: arrow.FixedWidthTypes.Date32},
+	{Name: "date", Type: arrow.FixedWidthTypes.Date32, Nullable: true},
+	{Name: "id", Type: arrow.BinaryTypes.String},
+	{Name: "symbol", Type: arrow.BinaryTypes.String},
+	{Name: "name", Type: arrow.BinaryTypes.String},
+	{Name: "currency", Type: arrow.BinaryTypes.String},
+	{Name: "price", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "market_cap", Type: arrow.PrimitiveTypes.Float64},
+	{Name: "last_updated", Type: arrow.FixedWidthTypes.Timestamp_ms, Nullable: true},
+	{Name: "source", Type: arrow.BinaryTypes.String},
+	{Name: "snapshot_time", Type: arrow.FixedWidthTypes.Timestamp_ms},
+}, nil)
+
+// ParquetPriceSink writes the prices as a Parquet file, one row group for each WritePrices call
+type ParquetPriceSink struct {
+	writer *pqarrow.FileWriter
+}
+
+// NewParquetPriceSink creates a ParquetPriceSink writing to the given file, the file is closed with the sink
+func NewParquetPriceSink(file *io.VfsReaderWriter) (*ParquetPriceSink, error) {
+	props := parquet.NewWriterProperties(parquet.WithCompression(compress.Codecs.Snappy))
+	writer, err := pqarrow.NewFileWriter(priceArrowSchema, file, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to create Parquet writer: %w", err)
+	}
+	return &ParquetPriceSink{writer: writer}, nil
+}
+
+// WritePrices writes the prices as a row group
+func (s *ParquetPriceSink) WritePrices(prices []io.HistoricalPrice) (int, error) {
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, priceArrowSchema)
+	defer builder.Release()
+
+	for _, price := range prices {
+		record, err := newPriceRecord(price)
+		if err != nil {
+			return 0, err
+		}
+		if err := appendPriceRecord(builder, record); err != nil {
+			return 0, err
+		}
+	}
+
+	batch := builder.NewRecord()
+	defer batch.Release()
+	if err := s.writer.Write(batch); err != nil {
+		return 0, fmt.Errorf("failed to write Parquet: %w", err)
+	}
+	return len(prices), nil
+}
+
+// Close writes the Parquet footer and closes the file
+func (s *ParquetPriceSink) Close() error {
+	return s.writer.Close()
+}
+
+// appendPriceRecord appends a price to the builders, in the order of priceArrowSchema
+func appendPriceRecord(builder *array.RecordBuilder, record priceRecord) error {
+	snapshotDate, err := time.Parse(io.PriceDateLayout, record.SnapshotDate)
+	if err != nil {
+		return fmt.Errorf("invalid snapshot date for %s: %w", record.ID, err)
+	}
+	builder.Field(0).(*array.Date32Builder).Append(arrow.Date32FromTime(snapshotDate))
+
+	dates := builder.Field(1).(*array.Date32Builder)
+	if record.Date == "" {
+		dates.AppendNull()
+	} else {
+		date, err := time.Parse(io.PriceDateLayout, record.Date)
+		if err != nil {
+			return fmt.Errorf("invalid price date for %s: %w", record.ID, err)
+		}
+		dates.Append(arrow.Date32FromTime(date))
+	}
+
+	builder.Field(2).(*array.StringBuilder).Append(record.ID)
+	builder.Field(3).(*array.StringBuilder).Append(record.Symbol)
+	builder.Field(4).(*array.StringBuilder).Append(record.Name)
+	builder.Field(5).(*array.StringBuilder).Append(record.Currency)
+	builder.Field(6).(*array.Float64Builder).Append(record.Price)
+	builder.Field(7).(*array.Float64Builder).Append(record.MarketCap)
+
+	lastUpdated := builder.Field(8).(*array.TimestampBuilder)
+	if record.LastUpdated == nil {
+		lastUpdated.AppendNull()
+	} else {
+		lastUpdated.Append(arrow.Timestamp(record.LastUpdated.UnixMilli()))
+	}
+
+	builder.Field(9).(*array.StringBuilder).Append(record.Source)
+	builder.Field(10).(*array.TimestampBuilder).Append(arrow.Timestamp(record.SnapshotTime.UnixMilli()))
+	return nil
+}
@@ -0,0 +1,157 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hodctl/pkg/io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Names of the price columns
+const (
+	ColumnSnapshotDate = "SnapshotDate"
+	ColumnPriceDate    = "Date"
+	ColumnID           = "ID"
+	ColumnSymbol       = "Symbol"
+	ColumnName         = "Name"
+	ColumnCurrency     = "Currency"
+	ColumnPrice        = "Price"
+	ColumnMarketCap    = "MarketCap"
+	ColumnLastUpdated  = "LastUpdated"
+	ColumnSource       = "Source"
+	ColumnSnapshotTime = "SnapshotTime"
+)
+
+// PriceSink for writing the fetched prices to a sink, WritePrices can be called several times.
+type PriceSink interface {
+	WritePrices(prices []io.HistoricalPrice) (int, error)
+	Close() error
+}
+
+// NewPriceSink initializes a new PriceSink based on the path.
+// If the path is a bq:// URI, it returns a BigQueryPriceSink.
+// Paths ending in .json, .jsonl/.ndjson and .parquet are written as a JSON array, JSON lines and Parquet,
+// any other path is written as the CSV read by agg.
+func NewPriceSink(ctx context.Context, p string) (PriceSink, error) {
+	if IsBigQueryPath(p) {
+		return NewBigQueryPriceSinkFromPath(ctx, p)
+	}
+
+	vfsWriter, err := io.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create price sink: %w", err)
+	}
+
+	switch strings.ToLower(path.Ext(p)) {
+	case ".json":
+		return &JSONPriceSink{writer: vfsWriter}, nil
+	case ".jsonl", ".ndjson":
+		return &JSONPriceSink{writer: vfsWriter, lines: true}, nil
+	case ".parquet":
+		return NewParquetPriceSink(vfsWriter)
+	default:
+		return io.NewCSVPriceWriter(vfsWriter), nil
+	}
+}
+
+// priceRecord is a price with typed dates, as written to the JSON, Parquet and BigQuery sinks
+type priceRecord struct {
+	SnapshotDate string     `json:"snapshot_date"`  // Day of the snapshot time (YYYY-MM-DD), partition of the price tables
+	Date         string     `json:"date,omitempty"` // Day of a historical price (YYYY-MM-DD), empty for a current price
+	ID           string     `json:"id"`
+	Symbol       string     `json:"symbol"`
+	Name         string     `json:"name"`
+	Currency     string     `json:"currency"`
+	Price        float64    `json:"price"`
+	MarketCap    float64    `json:"market_cap"`
+	LastUpdated  *time.Time `json:"last_updated,omitempty"`
+	Source       string     `json:"source"`
+	SnapshotTime time.Time  `json:"snapshot_time"`
+}
+
+// newPriceRecord converts a fetched price into a priceRecord
+func newPriceRecord(price io.HistoricalPrice) (priceRecord, error) {
+	snapshotTime, err := time.Parse(time.RFC3339, price.SnapshotTime)
+	if err != nil {
+		return priceRecord{}, fmt.Errorf("invalid snapshot time for %s: %w", price.ID, err)
+	}
+	currency := price.Currency
+	if currency == "" {
+		currency = io.DefaultCurrency
+	}
+
+	record := priceRecord{
+		SnapshotDate: snapshotTime.UTC().Format(io.PriceDateLayout),
+		Date:         price.Date,
+		ID:           price.ID,
+		Symbol:       price.Symbol,
+		Name:         price.Name,
+		Currency:     currency,
+		Price:        price.Price,
+		MarketCap:    price.MarketCap,
+		Source:       price.Source,
+		SnapshotTime: snapshotTime.UTC(),
+	}
+	if price.LastUpdated != "" {
+		lastUpdated, err := time.Parse(time.RFC3339, price.LastUpdated)
+		if err != nil {
+			return priceRecord{}, fmt.Errorf("invalid last updated time for %s: %w", price.ID, err)
+		}
+		lastUpdated = lastUpdated.UTC()
+		record.LastUpdated = &lastUpdated
+	}
+	return record, nil
+}
+
+// JSONPriceSink writes the prices as a JSON array, or as JSON lines
+type JSONPriceSink struct {
+	writer  *io.VfsReaderWriter
+	lines   bool // Write one JSON object per line instead of an array
+	written int
+}
+
+// WritePrices writes the prices as JSON objects
+func (s *JSONPriceSink) WritePrices(prices []io.HistoricalPrice) (int, error) {
+	for _, price := range prices {
+		record, err := newPriceRecord(price)
+		if err != nil {
+			return 0, err
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal price: %w", err)
+		}
+
+		switch {
+		case s.lines:
+			data = append(data, '\n')
+		case s.written == 0:
+			data = append([]byte("[\n"), data...)
+		default:
+			data = append([]byte(",\n"), data...)
+		}
+		if _, err := s.writer.Write(data); err != nil {
+			return 0, fmt.Errorf("failed to write JSON: %w", err)
+		}
+		s.written++
+	}
+	return len(prices), nil
+}
+
+// Close ends the JSON array and closes the file
+func (s *JSONPriceSink) Close() error {
+	if !s.lines {
+		end := "\n]\n"
+		if s.written == 0 {
+			end = "[]\n"
+		}
+		if _, err := s.writer.Write([]byte(end)); err != nil {
+			_ = s.writer.Close()
+			return fmt.Errorf("failed to write JSON: %w", err)
+		}
+	}
+	return s.writer.Close()
+}
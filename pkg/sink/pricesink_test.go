@@ -0,0 +1,131 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPrices = []io.HistoricalPrice{
+	{Coin: io.Coin{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", Currency: "usd", Price: 65000, MarketCap: 1.2e12,
+		LastUpdated: "2024-04-15T09:59:00.000Z", Source: "coingecko", SnapshotTime: "2024-04-15T10:00:00Z"}},
+	{Date: "2024-04-14", Coin: io.Coin{ID: "ethereum", Symbol: "eth", Name: "Ethereum", Price: 3000,
+		Source: "coingecko", SnapshotTime: "2024-04-15T10:00:00Z"}},
+}
+
+func writeTestPrices(t *testing.T, path string) {
+	priceSink, err := NewPriceSink(context.Background(), path)
+	assert.NoError(t, err)
+	_, err = priceSink.WritePrices(testPrices[:1])
+	assert.NoError(t, err)
+	_, err = priceSink.WritePrices(testPrices[1:])
+	assert.NoError(t, err)
+	assert.NoError(t, priceSink.Close())
+}
+
+func TestPriceSink_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.csv")
+	writeTestPrices(t, path)
+
+	reader, err := os.Open(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	table, err := io.ReadPriceTable(reader, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, io.Currency2Values{"BTC": 65000}, table.Quotes["usd"].Snapshot)
+	assert.Equal(t, io.Currency2Values{"ETH": 3000}, table.Quotes["usd"].Daily["2024-04-14"])
+}
+
+func TestPriceSink_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	writeTestPrices(t, path)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var records []map[string]any
+	assert.NoError(t, json.Unmarshal(content, &records))
+	assert.Len(t, records, 2)
+	assert.Equal(t, map[string]any{
+		"snapshot_date": "2024-04-15",
+		"id":            "bitcoin",
+		"symbol":        "btc",
+		"name":          "Bitcoin",
+		"currency":      "usd",
+		"price":         65000.0,
+		"market_cap":    1.2e12,
+		"last_updated":  "2024-04-15T09:59:00Z",
+		"source":        "coingecko",
+		"snapshot_time": "2024-04-15T10:00:00Z",
+	}, records[0])
+	assert.Equal(t, "2024-04-14", records[1]["date"])
+
+	// agg reads the prices written by fetch
+	table, err := io.LoadPriceTable(context.Background(), path, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, io.Currency2Values{"BTC": 65000}, table.Quotes["usd"].Snapshot)
+	assert.Equal(t, io.Currency2Values{"ETH": 3000}, table.Quotes["usd"].Daily["2024-04-14"])
+	assert.Equal(t, []string{"coingecko"}, table.Sources)
+	assert.Equal(t, time.Date(2024, 4, 15, 10, 0, 0, 0, time.UTC), table.SnapshotTime)
+}
+
+func TestPriceSink_JSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.jsonl")
+	writeTestPrices(t, path)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"snapshot_date":"2024-04-15","id":"bitcoin","symbol":"btc","name":"Bitcoin","currency":"usd","price":65000,"market_cap":1200000000000,"last_updated":"2024-04-15T09:59:00Z","source":"coingecko","snapshot_time":"2024-04-15T10:00:00Z"}
+{"snapshot_date":"2024-04-15","date":"2024-04-14","id":"ethereum","symbol":"eth","name":"Ethereum","currency":"usd","price":3000,"market_cap":0,"source":"coingecko","snapshot_time":"2024-04-15T10:00:00Z"}
+`, string(content))
+
+	prices, err := io.LoadPrices(context.Background(), path)
+	assert.NoError(t, err)
+	assert.Equal(t, []io.HistoricalPrice{
+		{Coin: io.Coin{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", Currency: "usd", Price: 65000, MarketCap: 1.2e12,
+			LastUpdated: "2024-04-15T09:59:00Z", Source: "coingecko", SnapshotTime: "2024-04-15T10:00:00Z"}},
+		{Date: "2024-04-14", Coin: io.Coin{ID: "ethereum", Symbol: "eth", Name: "Ethereum", Currency: "usd", Price: 3000,
+			Source: "coingecko", SnapshotTime: "2024-04-15T10:00:00Z"}},
+	}, prices)
+}
+
+func TestPriceSink_Parquet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.parquet")
+	writeTestPrices(t, path)
+
+	reader, err := file.OpenParquetFile(path, false)
+	assert.NoError(t, err)
+	defer reader.Close()
+	arrowReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	assert.NoError(t, err)
+	table, err := arrowReader.ReadTable(context.Background())
+	assert.NoError(t, err)
+	defer table.Release()
+
+	assert.Equal(t, int64(2), table.NumRows())
+	assert.Equal(t, int64(2), int64(reader.NumRowGroups()))
+	assert.Equal(t, "snapshot_date", table.Schema().Field(0).Name)
+	assert.Equal(t, 1, table.Column(1).Data().NullN()) // the current price has no date
+}
+
+func TestPriceToRow(t *testing.T) {
+	record, err := newPriceRecord(testPrices[1])
+	assert.NoError(t, err)
+
+	row := priceToRow(record)
+	assert.Len(t, row.Row, len(priceSchema))
+	assert.Equal(t, "2024-04-15", row.Row[0])
+	assert.Equal(t, "2024-04-14", row.Row[1])
+	assert.Equal(t, "usd", row.Row[5])
+	assert.Nil(t, row.Row[8])
+	assert.Equal(t, time.Date(2024, 4, 15, 10, 0, 0, 0, time.UTC), row.Row[10])
+}
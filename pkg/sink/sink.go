@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"hodctl/pkg/io"
 	"hodctl/pkg/worker"
-	"strings"
 )
 
-// AggSink for writing aggregated transactions to a sink.
+// AggSink for writing aggregated transactions to a sink, WriteAgg can be called several times.
+// Close completes the output, Abort releases the sink after a failure and discards the output when possible.
 type AggSink interface {
 	WriteAgg([]worker.Agg) (int, error)
 	Close() error
+	Abort() error
 }
 
 // ErrSink for writing errors to a sink.
@@ -21,11 +22,11 @@ type ErrSink interface {
 }
 
 // NewAggSink initializes a new Sink based on the path.
-// If the path starts with "bq", it returns a BQSink
+// If the path is a bq:// URI, it returns a BQSink
 // For any other path, it returns a VfsSink.
-func NewAggSink(ctx context.Context, path string) (AggSink, error) {
-	if strings.HasPrefix(path, "bq") {
-		return NewBigQuerySinkFromPath(ctx, path)
+func NewAggSink(ctx context.Context, path string, spec *worker.AggSpec) (AggSink, error) {
+	if IsBigQueryPath(path) {
+		return NewBigQuerySinkFromPath(ctx, path, spec)
 	}
 
 	// Create a VfsReaderWriter for the given path
@@ -35,7 +36,9 @@ func NewAggSink(ctx context.Context, path string) (AggSink, error) {
 	}
 
 	return &VfsAggSink{
-		writer: vfsWriter,
+		writer:  vfsWriter,
+		columns: spec.Columns(),
+		metrics: spec.MetricColumns(),
 	}, nil
 }
 
@@ -1,16 +1,22 @@
 package sink
 
 import (
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"hodctl/pkg/io"
 	"hodctl/pkg/worker"
+	"strconv"
 
 	"github.com/gocarina/gocsv"
 )
 
 // VfsSink struct, which will handle writing to a virtual file system
 type VfsAggSink struct {
-	writer *io.VfsReaderWriter
+	writer        *io.VfsReaderWriter
+	columns       []string              // Header of the CSV, it depends on the AggSpec
+	metrics       []worker.MetricColumn // Columns of the optional metrics, after the total volumes
+	headerWritten bool
 }
 
 type VfsOutlierSink struct {
@@ -19,8 +25,33 @@ type VfsOutlierSink struct {
 
 // WriteAgg method implementation for VfsSink that writes Agg data in CSV format
 func (s *VfsAggSink) WriteAgg(aggs []worker.Agg) (int, error) {
-	err := gocsv.Marshal(&aggs, s.writer)
-	if err != nil {
+	writer := csv.NewWriter(s.writer)
+	if !s.headerWritten {
+		if err := writer.Write(s.columns); err != nil {
+			return 0, fmt.Errorf("failed to write CSV header: %w", err)
+		}
+		s.headerWritten = true
+	}
+
+	record := make([]string, len(s.columns))
+	for _, agg := range aggs {
+		record = record[:0]
+		record = append(record, agg.Date)
+		record = append(record, agg.Dimensions...)
+		record = append(record, strconv.Itoa(agg.NumberOfTransactions))
+		for _, volume := range agg.TotalVolume {
+			record = append(record, strconv.FormatFloat(volume, 'f', -1, 64))
+		}
+		for _, metric := range s.metrics {
+			record = append(record, strconv.FormatFloat(metric.Value(agg), 'f', -1, 64))
+		}
+		if err := writer.Write(record); err != nil {
+			return 0, fmt.Errorf("failed to write CSV: %w", err)
+		}
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
 		return 0, fmt.Errorf("failed to write CSV: %w", err)
 	}
 
@@ -36,6 +67,18 @@ func (s *VfsAggSink) Close() error {
 	return s.writer.Close()
 }
 
+// Abort closes the file and deletes what was written so far
+func (s *VfsAggSink) Abort() error {
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	exists, err := s.writer.Exists()
+	if err != nil || !exists {
+		return err
+	}
+	return s.writer.Delete()
+}
+
 // WriteError method implementation for VfsSink that writes Outlier data in CSV format
 func (s *VfsOutlierSink) WriteError(outliersCh <-chan worker.Outlier) error {
 	writer := gocsv.DefaultCSVWriter(s.writer)
@@ -48,7 +91,14 @@ func (s *VfsOutlierSink) WriteError(outliersCh <-chan worker.Outlier) error {
 		}
 	}()
 	err := gocsv.MarshalChanWithoutHeaders(interfaceChan, writer)
+	if errors.Is(err, gocsv.ErrChannelIsClosed) {
+		// No outliers
+		return nil
+	}
 	if err != nil {
+		// Keep draining the outliers so the workers sending them are not blocked
+		for range interfaceChan {
+		}
 		return err
 	}
 
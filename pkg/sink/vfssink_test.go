@@ -0,0 +1,46 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hodctl/pkg/worker"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVfsAggSink_WriteAgg(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	spec, err := worker.NewAggSpec([]string{"usd", "eur"})
+	assert.NoError(t, err)
+
+	aggSink, err := NewAggSink(context.Background(), path, spec)
+	assert.NoError(t, err)
+
+	// The header is only written once
+	_, err = aggSink.WriteAgg([]worker.Agg{{Date: "2024-04-15", Dimensions: []string{"4974"}, NumberOfTransactions: 2, TotalVolume: []float64{0.5, 0.45}}})
+	assert.NoError(t, err)
+	_, err = aggSink.WriteAgg([]worker.Agg{{Date: "2024-04-16", Dimensions: []string{"0"}, NumberOfTransactions: 1, TotalVolume: []float64{7, 6.3}}})
+	assert.NoError(t, err)
+	assert.NoError(t, aggSink.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `Date,ProjectId,NumberOfTransactions,TotalVolumeUsd,TotalVolumeEur
+2024-04-15,4974,2,0.5,0.45
+2024-04-16,0,1,7,6.3
+`, string(content))
+}
+
+func TestVfsAggSink_Abort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv")
+	aggSink, err := NewAggSink(context.Background(), path, &worker.DefaultAggSpec)
+	assert.NoError(t, err)
+
+	_, err = aggSink.WriteAgg([]worker.Agg{{Date: "2024-04-15", Dimensions: []string{"4974"}, NumberOfTransactions: 2, TotalVolume: []float64{0.5}}})
+	assert.NoError(t, err)
+	assert.NoError(t, aggSink.Abort())
+	assert.NoFileExists(t, path)
+}
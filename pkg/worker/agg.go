@@ -3,47 +3,66 @@ package worker
 import (
 	"fmt"
 	"hodctl/pkg/io"
+	"strings"
 )
 
 type Agg struct {
-	Date                 string
-	ProjectId            string
+	Date                 string   // Label of the time bucket, see AggSpec.BucketLabel
+	Dimensions           []string // Values of the AggSpec dimensions, in AggSpec.Dimensions order
 	NumberOfTransactions int
-	TotalVolumeUsd       float64
+	TotalVolume          []float64   // Total volume in each reporting currency, in AggSpec.Currencies order
+	Metrics              *AggMetrics // State of the optional AggSpec metrics, nil without optional metric
 }
 
-// ProcessRawTransaction processes a batch of transactions, groups by date and projectId, and aggregates the data
-func DoAgg(batch MicroBatch, currencyValues *io.Currency2Values) ([]Agg, error) {
+// DoAgg processes a batch of transactions, groups by date and the spec's dimensions, and aggregates the data.
+// Each transaction is converted to the reporting currencies using the price of the transaction's own (UTC) date,
+// and grouped by the time bucket of the spec in its time zone.
+func DoAgg(batch MicroBatch, prices *io.PriceTable, spec *AggSpec) ([]Agg, error) {
 	// Map to aggregate results
 	aggMap := make(map[string]Agg)
 
 	for _, transaction := range batch.Data {
+		date := transaction.Timestamp.UTC().Format(io.PriceDateLayout)
+		bucket := spec.BucketLabel(transaction.Timestamp)
 
-		// convert to usd
-		toUsd, exists := (*currencyValues)[transaction.CurrencySymbol]
-		if !exists {
-			return nil, fmt.Errorf("currency symbol not supported: %s", transaction.CurrencySymbol)
-		}
-
-		volume := toUsd * transaction.Volume
+		// Create a unique key for grouping by date and dimensions
+		key := groupKey(bucket, transaction.Dimensions)
 
-		date := transaction.Timestamp.Format("2006-01-02")
-
-		// Create a unique key for grouping by date and projectId
-		key := date + "_" + transaction.ProjectID
-
-		// Update the aggregate map for the given key (date + projectId)
+		// Update the aggregate map for the given key (date + dimensions)
 		agg, exists := aggMap[key]
 		if !exists {
+			metrics, err := spec.newMetrics()
+			if err != nil {
+				return nil, err
+			}
 			agg = Agg{
-				Date:      date,
-				ProjectId: transaction.ProjectID,
+				Date:        bucket,
+				Dimensions:  transaction.Dimensions,
+				TotalVolume: make([]float64, len(spec.Currencies)),
+				Metrics:     metrics,
 			}
 		}
 
-		// Increment the number of transactions and sum the total volume
+		// convert to each reporting currency using the price of the day
+		for i, currency := range spec.Currencies {
+			price, exists := prices.Price(currency, transaction.CurrencySymbol, date)
+			if !exists {
+				return nil, fmt.Errorf("currency symbol not supported: %s on %s in %s", transaction.CurrencySymbol, date, currency)
+			}
+			volume := price * transaction.Volume
+			agg.TotalVolume[i] += volume
+			if agg.Metrics != nil {
+				if err := agg.Metrics.addVolume(i, volume); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if agg.Metrics != nil {
+			agg.Metrics.addIDs(transaction.UserID, transaction.SessionID)
+		}
+
+		// Increment the number of transactions
 		agg.NumberOfTransactions++
-		agg.TotalVolumeUsd += volume
 
 		// Store the updated aggregate back in the map
 		aggMap[key] = agg
@@ -58,37 +77,19 @@ func DoAgg(batch MicroBatch, currencyValues *io.Currency2Values) ([]Agg, error)
 	return aggs, nil
 }
 
-// DoAggReducer collects all Agg from workers and combines them (reduce step)
-func DoAggReducer(in <-chan AggResult) ([]Agg, error) {
-	aggMap := make(map[string]Agg)
-
-	// Combine all incoming Agg results
-	for result := range in {
-		if result.Err != nil {
-			return nil, fmt.Errorf("unable to aggregate transactions: %v", result.Err)
-		}
-
-		for _, agg := range result.Agg {
-
-			key := agg.Date + "_" + agg.ProjectId
+// groupKey returns the key grouping the transactions of the date with the same dimension values
+func groupKey(date string, dimensions []string) string {
+	return date + "\x00" + strings.Join(dimensions, "\x00")
+}
 
-			existingAgg, exists := aggMap[key]
-			if !exists {
-				aggMap[key] = agg
-			} else {
-				// Accumulate the result
-				existingAgg.NumberOfTransactions += agg.NumberOfTransactions
-				existingAgg.TotalVolumeUsd += agg.TotalVolumeUsd
-				aggMap[key] = existingAgg
-			}
-		}
+// merge accumulates the partial aggregate of the same group into a
+func (a *Agg) merge(other Agg) error {
+	a.NumberOfTransactions += other.NumberOfTransactions
+	for i := range a.TotalVolume {
+		a.TotalVolume[i] += other.TotalVolume[i]
 	}
-
-	// Convert map to slice
-	var result []Agg
-	for _, agg := range aggMap {
-		result = append(result, agg)
+	if a.Metrics != nil {
+		return a.Metrics.merge(other.Metrics)
 	}
-
-	return result, nil
+	return nil
 }
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -10,31 +11,31 @@ import (
 var transactions = []Transaction{
 	{
 		Timestamp:      time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectA",
+		Dimensions:     []string{"ProjectA"},
 		CurrencySymbol: "USD",
 		Volume:         100.0,
 	},
 	{
 		Timestamp:      time.Date(2023, 10, 1, 13, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectA",
+		Dimensions:     []string{"ProjectA"},
 		CurrencySymbol: "EUR",
 		Volume:         50.0,
 	},
 	{
 		Timestamp:      time.Date(2023, 10, 1, 14, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectB",
+		Dimensions:     []string{"ProjectB"},
 		CurrencySymbol: "BTC",
 		Volume:         0.001,
 	},
 	{
 		Timestamp:      time.Date(2023, 10, 2, 12, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectA",
+		Dimensions:     []string{"ProjectA"},
 		CurrencySymbol: "USD",
 		Volume:         200.0,
 	},
 	{
 		Timestamp:      time.Date(2023, 10, 2, 13, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectB",
+		Dimensions:     []string{"ProjectB"},
 		CurrencySymbol: "EUR",
 		Volume:         80.0,
 	},
@@ -43,27 +44,27 @@ var transactions = []Transaction{
 var expectedAggs = []Agg{
 	{
 		Date:                 "2023-10-01",
-		ProjectId:            "ProjectA",
+		Dimensions:           []string{"ProjectA"},
 		NumberOfTransactions: 2,
-		TotalVolumeUsd:       160.0, // 100*1.0 + 50*1.2
+		TotalVolume:          []float64{160.0}, // 100*1.0 + 50*1.2
 	},
 	{
 		Date:                 "2023-10-01",
-		ProjectId:            "ProjectB",
+		Dimensions:           []string{"ProjectB"},
 		NumberOfTransactions: 1,
-		TotalVolumeUsd:       60.0, // 0.001*60000
+		TotalVolume:          []float64{60.0}, // 0.001*60000
 	},
 	{
 		Date:                 "2023-10-02",
-		ProjectId:            "ProjectA",
+		Dimensions:           []string{"ProjectA"},
 		NumberOfTransactions: 1,
-		TotalVolumeUsd:       200.0, // 200*1.0
+		TotalVolume:          []float64{200.0}, // 200*1.0
 	},
 	{
 		Date:                 "2023-10-02",
-		ProjectId:            "ProjectB",
+		Dimensions:           []string{"ProjectB"},
 		NumberOfTransactions: 1,
-		TotalVolumeUsd:       96.0, // 80*1.2
+		TotalVolume:          []float64{96.0}, // 80*1.2
 	},
 }
 
@@ -82,7 +83,7 @@ func TestDoAgg(t *testing.T) {
 	}
 
 	// Call DoAgg
-	aggs, err := DoAgg(batch, &currencyValues)
+	aggs, err := DoAgg(batch, io.NewSnapshotPriceTable("usd", currencyValues), &DefaultAggSpec)
 	if err != nil {
 		t.Fatalf("DoAgg returned error: %v", err)
 	}
@@ -90,13 +91,13 @@ func TestDoAgg(t *testing.T) {
 	// Convert the Aggs to a map for easy comparisons
 	aggsMap := make(map[string]Agg)
 	for _, agg := range aggs {
-		key := agg.Date + "_" + agg.ProjectId
+		key := agg.Date + "_" + agg.Dimensions[0]
 		aggsMap[key] = agg
 	}
 
 	// Compare the results
 	for _, expected := range expectedAggs {
-		key := expected.Date + "_" + expected.ProjectId
+		key := expected.Date + "_" + expected.Dimensions[0]
 		agg, exists := aggsMap[key]
 		if !exists {
 			t.Errorf("Expected Agg not found: %+v", expected)
@@ -105,8 +106,8 @@ func TestDoAgg(t *testing.T) {
 		if agg.NumberOfTransactions != expected.NumberOfTransactions {
 			t.Errorf("Mismatch in NumberOfTransactions for %s: got %d, want %d", key, agg.NumberOfTransactions, expected.NumberOfTransactions)
 		}
-		if agg.TotalVolumeUsd != expected.TotalVolumeUsd {
-			t.Errorf("Mismatch in TotalVolumeUsd for %s: got %f, want %f", key, agg.TotalVolumeUsd, expected.TotalVolumeUsd)
+		if agg.TotalVolume[0] != expected.TotalVolume[0] {
+			t.Errorf("Mismatch in TotalVolumeUsd for %s: got %f, want %f", key, agg.TotalVolume[0], expected.TotalVolume[0])
 		}
 	}
 
@@ -115,3 +116,141 @@ func TestDoAgg(t *testing.T) {
 		t.Errorf("Got %d Aggs, expected %d", len(aggs), len(expectedAggs))
 	}
 }
+
+func TestDoAgg_HistoricalPrices(t *testing.T) {
+	// BTC price changes between days, EUR only has a snapshot price
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"EUR": 1.2})
+	prices.Quotes["usd"].Daily = map[string]io.Currency2Values{
+		"2023-10-01": {"BTC": 60000.0, "USD": 1.0},
+		"2023-10-02": {"BTC": 30000.0, "USD": 1.0},
+	}
+
+	batch := MicroBatch{
+		Data: []Transaction{
+			{Timestamp: time.Date(2023, 10, 1, 14, 0, 0, 0, time.UTC), Dimensions: []string{"ProjectB"}, CurrencySymbol: "BTC", Volume: 0.001},
+			{Timestamp: time.Date(2023, 10, 2, 14, 0, 0, 0, time.UTC), Dimensions: []string{"ProjectB"}, CurrencySymbol: "BTC", Volume: 0.001},
+			{Timestamp: time.Date(2023, 10, 2, 13, 0, 0, 0, time.UTC), Dimensions: []string{"ProjectB"}, CurrencySymbol: "EUR", Volume: 80.0},
+		},
+	}
+
+	aggs, err := DoAgg(batch, prices, &DefaultAggSpec)
+	if err != nil {
+		t.Fatalf("DoAgg returned error: %v", err)
+	}
+
+	expected := map[string]float64{
+		"2023-10-01": 60.0,        // 0.001*60000
+		"2023-10-02": 30.0 + 96.0, // 0.001*30000 + 80*1.2
+	}
+	if len(aggs) != len(expected) {
+		t.Fatalf("Got %d Aggs, expected %d", len(aggs), len(expected))
+	}
+	for _, agg := range aggs {
+		if agg.TotalVolume[0] != expected[agg.Date] {
+			t.Errorf("Mismatch in TotalVolumeUsd for %s: got %f, want %f", agg.Date, agg.TotalVolume[0], expected[agg.Date])
+		}
+	}
+
+	// No price for that day and no snapshot price
+	batch.Data[0].Timestamp = time.Date(2023, 10, 3, 14, 0, 0, 0, time.UTC)
+	if _, err := DoAgg(batch, prices, &DefaultAggSpec); err == nil {
+		t.Errorf("Expected an error for a missing daily price")
+	}
+}
+
+func TestDoAgg_ReportingCurrencies(t *testing.T) {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"BTC": 60000.0, "EUR": 1.2})
+	prices.Quotes["eur"] = &io.QuotePrices{Snapshot: io.Currency2Values{"BTC": 50000.0, "EUR": 1.0}}
+
+	spec, err := NewAggSpec([]string{"EUR", "usd"})
+	if err != nil {
+		t.Fatalf("NewAggSpec returned error: %v", err)
+	}
+	if err := spec.Validate(prices); err != nil {
+		t.Fatalf("Validate returned error: %v", err)
+	}
+
+	batch := MicroBatch{
+		Data: []Transaction{
+			{Timestamp: time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC), Dimensions: []string{"ProjectA"}, CurrencySymbol: "BTC", Volume: 0.001},
+			{Timestamp: time.Date(2023, 10, 1, 13, 0, 0, 0, time.UTC), Dimensions: []string{"ProjectA"}, CurrencySymbol: "EUR", Volume: 50.0},
+		},
+	}
+
+	aggs, err := DoAgg(batch, prices, spec)
+	if err != nil {
+		t.Fatalf("DoAgg returned error: %v", err)
+	}
+	if len(aggs) != 1 {
+		t.Fatalf("Got %d Aggs, expected 1", len(aggs))
+	}
+
+	expected := []float64{100.0, 120.0} // EUR: 0.001*50000 + 50, USD: 0.001*60000 + 50*1.2
+	for i, volume := range expected {
+		if aggs[0].TotalVolume[i] != volume {
+			t.Errorf("Mismatch in %s: got %f, want %f", VolumeColumn(spec.Currencies[i]), aggs[0].TotalVolume[i], volume)
+		}
+	}
+
+	expectedColumns := []string{"Date", "ProjectId", "NumberOfTransactions", "TotalVolumeEur", "TotalVolumeUsd"}
+	for i, column := range spec.Columns() {
+		if column != expectedColumns[i] {
+			t.Errorf("Column %d: got %s, want %s", i, column, expectedColumns[i])
+		}
+	}
+
+	// No GBP prices
+	spec.Currencies = append(spec.Currencies, "gbp")
+	if err := spec.Validate(prices); err == nil {
+		t.Errorf("Expected an error for a reporting currency without prices")
+	}
+}
+
+func TestSpillReducer_GroupBy(t *testing.T) {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"SFL": 0.5})
+	spec, err := NewAggSpec([]string{"usd"})
+	if err != nil {
+		t.Fatalf("NewAggSpec returned error: %v", err)
+	}
+	if err := spec.SetGroupBy([]string{"date", "project_id", "country"}); err != nil {
+		t.Fatalf("SetGroupBy returned error: %v", err)
+	}
+
+	day := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	batches := []MicroBatch{
+		{Data: []Transaction{
+			{Timestamp: day, Dimensions: []string{"ProjectA", "DE"}, CurrencySymbol: "SFL", Volume: 2},
+			{Timestamp: day, Dimensions: []string{"ProjectA", "FR"}, CurrencySymbol: "SFL", Volume: 4},
+		}},
+		{Data: []Transaction{
+			{Timestamp: day, Dimensions: []string{"ProjectA", "DE"}, CurrencySymbol: "SFL", Volume: 6},
+		}},
+	}
+
+	// Each batch is mapped separately, then reduced by the same key
+	var results []AggResult
+	for _, batch := range batches {
+		aggs, err := DoAgg(batch, prices, spec)
+		if err != nil {
+			t.Fatalf("DoAgg returned error: %v", err)
+		}
+		results = append(results, AggResult{Agg: aggs})
+	}
+
+	reducer, aggs := reduce(t, spec, ReduceOptions{}, results)
+	if err := reducer.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	expected := map[string]Agg{
+		"DE": {Date: "2023-10-01", Dimensions: []string{"ProjectA", "DE"}, NumberOfTransactions: 2, TotalVolume: []float64{4}},
+		"FR": {Date: "2023-10-01", Dimensions: []string{"ProjectA", "FR"}, NumberOfTransactions: 1, TotalVolume: []float64{2}},
+	}
+	if len(aggs) != len(expected) {
+		t.Fatalf("Got %d Aggs, expected %d", len(aggs), len(expected))
+	}
+	for _, agg := range aggs {
+		if want := expected[agg.Dimensions[1]]; !reflect.DeepEqual(agg, want) {
+			t.Errorf("Mismatch for %v: got %+v, want %+v", agg.Dimensions, agg, want)
+		}
+	}
+}
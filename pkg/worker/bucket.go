@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"fmt"
+	"hodctl/pkg/io"
+	"time"
+)
+
+// TimeBucket is the period the transactions are grouped by
+type TimeBucket string
+
+const (
+	BucketHour  TimeBucket = "hour"  // Hours starting at the beginning of each hour
+	BucketDay   TimeBucket = "day"   // Days starting at midnight
+	BucketWeek  TimeBucket = "week"  // ISO weeks starting on Monday at midnight
+	BucketMonth TimeBucket = "month" // Months starting on the first day at midnight
+)
+
+// Names of the time bucket column
+const (
+	ColumnHour  = "Hour"
+	ColumnWeek  = "Week"
+	ColumnMonth = "Month"
+)
+
+// hourLayout is the layout of the hourly buckets, with the offset of the time zone
+const hourLayout = time.RFC3339
+
+// ParseTimeBucket parses the name of a TimeBucket
+func ParseTimeBucket(name string) (TimeBucket, error) {
+	switch bucket := TimeBucket(name); bucket {
+	case BucketHour, BucketDay, BucketWeek, BucketMonth:
+		return bucket, nil
+	}
+	return "", fmt.Errorf("unsupported time bucket %q, expected %s, %s, %s or %s", name, BucketHour, BucketDay, BucketWeek, BucketMonth)
+}
+
+// TimeBucket returns the time bucket of the spec, daily by default
+func (s *AggSpec) TimeBucket() TimeBucket {
+	if s.Bucket == "" {
+		return BucketDay
+	}
+	return s.Bucket
+}
+
+// location returns the time zone of the buckets, UTC by default
+func (s *AggSpec) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+	return s.Location
+}
+
+// BucketColumn returns the name of the output column of the time bucket: Hour, Date, Week or Month
+func (s *AggSpec) BucketColumn() string {
+	switch s.TimeBucket() {
+	case BucketHour:
+		return ColumnHour
+	case BucketWeek:
+		return ColumnWeek
+	case BucketMonth:
+		return ColumnMonth
+	default:
+		return ColumnDate
+	}
+}
+
+// BucketStart returns the start of the time bucket of t, in the spec's time zone
+func (s *AggSpec) BucketStart(t time.Time) time.Time {
+	t = t.In(s.location())
+	year, month, day := t.Date()
+	switch s.TimeBucket() {
+	case BucketHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case BucketWeek:
+		// Days since Monday, Sunday being the last day of the ISO week
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case BucketMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// BucketEnd returns the end (excluded) of the time bucket starting at start
+func (s *AggSpec) BucketEnd(start time.Time) time.Time {
+	switch s.TimeBucket() {
+	case BucketHour:
+		return start.Add(time.Hour)
+	case BucketWeek:
+		return start.AddDate(0, 0, 7)
+	case BucketMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// BucketLabel returns the label of the time bucket of t: the start time with its offset for hourly buckets,
+// the start date otherwise (e.g. the Monday of the week)
+func (s *AggSpec) BucketLabel(t time.Time) string {
+	start := s.BucketStart(t)
+	if s.TimeBucket() == BucketHour {
+		return start.Format(hourLayout)
+	}
+	return start.Format(io.PriceDateLayout)
+}
+
+// ParseBucket parses the label of a time bucket and returns its start
+func (s *AggSpec) ParseBucket(label string) (time.Time, error) {
+	if s.TimeBucket() == BucketHour {
+		return time.Parse(hourLayout, label)
+	}
+	return time.ParseInLocation(io.PriceDateLayout, label, s.location())
+}
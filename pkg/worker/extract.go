@@ -11,21 +11,28 @@ import (
 // Transaction struct to hold a cleaned transaction (after processing raw transactions)
 type Transaction struct {
 	Timestamp      time.Time
-	ProjectID      string
+	Dimensions     []string // Values of the AggSpec dimensions
+	UserID         string
+	SessionID      string
 	CurrencySymbol string
 	Volume         float64
+	Raw            *io.RawTransaction // Row of the transaction, for the outliers found after the cleanup
 }
 
 // MicroBatch struct to hold a batch of transactions for processing
 type MicroBatch struct {
-	Data []Transaction
-	Err  error
+	Data     []Transaction
+	File     string // Path of the file of the transactions
+	Filtered int    // Number of valid transactions excluded by the AggSpec filter
+	Skipped  int    // Number of transactions skipped for their unknown currency
+	Err      error
 }
 
 // Outlier struct to hold detected outliers and invalid transactions
 type Outlier struct {
 	Reason string
 	io.RawTransaction
+	File string `csv:"file"` // Path of the file of the transaction, empty for a single reader
 }
 
 type numsJson struct {
@@ -42,16 +49,19 @@ const (
 )
 
 // DoCleanup processes the raw transactions to clean data and detect outliers.
-// It returns a cleaned MicroBatch and a channel emitting Outliers.
-func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier) (MicroBatch, error) {
+// It returns a cleaned MicroBatch, with the values of the spec's dimensions, and a channel emitting Outliers.
+// The valid transactions excluded by the spec's filter are only counted.
+func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier, spec *AggSpec) (MicroBatch, error) {
 	var cleanedTransactions []Transaction
+	filtered := 0
 
 	// Process the raw transactions
-	for _, transaction := range batch.Data {
+	for i, transaction := range batch.Data {
 		parsedTime, err := time.Parse("2006-01-02 15:04:05", transaction.Timestamp)
 		if err != nil {
 			outlierChan <- Outlier{
 				RawTransaction: transaction,
+				File:           batch.File,
 				Reason:         fmt.Sprintf("invalid timestamp format: %v", err),
 			}
 			continue
@@ -63,6 +73,7 @@ func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier) (MicroBatch, error
 		if err != nil {
 			outlierChan <- Outlier{
 				RawTransaction: transaction,
+				File:           batch.File,
 				Reason:         fmt.Sprintf("invalid JSON format in Nums field: %v", err),
 			}
 			continue
@@ -74,6 +85,7 @@ func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier) (MicroBatch, error
 		if err != nil {
 			outlierChan <- Outlier{
 				RawTransaction: transaction,
+				File:           batch.File,
 				Reason:         fmt.Sprintf("invalid JSON format in Props field: %v", err),
 			}
 			continue
@@ -83,6 +95,7 @@ func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier) (MicroBatch, error
 		if err != nil {
 			outlierChan <- Outlier{
 				RawTransaction: transaction,
+				File:           batch.File,
 				Reason:         fmt.Sprintf("invalid volume format: %v", err),
 			}
 			continue
@@ -92,6 +105,7 @@ func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier) (MicroBatch, error
 		if currencyValueDecimal < 0 || currencyValueDecimal > MaxVolumeThreshold {
 			outlierChan <- Outlier{
 				RawTransaction: transaction,
+				File:           batch.File,
 				Reason:         fmt.Sprintf("volume over threshold: %f", currencyValueDecimal),
 			}
 			continue
@@ -99,14 +113,36 @@ func DoCleanup(batch io.MicroBatch, outlierChan chan Outlier) (MicroBatch, error
 
 		// TODO add more outlier detection rules here!
 
-		// If everything is valid, add the transaction to the cleaned list
-		cleanedTransactions = append(cleanedTransactions, Transaction{
+		cleaned := Transaction{
 			Timestamp:      parsedTime,
-			ProjectID:      transaction.ProjectID,
+			Dimensions:     spec.DimensionValues(transaction),
+			UserID:         transaction.UserID,
+			SessionID:      transaction.SessionID,
 			CurrencySymbol: propsJSON.CurrencySymbol,
 			Volume:         currencyValueDecimal,
-		})
+			Raw:            &batch.Data[i],
+		}
+
+		// Skip the transactions excluded by the filter
+		if spec.Filter != nil {
+			match, err := spec.Filter.Match(transaction, cleaned)
+			if err != nil {
+				outlierChan <- Outlier{
+					RawTransaction: transaction,
+					File:           batch.File,
+					Reason:         fmt.Sprintf("filter error: %v", err),
+				}
+				continue
+			}
+			if !match {
+				filtered++
+				continue
+			}
+		}
+
+		// If everything is valid, add the transaction to the cleaned list
+		cleanedTransactions = append(cleanedTransactions, cleaned)
 	}
 
-	return MicroBatch{Data: cleanedTransactions}, nil
+	return MicroBatch{Data: cleanedTransactions, File: batch.File, Filtered: filtered}, nil
 }
@@ -57,19 +57,19 @@ var rawTransactions = []io.RawTransaction{
 var expectedTransactions = []Transaction{
 	{
 		Timestamp:      time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectA",
+		Dimensions:     []string{"ProjectA"},
 		CurrencySymbol: "USD",
 		Volume:         100.0,
 	},
 	{
 		Timestamp:      time.Date(2023, 10, 1, 13, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectB",
+		Dimensions:     []string{"ProjectB"},
 		CurrencySymbol: "EUR",
 		Volume:         50.0,
 	},
 	{
 		Timestamp:      time.Date(2023, 10, 1, 17, 0, 0, 0, time.UTC),
-		ProjectID:      "ProjectG",
+		Dimensions:     []string{"ProjectG"},
 		CurrencySymbol: "CHF",
 		Volume:         200.0,
 	},
@@ -86,7 +86,7 @@ func TestDoCleanup(t *testing.T) {
 	outlierChan := make(chan Outlier, len(rawTransactions))
 
 	// TEST Call DoCleanup
-	cleanedBatch, err := DoCleanup(batch, outlierChan)
+	cleanedBatch, err := DoCleanup(batch, outlierChan, &DefaultAggSpec)
 	if err != nil {
 		t.Fatalf("DoCleanup returned error: %v", err)
 	}
@@ -109,8 +109,8 @@ func TestDoCleanup(t *testing.T) {
 		if !actual.Timestamp.Equal(expected.Timestamp) {
 			t.Errorf("Transaction %d: expected Timestamp %v, got %v", i, expected.Timestamp, actual.Timestamp)
 		}
-		if actual.ProjectID != expected.ProjectID {
-			t.Errorf("Transaction %d: expected ProjectID %s, got %s", i, expected.ProjectID, actual.ProjectID)
+		if actual.Dimensions[0] != expected.Dimensions[0] {
+			t.Errorf("Transaction %d: expected ProjectID %s, got %s", i, expected.Dimensions[0], actual.Dimensions[0])
 		}
 		if actual.CurrencySymbol != expected.CurrencySymbol {
 			t.Errorf("Transaction %d: expected CurrencySymbol %s, got %s", i, expected.CurrencySymbol, actual.CurrencySymbol)
@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"fmt"
+	"hodctl/pkg/io"
+	"slices"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/interpreter"
+)
+
+// Names of the parsed fields of the transactions in the filter expressions
+const (
+	filterTimestamp      = "timestamp"
+	filterCurrencySymbol = "currency_symbol"
+	filterVolume         = "volume"
+)
+
+// rawFields maps the raw fields of the transactions in the filter expressions, by CSV column, to their value
+var rawFields = map[string]func(io.RawTransaction) string{
+	"ts":    func(t io.RawTransaction) string { return t.Timestamp },
+	"props": func(t io.RawTransaction) string { return t.Props },
+	"nums":  func(t io.RawTransaction) string { return t.Nums },
+}
+
+func init() {
+	for name, value := range dimensions {
+		rawFields[name] = value
+	}
+}
+
+// Filter is a CEL expression selecting the transactions to aggregate, e.g. event == "BUY_ITEMS" && volume > 0.
+// The raw fields are strings named after their CSV column, the parsed fields are timestamp (timestamp),
+// currency_symbol (string) and volume (double).
+type Filter struct {
+	Expression string
+	program    cel.Program
+	columns    []string // Raw fields of the expression, sorted
+}
+
+// NewFilter compiles the expression and checks it is a boolean over the fields of the transactions
+func NewFilter(expression string) (*Filter, error) {
+	options := []cel.EnvOption{
+		// volume > 0 compares the double with an int literal
+		cel.CrossTypeNumericComparisons(true),
+		cel.Variable(filterTimestamp, cel.TimestampType),
+		cel.Variable(filterCurrencySymbol, cel.StringType),
+		cel.Variable(filterVolume, cel.DoubleType),
+	}
+	for name := range rawFields {
+		options = append(options, cel.Variable(name, cel.StringType))
+	}
+	env, err := cel.NewEnv(options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filter environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, fmt.Errorf("invalid filter: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("invalid filter: the expression is a %s, expected a bool", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	var columns []string
+	for _, reference := range ast.NativeRep().ReferenceMap() {
+		if _, raw := rawFields[reference.Name]; raw && !slices.Contains(columns, reference.Name) {
+			columns = append(columns, reference.Name)
+		}
+	}
+	slices.Sort(columns)
+	return &Filter{Expression: expression, program: program, columns: columns}, nil
+}
+
+// Columns returns the CSV columns of the raw fields the expression uses, sorted
+func (f *Filter) Columns() []string {
+	return f.columns
+}
+
+// Match returns whether the transaction, with its raw fields, is selected by the filter
+func (f *Filter) Match(raw io.RawTransaction, transaction Transaction) (bool, error) {
+	out, _, err := f.program.Eval(&filterActivation{raw: &raw, transaction: &transaction})
+	if err != nil {
+		return false, err
+	}
+	match, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("the filter returned %v, expected a bool", out.Value())
+	}
+	return match, nil
+}
+
+// filterActivation resolves the fields of a transaction only when the filter uses them
+type filterActivation struct {
+	raw         *io.RawTransaction
+	transaction *Transaction
+}
+
+func (a *filterActivation) ResolveName(name string) (any, bool) {
+	switch name {
+	case filterTimestamp:
+		return a.transaction.Timestamp, true
+	case filterCurrencySymbol:
+		return a.transaction.CurrencySymbol, true
+	case filterVolume:
+		return a.transaction.Volume, true
+	}
+	if value, exists := rawFields[name]; exists {
+		return value(*a.raw), true
+	}
+	return nil, false
+}
+
+func (a *filterActivation) Parent() interpreter.Activation {
+	return nil
+}
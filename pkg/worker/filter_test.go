@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"testing"
+
+	"hodctl/pkg/io"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFilter(t *testing.T) {
+	_, err := NewFilter(`event == "BUY_ITEMS" && country in ["DE", "FR"] && volume > 0.0`)
+	assert.NoError(t, err)
+	// The numbers compare across types, e.g. the double volume with an int
+	_, err = NewFilter(`event == "BUY_ITEMS" && country in ["DE","FR"] && volume > 0`)
+	assert.NoError(t, err)
+	_, err = NewFilter(`timestamp > timestamp("2024-04-15T00:00:00Z") && currency_symbol.startsWith("S")`)
+	assert.NoError(t, err)
+
+	// Checked against the fields and their types at startup
+	_, err = NewFilter(`planet == "Mars"`)
+	assert.ErrorContains(t, err, "undeclared reference to 'planet'")
+	_, err = NewFilter(`volume > "0"`)
+	assert.ErrorContains(t, err, "invalid filter")
+	_, err = NewFilter(`volume * 2.0`)
+	assert.EqualError(t, err, "invalid filter: the expression is a double, expected a bool")
+}
+
+func TestDoCleanup_Filter(t *testing.T) {
+	filter, err := NewFilter(`event == "BUY_ITEMS" && country in ["DE", "FR"] && volume > 1`)
+	assert.NoError(t, err)
+	spec := DefaultAggSpec
+	spec.Filter = filter
+
+	transaction := func(event, country, volume string) io.RawTransaction {
+		return io.RawTransaction{
+			Timestamp: "2024-04-15 02:15:07",
+			ProjectID: "4974",
+			Event:     event,
+			Country:   country,
+			Props:     `{"currencySymbol": "SFL"}`,
+			Nums:      `{"currencyValueDecimal": "` + volume + `"}`,
+		}
+	}
+	batch := io.MicroBatch{Data: []io.RawTransaction{
+		transaction("BUY_ITEMS", "DE", "2"),
+		transaction("BUY_ITEMS", "FR", "3"),
+		transaction("SELL_ITEMS", "DE", "2"),
+		transaction("BUY_ITEMS", "US", "2"),
+		transaction("BUY_ITEMS", "DE", "0.5"),
+		transaction("BUY_ITEMS", "DE", "-1"), // Outlier
+	}}
+
+	outlierChan := make(chan Outlier, len(batch.Data))
+	cleaned, err := DoCleanup(batch, outlierChan, &spec)
+	assert.NoError(t, err)
+	assert.Len(t, cleaned.Data, 2)
+	assert.Equal(t, 3, cleaned.Filtered)
+	assert.Len(t, outlierChan, 1)
+
+	var stats RunStats
+	stats.AddBatch(len(batch.Data), cleaned)
+	assert.Equal(t, "6 transactions read, 2 aggregated, 1 outliers, 3 filtered out, 0 skipped", stats.String())
+}
@@ -0,0 +1,288 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+	"github.com/DataDog/sketches-go/ddsketch/store"
+	"github.com/axiomhq/hyperloglog"
+)
+
+// Metric is an optional metric of the aggregates, on top of the number of transactions and the total volumes
+type Metric string
+
+const (
+	MetricMin              Metric = "min"               // Minimum volume in each reporting currency
+	MetricMax              Metric = "max"               // Maximum volume in each reporting currency
+	MetricMean             Metric = "mean"              // Mean volume in each reporting currency
+	MetricDistinctUsers    Metric = "distinct_users"    // Approximate number of distinct user_id
+	MetricDistinctSessions Metric = "distinct_sessions" // Approximate number of distinct session_id
+	MetricP50              Metric = "p50"               // Approximate median volume in each reporting currency
+	MetricP95              Metric = "p95"               // Approximate 95th percentile volume in each reporting currency
+	MetricP99              Metric = "p99"               // Approximate 99th percentile volume in each reporting currency
+)
+
+// Metrics lists the supported metrics, in output order
+var Metrics = []Metric{MetricMin, MetricMax, MetricMean, MetricDistinctUsers, MetricDistinctSessions, MetricP50, MetricP95, MetricP99}
+
+// QuantileAccuracy is the relative accuracy of the volume quantiles
+const QuantileAccuracy = 0.01
+
+// quantiles of the quantile metrics
+var quantiles = map[Metric]float64{MetricP50: 0.5, MetricP95: 0.95, MetricP99: 0.99}
+
+// ParseMetrics parses the names of the metrics, and returns them in output order
+func ParseMetrics(names []string) ([]Metric, error) {
+	var parsed []Metric
+	for _, name := range names {
+		metric := Metric(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(Metrics, metric) {
+			return nil, fmt.Errorf("unsupported metric %q, expected any of %s", name, joinMetrics(Metrics))
+		}
+		if !slices.Contains(parsed, metric) {
+			parsed = append(parsed, metric)
+		}
+	}
+	slices.SortFunc(parsed, func(a, b Metric) int {
+		return slices.Index(Metrics, a) - slices.Index(Metrics, b)
+	})
+	return parsed, nil
+}
+
+func joinMetrics(metrics []Metric) string {
+	names := make([]string, len(metrics))
+	for i, metric := range metrics {
+		names[i] = string(metric)
+	}
+	return strings.Join(names, ", ")
+}
+
+// AggMetrics is the mergeable state of the optional metrics of an aggregate, only the states of the spec's metrics are set.
+// The mean is derived from the total volume and the number of transactions.
+type AggMetrics struct {
+	MinVolume []float64            // Minimum volume in each reporting currency
+	MaxVolume []float64            // Maximum volume in each reporting currency
+	Users     *hyperloglog.Sketch  // Sketch of the distinct user_id
+	Sessions  *hyperloglog.Sketch  // Sketch of the distinct session_id
+	Volumes   []*ddsketch.DDSketch // Quantile sketch of the volumes in each reporting currency
+}
+
+// newMetrics returns the empty state of the spec's metrics, nil without optional metric
+func (s *AggSpec) newMetrics() (*AggMetrics, error) {
+	if len(s.Metrics) == 0 {
+		return nil, nil
+	}
+
+	m := &AggMetrics{}
+	for _, metric := range s.Metrics {
+		switch metric {
+		case MetricMin:
+			m.MinVolume = filled(len(s.Currencies), math.Inf(1))
+		case MetricMax:
+			m.MaxVolume = filled(len(s.Currencies), math.Inf(-1))
+		case MetricDistinctUsers:
+			m.Users = hyperloglog.New14()
+		case MetricDistinctSessions:
+			m.Sessions = hyperloglog.New14()
+		case MetricP50, MetricP95, MetricP99:
+			if m.Volumes != nil {
+				continue
+			}
+			m.Volumes = make([]*ddsketch.DDSketch, len(s.Currencies))
+			for i := range m.Volumes {
+				sketch, err := ddsketch.NewDefaultDDSketch(QuantileAccuracy)
+				if err != nil {
+					return nil, fmt.Errorf("failed to create quantile sketch: %w", err)
+				}
+				m.Volumes[i] = sketch
+			}
+		}
+	}
+	return m, nil
+}
+
+func filled(n int, value float64) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+// addVolume records the volume of a transaction in the i-th reporting currency
+func (m *AggMetrics) addVolume(i int, volume float64) error {
+	if m.MinVolume != nil {
+		m.MinVolume[i] = math.Min(m.MinVolume[i], volume)
+	}
+	if m.MaxVolume != nil {
+		m.MaxVolume[i] = math.Max(m.MaxVolume[i], volume)
+	}
+	if m.Volumes != nil {
+		if err := m.Volumes[i].Add(volume); err != nil {
+			return fmt.Errorf("failed to add volume %f to the quantile sketch: %w", volume, err)
+		}
+	}
+	return nil
+}
+
+// addIDs records the user and session of a transaction
+func (m *AggMetrics) addIDs(userID, sessionID string) {
+	if m.Users != nil {
+		m.Users.Insert([]byte(userID))
+	}
+	if m.Sessions != nil {
+		m.Sessions.Insert([]byte(sessionID))
+	}
+}
+
+// merge combines the state of other, computed with the same spec, into m
+func (m *AggMetrics) merge(other *AggMetrics) error {
+	for i := range m.MinVolume {
+		m.MinVolume[i] = math.Min(m.MinVolume[i], other.MinVolume[i])
+	}
+	for i := range m.MaxVolume {
+		m.MaxVolume[i] = math.Max(m.MaxVolume[i], other.MaxVolume[i])
+	}
+	if m.Users != nil {
+		if err := m.Users.Merge(other.Users); err != nil {
+			return fmt.Errorf("failed to merge distinct users: %w", err)
+		}
+	}
+	if m.Sessions != nil {
+		if err := m.Sessions.Merge(other.Sessions); err != nil {
+			return fmt.Errorf("failed to merge distinct sessions: %w", err)
+		}
+	}
+	for i := range m.Volumes {
+		if err := m.Volumes[i].MergeWith(other.Volumes[i]); err != nil {
+			return fmt.Errorf("failed to merge volume quantiles: %w", err)
+		}
+	}
+	return nil
+}
+
+// Estimated memory of the sketches, for the memory budget of the reducer.
+// HyperLogLog sketches are sparse while small, but the estimate is their dense size to stay on the safe side.
+const (
+	hllSizeEstimate      = 1 << 14
+	ddSketchSizeEstimate = 2 << 10
+)
+
+// sizeEstimate returns the estimated memory of the state in bytes
+func (m *AggMetrics) sizeEstimate() int64 {
+	size := int64(8 * (len(m.MinVolume) + len(m.MaxVolume)))
+	if m.Users != nil {
+		size += hllSizeEstimate
+	}
+	if m.Sessions != nil {
+		size += hllSizeEstimate
+	}
+	return size + int64(ddSketchSizeEstimate*len(m.Volumes))
+}
+
+// aggMetricsState is the serialized AggMetrics, the quantile sketches are encoded with their index mapping
+type aggMetricsState struct {
+	MinVolume []float64
+	MaxVolume []float64
+	Users     *hyperloglog.Sketch
+	Sessions  *hyperloglog.Sketch
+	Volumes   [][]byte
+}
+
+// GobEncode serializes the state of the metrics, to spill or checkpoint partial aggregates
+func (m *AggMetrics) GobEncode() ([]byte, error) {
+	state := aggMetricsState{MinVolume: m.MinVolume, MaxVolume: m.MaxVolume, Users: m.Users, Sessions: m.Sessions}
+	for _, sketch := range m.Volumes {
+		var encoded []byte
+		sketch.Encode(&encoded, false)
+		state.Volumes = append(state.Volumes, encoded)
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode deserializes the state of the metrics encoded by GobEncode
+func (m *AggMetrics) GobDecode(data []byte) error {
+	var state aggMetricsState
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
+		return err
+	}
+	*m = AggMetrics{MinVolume: state.MinVolume, MaxVolume: state.MaxVolume, Users: state.Users, Sessions: state.Sessions}
+	for _, encoded := range state.Volumes {
+		sketch, err := ddsketch.DecodeDDSketch(encoded, store.DefaultProvider, nil)
+		if err != nil {
+			return fmt.Errorf("failed to decode quantile sketch: %w", err)
+		}
+		m.Volumes = append(m.Volumes, sketch)
+	}
+	return nil
+}
+
+// MetricColumn is an output column of an optional metric
+type MetricColumn struct {
+	Name    string // Name of the output column, e.g. MinVolumeUsd
+	Integer bool   // Whether the values are counts
+	value   func(agg Agg) float64
+}
+
+// Value returns the value of the column for the aggregate
+func (c MetricColumn) Value(agg Agg) float64 {
+	return c.value(agg)
+}
+
+// MetricColumns returns the output columns of the spec's metrics, one per reporting currency for the volume metrics
+func (s *AggSpec) MetricColumns() []MetricColumn {
+	var columns []MetricColumn
+	for _, metric := range s.Metrics {
+		switch metric {
+		case MetricDistinctUsers:
+			columns = append(columns, MetricColumn{Name: "DistinctUsers", Integer: true, value: func(agg Agg) float64 {
+				return float64(agg.Metrics.Users.Estimate())
+			}})
+		case MetricDistinctSessions:
+			columns = append(columns, MetricColumn{Name: "DistinctSessions", Integer: true, value: func(agg Agg) float64 {
+				return float64(agg.Metrics.Sessions.Estimate())
+			}})
+		default:
+			for i, currency := range s.Currencies {
+				columns = append(columns, MetricColumn{Name: currencyColumn(volumeMetricPrefix(metric), currency), value: volumeMetric(metric, i)})
+			}
+		}
+	}
+	return columns
+}
+
+// volumeMetricPrefix returns the prefix of the volume metric columns, e.g. P95Volume
+func volumeMetricPrefix(metric Metric) string {
+	name := string(metric)
+	return strings.ToUpper(name[:1]) + name[1:] + "Volume"
+}
+
+// volumeMetric returns the value of the volume metric in the i-th reporting currency
+func volumeMetric(metric Metric, i int) func(agg Agg) float64 {
+	switch metric {
+	case MetricMin:
+		return func(agg Agg) float64 { return agg.Metrics.MinVolume[i] }
+	case MetricMax:
+		return func(agg Agg) float64 { return agg.Metrics.MaxVolume[i] }
+	case MetricMean:
+		return func(agg Agg) float64 { return agg.TotalVolume[i] / float64(agg.NumberOfTransactions) }
+	default:
+		quantile := quantiles[metric]
+		return func(agg Agg) float64 {
+			value, err := agg.Metrics.Volumes[i].GetValueAtQuantile(quantile)
+			if err != nil {
+				return math.NaN()
+			}
+			return value
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package worker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetrics(t *testing.T) {
+	metrics, err := ParseMetrics([]string{"p99", "MIN", "distinct_users", "min"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Metric{MetricMin, MetricDistinctUsers, MetricP99}, metrics)
+
+	_, err = ParseMetrics([]string{"median"})
+	assert.ErrorContains(t, err, `unsupported metric "median"`)
+}
+
+func TestSpillReducer_Metrics(t *testing.T) {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"SFL": 1, "EUR": 2})
+	prices.Quotes["eur"] = &io.QuotePrices{Snapshot: io.Currency2Values{"SFL": 0.5, "EUR": 1}}
+	spec, err := NewAggSpec([]string{"usd", "eur"})
+	assert.NoError(t, err)
+	spec.Metrics = Metrics
+
+	// Volumes 1 to 100 SFL by 10 users in 20 sessions, split in 4 batches mapped separately
+	day := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	var results []AggResult
+	for b := 0; b < 4; b++ {
+		var batch MicroBatch
+		for i := 1 + b*25; i <= (b+1)*25; i++ {
+			batch.Data = append(batch.Data, Transaction{
+				Timestamp:      day,
+				Dimensions:     []string{"ProjectA"},
+				UserID:         fmt.Sprintf("user-%d", i%10),
+				SessionID:      fmt.Sprintf("session-%d", i%20),
+				CurrencySymbol: "SFL",
+				Volume:         float64(i),
+			})
+		}
+		aggs, err := DoAgg(batch, prices, spec)
+		assert.NoError(t, err)
+		results = append(results, AggResult{Agg: aggs})
+	}
+
+	reducer, aggs := reduce(t, spec, ReduceOptions{}, results)
+	assert.NoError(t, reducer.Close())
+	assert.Len(t, aggs, 1)
+
+	values := make(map[string]float64)
+	for _, column := range spec.MetricColumns() {
+		values[column.Name] = column.Value(aggs[0])
+	}
+	assert.Equal(t, 100, aggs[0].NumberOfTransactions)
+	assert.Equal(t, []float64{5050, 2525}, aggs[0].TotalVolume)
+	assert.Equal(t, 1.0, values["MinVolumeUsd"])
+	assert.Equal(t, 0.5, values["MinVolumeEur"])
+	assert.Equal(t, 100.0, values["MaxVolumeUsd"])
+	assert.Equal(t, 50.5, values["MeanVolumeUsd"])
+	assert.Equal(t, 25.25, values["MeanVolumeEur"])
+	assert.Equal(t, 10.0, values["DistinctUsers"])
+	assert.Equal(t, 20.0, values["DistinctSessions"])
+	assert.InEpsilon(t, 50, values["P50VolumeUsd"], 2*QuantileAccuracy)
+	assert.InEpsilon(t, 95, values["P95VolumeUsd"], 2*QuantileAccuracy)
+	assert.InEpsilon(t, 99, values["P99VolumeUsd"], 2*QuantileAccuracy)
+	assert.InEpsilon(t, 49.5, values["P99VolumeEur"], 2*QuantileAccuracy)
+
+	assert.Equal(t, []string{"Date", "ProjectId", "NumberOfTransactions", "TotalVolumeUsd", "TotalVolumeEur",
+		"MinVolumeUsd", "MinVolumeEur", "MaxVolumeUsd", "MaxVolumeEur", "MeanVolumeUsd", "MeanVolumeEur", "DistinctUsers", "DistinctSessions",
+		"P50VolumeUsd", "P50VolumeEur", "P95VolumeUsd", "P95VolumeEur", "P99VolumeUsd", "P99VolumeEur"}, spec.Columns())
+}
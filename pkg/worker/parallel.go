@@ -1,6 +1,8 @@
 package worker
 
 import (
+	"context"
+	"hash/fnv"
 	"hodctl/pkg/io"
 	"log"
 	"sync"
@@ -18,43 +20,91 @@ type AggResult struct {
 
 // Do is the function signature for any worker function.
 // For now we only have a Agg worker function
-type Do = func(batch io.MicroBatch, chOutlier chan Outlier, currencyValues *io.Currency2Values) ([]Agg, error)
+type Do = func(batch io.MicroBatch, chOutlier chan Outlier, prices *io.PriceTable, spec *AggSpec) ([]Agg, error)
 
 // ParallelProcessing distributes the load to NumWorkers workers,
 // ensuring only one worker processes each transaction at a time.
-func ParallelProcessing(chInput <-chan io.MicroBatch, chOutlier chan Outlier, worker Do, currencyValues *io.Currency2Values, parallelism int) <-chan AggResult {
-	aggChan := make(chan AggResult, ChannelBufferSize)
+// The partial aggregates of the workers are partitioned by group key into the given number of channels,
+// so each reducer owns a disjoint slice of the group keys.
+// The workers stop taking new batches as soon as the context is done.
+func ParallelProcessing(ctx context.Context, chInput <-chan io.MicroBatch, chOutlier chan Outlier, worker Do, prices *io.PriceTable, spec *AggSpec, parallelism int, partitions int) []<-chan AggResult {
+	aggChans := make([]chan AggResult, partitions)
+	outs := make([]<-chan AggResult, partitions)
+	for p := range aggChans {
+		aggChans[p] = make(chan AggResult, ChannelBufferSize)
+		outs[p] = aggChans[p]
+	}
 
-	// WaitGroup for workers, to ensure all workers are done before closing the aggChan
+	// WaitGroup for workers, to ensure all workers are done before closing the aggChans
 	var wg sync.WaitGroup
 
 	for i := 0; i < parallelism; i++ {
 		wg.Add(1)
-		go Worker(chInput, aggChan, chOutlier, &wg, currencyValues, worker)
+		go Worker(ctx, chInput, aggChans, chOutlier, &wg, prices, spec, worker)
 	}
 
-	// Close the aggChan when all workers are done
+	// Close the aggChans when all workers are done
 	go func() {
 		wg.Wait()
-		close(aggChan)
-		log.Printf("All workers done, closing agg chan\n")
+		for _, aggChan := range aggChans {
+			close(aggChan)
+		}
+		log.Printf("All workers done, closing agg chans\n")
 	}()
 
-	log.Printf("Launched %d workers, returning agg chan\n", parallelism)
-	return aggChan
+	log.Printf("Launched %d workers, returning %d agg chans\n", parallelism, partitions)
+	return outs
 }
 
-// Worker processes each micro-batch and sends the result to the output channel or the outlier channel.
-func Worker(in <-chan io.MicroBatch, out chan AggResult, chOutlier chan Outlier, wg *sync.WaitGroup, currencyValues *io.Currency2Values, worker Do) {
+// Worker processes each micro-batch and sends the result, partitioned by group key, to the output channels or the outlier channel,
+// until the input channel is closed or the context is done. The errors are sent to the first output channel.
+func Worker(ctx context.Context, in <-chan io.MicroBatch, outs []chan AggResult, chOutlier chan Outlier, wg *sync.WaitGroup, prices *io.PriceTable, spec *AggSpec, worker Do) {
 	defer wg.Done()
 
-	for batch := range in {
+	for {
+		var batch io.MicroBatch
+		select {
+		case <-ctx.Done():
+			return
+		case next, ok := <-in:
+			if !ok {
+				return
+			}
+			batch = next
+		}
+
 		// Process each batch and generate Agg
-		agg, err := worker(batch, chOutlier, currencyValues)
-		if err != nil {
-			out <- AggResult{Err: err}
-			continue
+		result := AggResult{}
+		result.Agg, result.Err = worker(batch, chOutlier, prices, spec)
+		for p, partition := range partitionResult(result, len(outs)) {
+			if partition.Err == nil && len(partition.Agg) == 0 {
+				continue
+			}
+			select {
+			case outs[p] <- partition:
+			case <-ctx.Done():
+				return
+			}
 		}
-		out <- AggResult{Agg: agg}
 	}
 }
+
+// partitionResult splits the aggregates of the result into n partitions by hash of their group key
+func partitionResult(result AggResult, n int) []AggResult {
+	if n == 1 || result.Err != nil {
+		return []AggResult{result}
+	}
+	partitions := make([]AggResult, n)
+	for _, agg := range result.Agg {
+		p := partitionOf(groupKey(agg.Date, agg.Dimensions), n)
+		partitions[p].Agg = append(partitions[p].Agg, agg)
+	}
+	return partitions
+}
+
+// partitionOf returns the partition of the group key among n
+func partitionOf(key string, n int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(n))
+}
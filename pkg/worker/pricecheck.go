@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"fmt"
+	"hodctl/pkg/io"
+)
+
+// UnknownCurrencyPolicy decides what happens to the transactions in a currency without price
+type UnknownCurrencyPolicy string
+
+const (
+	UnknownCurrencyFail       UnknownCurrencyPolicy = "fail"        // The run fails on the first unknown currency
+	UnknownCurrencyDeadLetter UnknownCurrencyPolicy = "dead-letter" // The transactions are sent to the outliers
+	UnknownCurrencySkip       UnknownCurrencyPolicy = "skip"        // The transactions are only counted
+)
+
+// ParseUnknownCurrencyPolicy parses the name of an UnknownCurrencyPolicy
+func ParseUnknownCurrencyPolicy(name string) (UnknownCurrencyPolicy, error) {
+	switch policy := UnknownCurrencyPolicy(name); policy {
+	case UnknownCurrencyFail, UnknownCurrencyDeadLetter, UnknownCurrencySkip:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported unknown currency policy %q, expected %s, %s or %s", name, UnknownCurrencyFail, UnknownCurrencyDeadLetter, UnknownCurrencySkip)
+}
+
+// DoPriceCheck removes the transactions without price in every reporting currency from the batch, following the spec's
+// UnknownCurrencies policy: an error for fail (the default), an Outlier for dead-letter, and only a count for skip.
+func DoPriceCheck(batch MicroBatch, outlierChan chan Outlier, prices *io.PriceTable, spec *AggSpec) (MicroBatch, error) {
+	priced := batch.Data[:0]
+	for _, transaction := range batch.Data {
+		reason := unknownCurrency(transaction, prices, spec)
+		if reason == "" {
+			priced = append(priced, transaction)
+			continue
+		}
+
+		switch spec.UnknownCurrencies {
+		case UnknownCurrencyDeadLetter:
+			outlierChan <- Outlier{
+				RawTransaction: *transaction.Raw,
+				Reason:         reason,
+				File:           batch.File,
+			}
+		case UnknownCurrencySkip:
+			batch.Skipped++
+		default:
+			return MicroBatch{}, fmt.Errorf("%s", reason)
+		}
+	}
+	batch.Data = priced
+	return batch, nil
+}
+
+// unknownCurrency returns why the transaction cannot be converted to the reporting currencies, empty if it can
+func unknownCurrency(transaction Transaction, prices *io.PriceTable, spec *AggSpec) string {
+	date := transaction.Timestamp.UTC().Format(io.PriceDateLayout)
+	for _, currency := range spec.Currencies {
+		if _, exists := prices.Price(currency, transaction.CurrencySymbol, date); !exists {
+			return fmt.Sprintf("currency symbol not supported: %s on %s in %s", transaction.CurrencySymbol, date, currency)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoPriceCheck(t *testing.T) {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"SFL": 0.5})
+	raw := []io.RawTransaction{{ProjectID: "4974"}, {ProjectID: "0"}, {ProjectID: "1"}}
+	newBatch := func() MicroBatch {
+		day := time.Date(2024, 4, 15, 12, 0, 0, 0, time.UTC)
+		return MicroBatch{Data: []Transaction{
+			{Timestamp: day, CurrencySymbol: "SFL", Volume: 1, Raw: &raw[0]},
+			{Timestamp: day, CurrencySymbol: "EXOTIC", Volume: 2, Raw: &raw[1]},
+			{Timestamp: day, CurrencySymbol: "SFL", Volume: 3, Raw: &raw[2]},
+		}}
+	}
+
+	// The run fails by default
+	outlierChan := make(chan Outlier, 3)
+	_, err := DoPriceCheck(newBatch(), outlierChan, prices, &AggSpec{Currencies: []string{"usd"}})
+	assert.EqualError(t, err, "currency symbol not supported: EXOTIC on 2024-04-15 in usd")
+
+	// Only the transactions in the unknown currency are sent to the outliers
+	spec := &AggSpec{Currencies: []string{"usd"}, UnknownCurrencies: UnknownCurrencyDeadLetter}
+	batch, err := DoPriceCheck(newBatch(), outlierChan, prices, spec)
+	assert.NoError(t, err)
+	assert.Len(t, batch.Data, 2)
+	assert.Equal(t, 0, batch.Skipped)
+	assert.Len(t, outlierChan, 1)
+	outlier := <-outlierChan
+	assert.Equal(t, "0", outlier.ProjectID)
+	assert.Equal(t, "currency symbol not supported: EXOTIC on 2024-04-15 in usd", outlier.Reason)
+
+	// Or only counted
+	spec.UnknownCurrencies = UnknownCurrencySkip
+	batch, err = DoPriceCheck(newBatch(), outlierChan, prices, spec)
+	assert.NoError(t, err)
+	assert.Len(t, batch.Data, 2)
+	assert.Equal(t, 1, batch.Skipped)
+	assert.Len(t, outlierChan, 0)
+
+	_, err = ParseUnknownCurrencyPolicy("ignore")
+	assert.EqualError(t, err, `unsupported unknown currency policy "ignore", expected fail, dead-letter or skip`)
+}
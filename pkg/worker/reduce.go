@@ -0,0 +1,135 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PartitionedReducer reduces the partitions of the partial aggregates concurrently, one SpillReducer each.
+// The partitions own disjoint slices of the group keys, so their aggregates are only concatenated.
+type PartitionedReducer struct {
+	reducers []*SpillReducer
+}
+
+// NewPartitionedReducer creates the reducers of the ReduceOptions partitions, sharing the memory budget
+func NewPartitionedReducer(spec *AggSpec, options ReduceOptions) (*PartitionedReducer, error) {
+	partitions := options.Partitions
+	if partitions <= 0 {
+		partitions = 1
+	}
+	if options.MemoryBudget <= 0 {
+		options.MemoryBudget = DefaultMemoryBudget
+	}
+	options.MemoryBudget = max(options.MemoryBudget/int64(partitions), 1)
+
+	r := &PartitionedReducer{}
+	for i := 0; i < partitions; i++ {
+		reducer, err := NewSpillReducer(spec, options)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.reducers = append(r.reducers, reducer)
+	}
+	return r, nil
+}
+
+// ReducerState is the state of a PartitionedReducer saved by a checkpoint, every group being in the runs
+type ReducerState struct {
+	Runs      [][]string // Paths of the runs of each partition
+	WindowEnd time.Time  // End of the latest time bucket
+}
+
+// Checkpoint spills the groups in memory of every partition and returns the state of the reducer
+func (r *PartitionedReducer) Checkpoint() (ReducerState, error) {
+	state := ReducerState{WindowEnd: r.WindowEnd()}
+	for _, reducer := range r.reducers {
+		runs, err := reducer.Checkpoint()
+		if err != nil {
+			return state, err
+		}
+		state.Runs = append(state.Runs, runs)
+	}
+	return state, nil
+}
+
+// Restore adds the runs of a checkpoint of a reducer with the same number of partitions
+func (r *PartitionedReducer) Restore(state ReducerState) error {
+	if len(state.Runs) != len(r.reducers) {
+		return fmt.Errorf("the checkpoint has %d reducer partitions, expected %d", len(state.Runs), len(r.reducers))
+	}
+	for i, reducer := range r.reducers {
+		reducer.Restore(state.Runs[i], state.WindowEnd)
+	}
+	return nil
+}
+
+// Partitions returns the number of partitions of the group keys
+func (r *PartitionedReducer) Partitions() int {
+	return len(r.reducers)
+}
+
+// Reduce combines each partition with its reducer, in one goroutine per partition, until every partition is closed.
+// When a partition fails, stop is called so the workers stop, and what is left of the partition is drained.
+func (r *PartitionedReducer) Reduce(partitions []<-chan AggResult, stop func()) error {
+	errs := make([]error, len(partitions))
+	var wg sync.WaitGroup
+	for i, in := range partitions {
+		wg.Add(1)
+		go func(i int, in <-chan AggResult) {
+			defer wg.Done()
+			if err := r.reducers[i].Reduce(in); err != nil {
+				errs[i] = err
+				stop()
+				for range in {
+				}
+			}
+		}(i, in)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// WindowEnd returns the end of the latest time bucket of the aggregates, zero without aggregate
+func (r *PartitionedReducer) WindowEnd() time.Time {
+	var end time.Time
+	for _, reducer := range r.reducers {
+		if reducer.WindowEnd().After(end) {
+			end = reducer.WindowEnd()
+		}
+	}
+	return end
+}
+
+// Spills returns the number of runs spilled to disk by all the reducers
+func (r *PartitionedReducer) Spills() int {
+	spills := 0
+	for _, reducer := range r.reducers {
+		spills += reducer.Spills()
+	}
+	return spills
+}
+
+// Emit sends the aggregates of each partition in turn, see SpillReducer.Emit, and returns the number of aggregates
+func (r *PartitionedReducer) Emit(batchSize int, emit func([]Agg) error) (int, error) {
+	count := 0
+	for _, reducer := range r.reducers {
+		n, err := reducer.Emit(batchSize, emit)
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Close removes the runs spilled by all the reducers
+func (r *PartitionedReducer) Close() error {
+	var errs []error
+	for _, reducer := range r.reducers {
+		errs = append(errs, reducer.Close())
+	}
+	return errors.Join(errs...)
+}
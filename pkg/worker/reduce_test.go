@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// userBatch returns n transactions of users spread over the hours of a week
+func userBatch(n int, users int) MicroBatch {
+	var batch MicroBatch
+	start := time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		batch.Data = append(batch.Data, Transaction{
+			Timestamp:      start.Add(time.Duration(i%168) * time.Hour),
+			Dimensions:     []string{fmt.Sprintf("user-%d", i%users)},
+			CurrencySymbol: "SFL",
+			Volume:         float64(i),
+		})
+	}
+	return batch
+}
+
+// partitionedAgg maps the batch of transactions the given number of times and reduces them in the given number of partitions
+func partitionedAgg(batch MicroBatch, spec *AggSpec, batches int, parallelism int, partitions int) ([]Agg, error) {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"SFL": 2})
+	reducer, err := NewPartitionedReducer(spec, ReduceOptions{Partitions: partitions})
+	if err != nil {
+		return nil, err
+	}
+	defer reducer.Close()
+
+	in := make(chan io.MicroBatch, batches)
+	for i := 0; i < batches; i++ {
+		in <- io.MicroBatch{}
+	}
+	close(in)
+	doAgg := func(io.MicroBatch, chan Outlier, *io.PriceTable, *AggSpec) ([]Agg, error) {
+		return DoAgg(batch, prices, spec)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	partials := ParallelProcessing(ctx, in, nil, doAgg, prices, spec, parallelism, reducer.Partitions())
+	if err := reducer.Reduce(partials, cancel); err != nil {
+		return nil, err
+	}
+
+	var aggs []Agg
+	_, err = reducer.Emit(1000, func(batch []Agg) error {
+		aggs = append(aggs, batch...)
+		return nil
+	})
+	return aggs, err
+}
+
+func TestPartitionedReducer(t *testing.T) {
+	spec := &AggSpec{Dimensions: []string{"user_id"}, Bucket: BucketHour, Currencies: []string{"usd"}, Metrics: []Metric{MetricMax}}
+	batch := userBatch(1000, 50)
+
+	expected, err := partitionedAgg(batch, spec, 8, 4, 1)
+	assert.NoError(t, err)
+	aggs, err := partitionedAgg(batch, spec, 8, 4, 3)
+	assert.NoError(t, err)
+
+	// Every group is reduced by a single partition
+	totals := make(map[string]Agg)
+	for _, agg := range aggs {
+		key := groupKey(agg.Date, agg.Dimensions)
+		assert.NotContains(t, totals, key)
+		totals[key] = agg
+	}
+	assert.Len(t, aggs, len(expected))
+	for _, agg := range expected {
+		key := groupKey(agg.Date, agg.Dimensions)
+		assert.Equal(t, agg.NumberOfTransactions, totals[key].NumberOfTransactions, key)
+		assert.Equal(t, agg.TotalVolume, totals[key].TotalVolume, key)
+		assert.Equal(t, agg.Metrics.MaxVolume, totals[key].Metrics.MaxVolume, key)
+	}
+}
+
+func TestPartitionedReducer_Error(t *testing.T) {
+	reducer, err := NewPartitionedReducer(&DefaultAggSpec, ReduceOptions{Partitions: 2, SpillDir: t.TempDir()})
+	assert.NoError(t, err)
+	defer reducer.Close()
+
+	failed := make(chan AggResult, 1)
+	failed <- AggResult{Err: fmt.Errorf("boom")}
+	close(failed)
+	other := make(chan AggResult)
+	stopped := false
+	err = reducer.Reduce([]<-chan AggResult{failed, other}, func() {
+		stopped = true
+		close(other)
+	})
+	assert.ErrorContains(t, err, "boom")
+	assert.True(t, stopped)
+}
+
+// BenchmarkPartitionedReducer shows the scaling of the reduce stage with the number of reducers,
+// for 10K groups (hours of a week by user) merged from 32 batches mapped by 8 workers
+func BenchmarkPartitionedReducer(b *testing.B) {
+	spec := &AggSpec{Dimensions: []string{"user_id"}, Bucket: BucketHour, Currencies: []string{"usd"}, Metrics: []Metric{MetricMin, MetricMax}}
+	batch := userBatch(10_000, 10_000)
+
+	for _, partitions := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("Reducers=%d", partitions), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := partitionedAgg(batch, spec, 32, 8, partitions); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
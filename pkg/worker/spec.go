@@ -0,0 +1,216 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"hodctl/pkg/io"
+	"log"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of the output columns
+const (
+	ColumnDate                 = "Date"
+	ColumnNumberOfTransactions = "NumberOfTransactions"
+	columnTotalVolumePrefix    = "TotalVolume"
+)
+
+// DimensionDate groups the transactions by their date, it is always the first output column
+const DimensionDate = "date"
+
+// dimensions maps the name of each dimension the transactions can be grouped by, its CSV column, to its value
+var dimensions = map[string]func(io.RawTransaction) string{
+	"app":                func(t io.RawTransaction) string { return t.App },
+	"event":              func(t io.RawTransaction) string { return t.Event },
+	"project_id":         func(t io.RawTransaction) string { return t.ProjectID },
+	"source":             func(t io.RawTransaction) string { return t.Source },
+	"ident":              func(t io.RawTransaction) string { return t.Ident },
+	"user_id":            func(t io.RawTransaction) string { return t.UserID },
+	"session_id":         func(t io.RawTransaction) string { return t.SessionID },
+	"country":            func(t io.RawTransaction) string { return t.Country },
+	"device_type":        func(t io.RawTransaction) string { return t.DeviceType },
+	"device_os":          func(t io.RawTransaction) string { return t.DeviceOS },
+	"device_os_ver":      func(t io.RawTransaction) string { return t.DeviceOSVer },
+	"device_browser":     func(t io.RawTransaction) string { return t.DeviceBrowser },
+	"device_browser_ver": func(t io.RawTransaction) string { return t.DeviceBrowserVer },
+}
+
+// DefaultGroupBy groups the transactions by date and project
+var DefaultGroupBy = []string{DimensionDate, "project_id"}
+
+// AggSpec describes how the transactions are aggregated
+type AggSpec struct {
+	Dimensions        []string              // Dimensions the transactions are grouped by besides the date, in output order
+	Bucket            TimeBucket            // Period of the date the transactions are grouped by, daily when empty
+	Location          *time.Location        // Time zone of the time buckets, UTC when nil
+	Currencies        []string              // Reporting currencies of the total volumes (usd, eur), in output order
+	Metrics           []Metric              // Optional metrics, in output order
+	Filter            *Filter               // Selects the transactions to aggregate, all of them when nil
+	UnknownCurrencies UnknownCurrencyPolicy // What to do with the transactions in a currency without price, fail when empty
+	MaxPriceAge       time.Duration         // Maximum age of the prices at the end of the data window, 0 to skip the check
+	FailOnStalePrices bool                  // Fail instead of warning when the prices are older than MaxPriceAge
+}
+
+// DefaultAggSpec reports the total volume in USD by date and project
+var DefaultAggSpec = AggSpec{Dimensions: DefaultGroupBy[1:], Currencies: []string{io.DefaultCurrency}}
+
+// NewAggSpec creates the spec for the given reporting currencies, grouped by date and project
+func NewAggSpec(currencies []string) (*AggSpec, error) {
+	currencies = io.NormalizeCurrencies(currencies)
+	if len(currencies) == 0 {
+		return nil, errors.New("at least one reporting currency is required")
+	}
+	return &AggSpec{Dimensions: DefaultGroupBy[1:], Currencies: currencies}, nil
+}
+
+// SetGroupBy sets the dimensions the transactions are grouped by, e.g. date,project_id,country.
+// The date is required as the prices are daily.
+func (s *AggSpec) SetGroupBy(groupBy []string) error {
+	var dims []string
+	hasDate := false
+	seen := make(map[string]bool)
+	for _, dimension := range groupBy {
+		dimension = strings.ToLower(strings.TrimSpace(dimension))
+		if seen[dimension] {
+			return fmt.Errorf("duplicate group by dimension: %s", dimension)
+		}
+		seen[dimension] = true
+
+		if dimension == DimensionDate {
+			hasDate = true
+			continue
+		}
+		if _, exists := dimensions[dimension]; !exists {
+			return fmt.Errorf("unsupported group by dimension: %s (expected %s or %s)", dimension, DimensionDate, strings.Join(Dimensions(), ", "))
+		}
+		dims = append(dims, dimension)
+	}
+	if !hasDate {
+		return fmt.Errorf("the %s dimension is required to group by", DimensionDate)
+	}
+	s.Dimensions = dims
+	return nil
+}
+
+// Dimensions returns the sorted names of the dimensions the transactions can be grouped by besides the date
+func Dimensions() []string {
+	names := make([]string, 0, len(dimensions))
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DimensionValues returns the values of the spec's dimensions for the transaction
+func (s *AggSpec) DimensionValues(transaction io.RawTransaction) []string {
+	values := make([]string, len(s.Dimensions))
+	for i, dimension := range s.Dimensions {
+		values[i] = dimensions[dimension](transaction)
+	}
+	return values
+}
+
+// InputColumns returns the CSV columns of the transactions the aggregation reads, sorted: ts, props and nums,
+// the dimensions, the user_id and session_id of the distinct metrics, and the raw fields of the filter
+func (s *AggSpec) InputColumns() []string {
+	columns := map[string]bool{"ts": true, "props": true, "nums": true}
+	for _, dimension := range s.Dimensions {
+		columns[dimension] = true
+	}
+	for _, metric := range s.Metrics {
+		switch metric {
+		case MetricDistinctUsers:
+			columns["user_id"] = true
+		case MetricDistinctSessions:
+			columns["session_id"] = true
+		}
+	}
+	if s.Filter != nil {
+		for _, column := range s.Filter.Columns() {
+			columns[column] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(columns))
+}
+
+// DimensionColumn returns the name of the output column of the dimension, e.g. DeviceType for device_type
+func DimensionColumn(dimension string) string {
+	var column strings.Builder
+	for _, word := range strings.Split(dimension, "_") {
+		if word != "" {
+			column.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return column.String()
+}
+
+// VolumeColumn returns the name of the total volume column of the reporting currency, e.g. TotalVolumeUsd
+func VolumeColumn(currency string) string {
+	return currencyColumn(columnTotalVolumePrefix, currency)
+}
+
+// currencyColumn returns the name of a column of the reporting currency, e.g. MinVolumeUsd for the MinVolume prefix
+func currencyColumn(prefix string, currency string) string {
+	if currency == "" {
+		return prefix
+	}
+	currency = strings.ToLower(currency)
+	return prefix + strings.ToUpper(currency[:1]) + currency[1:]
+}
+
+// Columns returns the names of the output columns, in the order of the Agg fields
+func (s *AggSpec) Columns() []string {
+	columns := []string{s.BucketColumn()}
+	for _, dimension := range s.Dimensions {
+		columns = append(columns, DimensionColumn(dimension))
+	}
+	columns = append(columns, ColumnNumberOfTransactions)
+	for _, currency := range s.Currencies {
+		columns = append(columns, VolumeColumn(currency))
+	}
+	for _, column := range s.MetricColumns() {
+		columns = append(columns, column.Name)
+	}
+	return columns
+}
+
+// Validate checks the price table has prices for all the reporting currencies
+func (s *AggSpec) Validate(prices *io.PriceTable) error {
+	for _, currency := range s.Currencies {
+		if _, exists := prices.Quotes[currency]; !exists {
+			return fmt.Errorf("no %s prices in the currency values, available: %s", currency, strings.Join(prices.QuoteCurrencies(), ","))
+		}
+	}
+	return nil
+}
+
+// CheckPriceAgeAt checks the prices are at most MaxPriceAge older than the end of the data window of the aggregates,
+// end being zero without data. Stale prices are logged as a warning, or returned as an error when FailOnStalePrices is set.
+func (s *AggSpec) CheckPriceAgeAt(prices *io.PriceTable, end time.Time) error {
+	if s.MaxPriceAge <= 0 || end.IsZero() {
+		return nil
+	}
+
+	asOf := prices.AsOf()
+	if asOf.IsZero() {
+		log.Printf("WARNING: the currency values do not record when they were fetched, their age cannot be checked\n")
+		return nil
+	}
+
+	age := end.Sub(asOf)
+	if age <= s.MaxPriceAge {
+		return nil
+	}
+	message := fmt.Sprintf("prices as of %s are %s older than the data window ending %s (max price age %s)",
+		asOf.UTC().Format(time.RFC3339), age.Round(time.Minute), end.Format(time.RFC3339), s.MaxPriceAge)
+	if s.FailOnStalePrices {
+		return errors.New("stale currency values: " + message)
+	}
+	log.Printf("WARNING: %s\n", message)
+	return nil
+}
@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"hodctl/pkg/io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggSpec_CheckPriceAgeAt(t *testing.T) {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"ETH": 3000})
+	prices.SnapshotTime = time.Date(2024, 4, 14, 12, 0, 0, 0, time.UTC)
+	// The data window ends on 2024-04-16 00:00 UTC, 36h after the snapshot
+	end := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)
+	spec := AggSpec{Currencies: []string{"usd"}, MaxPriceAge: 48 * time.Hour, FailOnStalePrices: true}
+	assert.NoError(t, spec.CheckPriceAgeAt(prices, end))
+
+	spec.MaxPriceAge = 24 * time.Hour
+	err := spec.CheckPriceAgeAt(prices, end)
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "stale currency values: prices as of 2024-04-14T12:00:00Z are 36h0m0s older"), err.Error())
+
+	// Stale prices are only a warning by default
+	spec.FailOnStalePrices = false
+	assert.NoError(t, spec.CheckPriceAgeAt(prices, end))
+
+	// Prices without snapshot time cannot be checked
+	spec.FailOnStalePrices = true
+	assert.NoError(t, spec.CheckPriceAgeAt(io.NewSnapshotPriceTable("usd", io.Currency2Values{}), end))
+
+	// Nor can a window without data
+	assert.NoError(t, spec.CheckPriceAgeAt(prices, time.Time{}))
+}
+
+func TestAggSpec_SetGroupBy(t *testing.T) {
+	spec, err := NewAggSpec([]string{"usd"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Date", "ProjectId", "NumberOfTransactions", "TotalVolumeUsd"}, spec.Columns())
+
+	assert.NoError(t, spec.SetGroupBy([]string{"date", "project_id", "Country", "device_type"}))
+	assert.Equal(t, []string{"project_id", "country", "device_type"}, spec.Dimensions)
+	assert.Equal(t, []string{"Date", "ProjectId", "Country", "DeviceType", "NumberOfTransactions", "TotalVolumeUsd"}, spec.Columns())
+	assert.Equal(t, []string{"4974", "DE", "desktop"}, spec.DimensionValues(io.RawTransaction{ProjectID: "4974", Country: "DE", DeviceType: "desktop", App: "seq-market"}))
+
+	// Only by date
+	assert.NoError(t, spec.SetGroupBy([]string{"date"}))
+	assert.Empty(t, spec.Dimensions)
+
+	assert.EqualError(t, spec.SetGroupBy([]string{"project_id"}), "the date dimension is required to group by")
+	assert.EqualError(t, spec.SetGroupBy([]string{"date", "country", "country"}), "duplicate group by dimension: country")
+	assert.ErrorContains(t, spec.SetGroupBy([]string{"date", "props"}), "unsupported group by dimension: props")
+}
+
+func TestAggSpec_InputColumns(t *testing.T) {
+	spec, err := NewAggSpec([]string{"usd"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nums", "project_id", "props", "ts"}, spec.InputColumns())
+
+	assert.NoError(t, spec.SetGroupBy([]string{"date", "country"}))
+	spec.Metrics = []Metric{MetricMax, MetricDistinctUsers}
+	spec.Filter, err = NewFilter(`event == "BUY_ITEMS" && country in ["DE", "FR"] && volume > 0`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"country", "event"}, spec.Filter.Columns())
+	assert.Equal(t, []string{"country", "event", "nums", "props", "ts", "user_id"}, spec.InputColumns())
+}
+
+func TestAggSpec_BucketLabel(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	assert.NoError(t, err)
+	// Sunday 2024-04-14 23:30 UTC is already Monday 2024-04-15 in Berlin
+	ts := time.Date(2024, 4, 14, 23, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		bucket   TimeBucket
+		location *time.Location
+		label    string
+		column   string
+	}{
+		{BucketDay, nil, "2024-04-14", "Date"},
+		{BucketDay, berlin, "2024-04-15", "Date"},
+		{BucketHour, nil, "2024-04-14T23:00:00Z", "Hour"},
+		{BucketHour, berlin, "2024-04-15T01:00:00+02:00", "Hour"},
+		{BucketWeek, nil, "2024-04-08", "Week"},
+		{BucketWeek, berlin, "2024-04-15", "Week"},
+		{BucketMonth, nil, "2024-04-01", "Month"},
+	}
+	for _, test := range tests {
+		spec := AggSpec{Bucket: test.bucket, Location: test.location}
+		label := spec.BucketLabel(ts)
+		assert.Equal(t, test.label, label, "%s in %v", test.bucket, test.location)
+		assert.Equal(t, test.column, spec.BucketColumn())
+
+		// The label is parsed back to the start of the bucket, which contains ts
+		start, err := spec.ParseBucket(label)
+		assert.NoError(t, err)
+		assert.False(t, start.After(ts))
+		assert.True(t, spec.BucketEnd(start).After(ts))
+	}
+
+	_, err = ParseTimeBucket("year")
+	assert.EqualError(t, err, `unsupported time bucket "year", expected hour, day, week or month`)
+}
@@ -0,0 +1,455 @@
+package worker
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	stdio "io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMemoryBudget is the default estimated memory of the groups kept by the reducer before they are spilled to disk
+const DefaultMemoryBudget = 256 << 20 // 256MB
+
+// DefaultMergeFanIn is the default maximum number of runs merged at once
+const DefaultMergeFanIn = 64
+
+// aggOverhead is the estimated memory of an Agg and its map entry, besides its strings, volumes and metrics
+const aggOverhead = 160
+
+// ReduceOptions bounds the memory of the reduce step and sets its parallelism
+type ReduceOptions struct {
+	MemoryBudget int64  // Estimated memory of the groups before they are spilled to disk, in bytes, DefaultMemoryBudget when 0
+	SpillDir     string // Directory of the spilled runs, the system temp directory when empty
+	Partitions   int    // Number of reducers, each owning a disjoint slice of the group keys, 1 when 0
+	MergeFanIn   int    // Maximum number of runs merged at once, DefaultMergeFanIn when 0
+}
+
+// SpillReducer combines the partial aggregates of the workers by group key (reduce step), with bounded memory whatever
+// the number of groups: once the estimated memory of the groups passes the budget, they are sorted by group key and
+// spilled as a run to a temp file. Emit merges the runs in group key order, so only one aggregate per run is kept in
+// memory, and at most MergeFanIn runs are open at once.
+type SpillReducer struct {
+	spec      *AggSpec
+	budget    int64
+	fanIn     int            // Maximum number of runs merged at once
+	dir       string         // Temp directory of the runs
+	groups    map[string]Agg // Groups combined since the last spill
+	size      int64          // Estimated memory of the groups
+	runs      []string       // Paths of the spilled runs, in spill order
+	saved     int            // Number of runs in the last checkpoint, the runs after them were spilled since
+	spills    int            // Number of runs spilled
+	created   int            // Number of runs written to the directory, spilled or merged
+	windowEnd time.Time      // End of the latest time bucket
+}
+
+// NewSpillReducer creates a reducer of the aggregates of the spec, its runs are written to a new temp directory removed by Close
+func NewSpillReducer(spec *AggSpec, options ReduceOptions) (*SpillReducer, error) {
+	budget := options.MemoryBudget
+	if budget <= 0 {
+		budget = DefaultMemoryBudget
+	}
+	fanIn := options.MergeFanIn
+	if fanIn <= 0 {
+		fanIn = DefaultMergeFanIn
+	}
+	fanIn = max(fanIn, 2)
+	dir, err := os.MkdirTemp(options.SpillDir, "hodctl-reduce-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	return &SpillReducer{spec: spec, budget: budget, fanIn: fanIn, dir: dir, groups: make(map[string]Agg)}, nil
+}
+
+// Reduce combines all the Agg from the workers, spilling the groups to disk when they pass the memory budget
+func (r *SpillReducer) Reduce(in <-chan AggResult) error {
+	for result := range in {
+		if result.Err != nil {
+			return fmt.Errorf("unable to aggregate transactions: %v", result.Err)
+		}
+
+		for _, agg := range result.Agg {
+			if err := r.add(agg); err != nil {
+				return err
+			}
+		}
+		if r.size > r.budget {
+			if err := r.spill(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// add accumulates the aggregate into its group
+func (r *SpillReducer) add(agg Agg) error {
+	key := groupKey(agg.Date, agg.Dimensions)
+	existingAgg, exists := r.groups[key]
+	if exists {
+		if err := existingAgg.merge(agg); err != nil {
+			return err
+		}
+		r.groups[key] = existingAgg
+		return nil
+	}
+
+	start, err := r.spec.ParseBucket(agg.Date)
+	if err != nil {
+		return fmt.Errorf("invalid aggregate date %s: %w", agg.Date, err)
+	}
+	if end := r.spec.BucketEnd(start); end.After(r.windowEnd) {
+		r.windowEnd = end
+	}
+	r.groups[key] = agg
+	r.size += aggSizeEstimate(key, agg)
+	return nil
+}
+
+// aggSizeEstimate returns the estimated memory of the group in bytes
+func aggSizeEstimate(key string, agg Agg) int64 {
+	size := int64(aggOverhead + len(key) + len(agg.Date) + 8*len(agg.TotalVolume))
+	for _, value := range agg.Dimensions {
+		size += int64(16 + len(value))
+	}
+	if agg.Metrics != nil {
+		size += agg.Metrics.sizeEstimate()
+	}
+	return size
+}
+
+// sortedKeys returns the keys of the groups in memory, sorted
+func (r *SpillReducer) sortedKeys() []string {
+	keys := make([]string, 0, len(r.groups))
+	for key := range r.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// spill writes the groups in memory, sorted by key, to a new run and releases them
+func (r *SpillReducer) spill() error {
+	path := r.newRunPath("run")
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create spill run: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(writer)
+	for _, key := range r.sortedKeys() {
+		agg := r.groups[key]
+		if err := encoder.Encode(&agg); err != nil {
+			return fmt.Errorf("failed to spill aggregate %s: %w", key, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write spill run: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write spill run: %w", err)
+	}
+
+	r.runs = append(r.runs, path)
+	r.spills++
+	r.groups = make(map[string]Agg)
+	r.size = 0
+	return nil
+}
+
+// newRunPath returns the path of a new run of the directory, unique whatever the runs merged since
+func (r *SpillReducer) newRunPath(prefix string) string {
+	r.created++
+	return filepath.Join(r.dir, fmt.Sprintf("%s-%05d.gob", prefix, r.created))
+}
+
+// WindowEnd returns the end of the latest time bucket of the aggregates, zero without aggregate
+func (r *SpillReducer) WindowEnd() time.Time {
+	return r.windowEnd
+}
+
+// Spills returns the number of runs spilled to disk
+func (r *SpillReducer) Spills() int {
+	return r.spills
+}
+
+// Checkpoint spills the groups in memory, so every group is in the runs, and returns the paths of the runs.
+// The runs spilled since the last checkpoint are compacted into one, so a checkpoint adds at most one run to save.
+func (r *SpillReducer) Checkpoint() ([]string, error) {
+	if len(r.groups) > 0 {
+		if err := r.spill(); err != nil {
+			return nil, err
+		}
+	}
+	if spilled := r.runs[r.saved:]; len(spilled) > 1 {
+		path, err := r.mergeToRun(spilled, r.newRunPath("run"))
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range spilled {
+			_ = os.Remove(run)
+		}
+		r.runs = append(r.runs[:r.saved], path)
+	}
+	r.saved = len(r.runs)
+	return slices.Clone(r.runs), nil
+}
+
+// Restore adds the runs saved by a checkpoint and the end of their latest time bucket.
+// The runs are merged by Emit like the spilled ones, but are not removed by Close.
+func (r *SpillReducer) Restore(runs []string, windowEnd time.Time) {
+	r.runs = append(r.runs, runs...)
+	r.saved = len(r.runs)
+	if windowEnd.After(r.windowEnd) {
+		r.windowEnd = windowEnd
+	}
+}
+
+// Emit sends the combined aggregates, sorted by group key, to emit in batches of at most batchSize,
+// and returns the number of aggregates.
+func (r *SpillReducer) Emit(batchSize int, emit func([]Agg) error) (int, error) {
+	if batchSize <= 0 {
+		return 0, errors.New("the batch size must be positive")
+	}
+
+	if len(r.runs) == 0 {
+		return r.emitGroups(batchSize, emit)
+	}
+
+	// Every group is merged from disk, the last groups in memory are spilled as the last run
+	if len(r.groups) > 0 {
+		if err := r.spill(); err != nil {
+			return 0, err
+		}
+	}
+	log.Printf("Merging %d runs of aggregates spilled to %s\n", len(r.runs), r.dir)
+	return r.mergeRuns(batchSize, emit)
+}
+
+// emitGroups emits the groups in memory when nothing was spilled
+func (r *SpillReducer) emitGroups(batchSize int, emit func([]Agg) error) (int, error) {
+	batch := make([]Agg, 0, batchSize)
+	count := 0
+	for _, key := range r.sortedKeys() {
+		batch = append(batch, r.groups[key])
+		count++
+		if len(batch) == batchSize {
+			if err := emit(batch); err != nil {
+				return count, err
+			}
+			batch = make([]Agg, 0, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		if err := emit(batch); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// mergeRuns merges the sorted runs and emits their aggregates in batches. At most fanIn runs are open at once:
+// beyond it, the runs are first merged in passes into intermediate runs, fanIn at a time.
+func (r *SpillReducer) mergeRuns(batchSize int, emit func([]Agg) error) (int, error) {
+	runs := r.runs
+	for len(runs) > r.fanIn {
+		var merged []string
+		for i := 0; i < len(runs); i += r.fanIn {
+			group := runs[i:min(i+r.fanIn, len(runs))]
+			if len(group) == 1 {
+				merged = append(merged, group[0])
+				continue
+			}
+			path, err := r.mergeToRun(group, r.newRunPath("merge"))
+			if err != nil {
+				return 0, err
+			}
+			merged = append(merged, path)
+		}
+		log.Printf("Merged %d runs of aggregates into %d runs\n", len(runs), len(merged))
+		r.removeIntermediate(runs)
+		runs = merged
+	}
+	defer r.removeIntermediate(runs)
+
+	batch := make([]Agg, 0, batchSize)
+	count := 0
+	err := mergeSorted(runs, func(agg Agg) error {
+		batch = append(batch, agg)
+		count++
+		if len(batch) < batchSize {
+			return nil
+		}
+		err := emit(batch)
+		batch = make([]Agg, 0, batchSize)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	if len(batch) > 0 {
+		if err := emit(batch); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// mergeToRun merges the sorted runs into a new run at path, and returns its path
+func (r *SpillReducer) mergeToRun(runs []string, path string) (string, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create merged run: %w", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := gob.NewEncoder(writer)
+	err = mergeSorted(runs, func(agg Agg) error {
+		if err := encoder.Encode(&agg); err != nil {
+			return fmt.Errorf("failed to write merged run: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := writer.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write merged run: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write merged run: %w", err)
+	}
+	return path, nil
+}
+
+// removeIntermediate removes the intermediate runs of a merge, the spilled and restored runs are kept
+func (r *SpillReducer) removeIntermediate(runs []string) {
+	for _, run := range runs {
+		if strings.HasPrefix(filepath.Base(run), "merge-") && filepath.Dir(run) == r.dir {
+			_ = os.Remove(run)
+		}
+	}
+}
+
+// mergeSorted merges the sorted runs in group key order, and calls fn with each group,
+// combining the aggregates of the same group found in several runs
+func mergeSorted(runs []string, fn func(Agg) error) error {
+	readers := make(runHeap, 0, len(runs))
+	defer func() {
+		for _, reader := range readers {
+			reader.file.Close()
+		}
+	}()
+	for _, path := range runs {
+		reader, err := openRun(path)
+		if err != nil {
+			return err
+		}
+		ok, err := reader.advance()
+		if err != nil {
+			reader.file.Close()
+			return err
+		}
+		if !ok {
+			reader.file.Close()
+			continue
+		}
+		readers = append(readers, reader)
+	}
+	heap.Init(&readers)
+
+	var current Agg
+	var currentKey string
+	hasCurrent := false
+	for readers.Len() > 0 {
+		reader := readers[0]
+		agg, key := reader.next, reader.key
+		ok, err := reader.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&readers, 0)
+		} else {
+			reader.file.Close()
+			heap.Pop(&readers)
+		}
+
+		if hasCurrent && key == currentKey {
+			if err := current.merge(agg); err != nil {
+				return err
+			}
+			continue
+		}
+		if hasCurrent {
+			if err := fn(current); err != nil {
+				return err
+			}
+		}
+		current, currentKey, hasCurrent = agg, key, true
+	}
+	if hasCurrent {
+		return fn(current)
+	}
+	return nil
+}
+
+// Close removes the spilled runs
+func (r *SpillReducer) Close() error {
+	r.groups = nil
+	return os.RemoveAll(r.dir)
+}
+
+// runReader reads the aggregates of a spilled run in group key order
+type runReader struct {
+	file    *os.File
+	decoder *gob.Decoder
+	next    Agg    // Next aggregate of the run
+	key     string // Group key of next
+}
+
+func openRun(path string) (*runReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spill run: %w", err)
+	}
+	return &runReader{file: file, decoder: gob.NewDecoder(bufio.NewReader(file))}, nil
+}
+
+// advance reads the next aggregate of the run, false at the end of the run
+func (r *runReader) advance() (bool, error) {
+	var agg Agg
+	if err := r.decoder.Decode(&agg); err != nil {
+		if errors.Is(err, stdio.EOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to read spill run %s: %w", r.file.Name(), err)
+	}
+	r.next, r.key = agg, groupKey(agg.Date, agg.Dimensions)
+	return true, nil
+}
+
+// runHeap orders the run readers by the group key of their next aggregate
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h runHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	reader := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return reader
+}
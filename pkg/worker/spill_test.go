@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"hodctl/pkg/io"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// partialAggs maps 4 batches of transactions of 50 users over 3 days, every group being found in several batches
+func partialAggs(t *testing.T, spec *AggSpec) []AggResult {
+	prices := io.NewSnapshotPriceTable("usd", io.Currency2Values{"SFL": 2})
+	var results []AggResult
+	for b := 0; b < 4; b++ {
+		var batch MicroBatch
+		for i := 0; i < 150; i++ {
+			batch.Data = append(batch.Data, Transaction{
+				Timestamp:      time.Date(2023, 10, 1+i%3, 12, 0, 0, 0, time.UTC),
+				Dimensions:     []string{fmt.Sprintf("user-%02d", (i+b)%50)},
+				UserID:         fmt.Sprintf("user-%02d", (i+b)%50),
+				SessionID:      fmt.Sprintf("session-%d", i),
+				CurrencySymbol: "SFL",
+				Volume:         float64(i + b),
+			})
+		}
+		aggs, err := DoAgg(batch, prices, spec)
+		assert.NoError(t, err)
+		results = append(results, AggResult{Agg: aggs})
+	}
+	return results
+}
+
+func reduce(t *testing.T, spec *AggSpec, options ReduceOptions, results []AggResult) (*SpillReducer, []Agg) {
+	in := make(chan AggResult, len(results))
+	for _, result := range results {
+		in <- result
+	}
+	close(in)
+
+	reducer, err := NewSpillReducer(spec, options)
+	assert.NoError(t, err)
+	assert.NoError(t, reducer.Reduce(in))
+
+	var aggs []Agg
+	count, err := reducer.Emit(7, func(batch []Agg) error {
+		assert.LessOrEqual(t, len(batch), 7)
+		aggs = append(aggs, batch...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(aggs), count)
+	return reducer, aggs
+}
+
+func TestSpillReducer(t *testing.T) {
+	spec, err := NewAggSpec([]string{"usd"})
+	assert.NoError(t, err)
+	spec.Dimensions = []string{"user_id"}
+	spec.Metrics = Metrics
+
+	// The partial aggregates are merged in place, so each reducer gets its own
+	inMemory, expected := reduce(t, spec, ReduceOptions{}, partialAggs(t, spec))
+	assert.Equal(t, 0, inMemory.Spills())
+	assert.NoError(t, inMemory.Close())
+
+	// Spilled after every partial result with a 1 byte budget
+	dir := t.TempDir()
+	spilled, aggs := reduce(t, spec, ReduceOptions{MemoryBudget: 1, SpillDir: dir}, partialAggs(t, spec))
+	assert.Equal(t, 4, spilled.Spills())
+	assert.Equal(t, time.Date(2023, 10, 4, 0, 0, 0, 0, time.UTC), spilled.WindowEnd())
+
+	assert.Len(t, aggs, 150)
+	assert.Equal(t, len(expected), len(aggs))
+	columns := spec.MetricColumns()
+	for i := range expected {
+		assert.Equal(t, groupKey(expected[i].Date, expected[i].Dimensions), groupKey(aggs[i].Date, aggs[i].Dimensions))
+		assert.Equal(t, expected[i].NumberOfTransactions, aggs[i].NumberOfTransactions)
+		assert.Equal(t, expected[i].TotalVolume, aggs[i].TotalVolume)
+		for _, column := range columns {
+			assert.Equal(t, column.Value(expected[i]), column.Value(aggs[i]), column.Name)
+		}
+	}
+	if t.Failed() {
+		return
+	}
+	assert.Less(t, groupKey(aggs[0].Date, aggs[0].Dimensions), groupKey(aggs[1].Date, aggs[1].Dimensions))
+
+	// The runs are removed on close
+	assert.NoError(t, spilled.Close())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestSpillReducer_FanIn(t *testing.T) {
+	spec, err := NewAggSpec([]string{"usd"})
+	assert.NoError(t, err)
+	spec.Dimensions = []string{"user_id"}
+	spec.Metrics = Metrics
+
+	inMemory, expected := reduce(t, spec, ReduceOptions{}, partialAggs(t, spec))
+	assert.NoError(t, inMemory.Close())
+
+	// The 4 runs are more than the fan-in, so 3 of them are first merged into an intermediate run
+	dir := t.TempDir()
+	spilled, aggs := reduce(t, spec, ReduceOptions{MemoryBudget: 1, SpillDir: dir, MergeFanIn: 3}, partialAggs(t, spec))
+	assert.Equal(t, 4, spilled.Spills())
+	assert.Equal(t, len(expected), len(aggs))
+	columns := spec.MetricColumns()
+	for i := range expected {
+		assert.Equal(t, groupKey(expected[i].Date, expected[i].Dimensions), groupKey(aggs[i].Date, aggs[i].Dimensions))
+		assert.Equal(t, expected[i].NumberOfTransactions, aggs[i].NumberOfTransactions)
+		assert.Equal(t, expected[i].TotalVolume, aggs[i].TotalVolume)
+		for _, column := range columns {
+			assert.Equal(t, column.Value(expected[i]), column.Value(aggs[i]), column.Name)
+		}
+	}
+
+	// The intermediate runs are removed once merged
+	runs, err := filepath.Glob(filepath.Join(dir, "*", "*.gob"))
+	assert.NoError(t, err)
+	assert.Len(t, runs, 4)
+	for _, run := range runs {
+		assert.True(t, strings.HasPrefix(filepath.Base(run), "run-"), run)
+	}
+	assert.NoError(t, spilled.Close())
+}
+
+func TestSpillReducer_Checkpoint(t *testing.T) {
+	spec, err := NewAggSpec([]string{"usd"})
+	assert.NoError(t, err)
+	spec.Dimensions = []string{"user_id"}
+	spec.Metrics = Metrics
+
+	inMemory, expected := reduce(t, spec, ReduceOptions{}, partialAggs(t, spec))
+	assert.NoError(t, inMemory.Close())
+
+	reducer, err := NewSpillReducer(spec, ReduceOptions{MemoryBudget: 1, SpillDir: t.TempDir()})
+	assert.NoError(t, err)
+	defer reducer.Close()
+
+	// The 2 runs spilled between two checkpoints are compacted into one
+	results := partialAggs(t, spec)
+	runs := []string{}
+	for i := 0; i < len(results); i += 2 {
+		in := make(chan AggResult, 2)
+		in <- results[i]
+		in <- results[i+1]
+		close(in)
+		assert.NoError(t, reducer.Reduce(in))
+
+		checkpointRuns, err := reducer.Checkpoint()
+		assert.NoError(t, err)
+		assert.Equal(t, runs, checkpointRuns[:len(runs)])
+		assert.Len(t, checkpointRuns, len(runs)+1)
+		runs = checkpointRuns
+	}
+	assert.Equal(t, 4, reducer.Spills())
+	entries, err := os.ReadDir(filepath.Dir(runs[0]))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	var aggs []Agg
+	_, err = reducer.Emit(7, func(batch []Agg) error {
+		aggs = append(aggs, batch...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(expected), len(aggs))
+	for i := range expected {
+		assert.Equal(t, groupKey(expected[i].Date, expected[i].Dimensions), groupKey(aggs[i].Date, aggs[i].Dimensions))
+		assert.Equal(t, expected[i].NumberOfTransactions, aggs[i].NumberOfTransactions)
+		assert.Equal(t, expected[i].TotalVolume, aggs[i].TotalVolume)
+	}
+}
+
+func TestSpillReducer_Error(t *testing.T) {
+	in := make(chan AggResult, 1)
+	in <- AggResult{Err: fmt.Errorf("boom")}
+	close(in)
+
+	reducer, err := NewSpillReducer(&DefaultAggSpec, ReduceOptions{SpillDir: t.TempDir()})
+	assert.NoError(t, err)
+	defer reducer.Close()
+	assert.ErrorContains(t, reducer.Reduce(in), "unable to aggregate transactions: boom")
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// RunStats counts the transactions of an aggregation run, in total and by file, it is safe for concurrent use
+type RunStats struct {
+	Read       atomic.Int64 // Transactions read from the input
+	Outliers   atomic.Int64 // Invalid or outlier transactions sent to the error output
+	Filtered   atomic.Int64 // Valid transactions excluded by the filter
+	Skipped    atomic.Int64 // Transactions skipped for their unknown currency
+	Aggregated atomic.Int64 // Transactions aggregated
+
+	mu    sync.Mutex
+	files map[string]*RunCounts // Counts of each file of the input, when read from files
+}
+
+// AddBatch counts a raw batch of n transactions and its cleaned batch, the other transactions are outliers
+func (s *RunStats) AddBatch(n int, cleaned MicroBatch) {
+	counts := RunCounts{
+		Read:       int64(n),
+		Filtered:   int64(cleaned.Filtered),
+		Skipped:    int64(cleaned.Skipped),
+		Aggregated: int64(len(cleaned.Data)),
+		Outliers:   int64(n - cleaned.Filtered - cleaned.Skipped - len(cleaned.Data)),
+	}
+	s.Read.Add(counts.Read)
+	s.Filtered.Add(counts.Filtered)
+	s.Skipped.Add(counts.Skipped)
+	s.Aggregated.Add(counts.Aggregated)
+	s.Outliers.Add(counts.Outliers)
+
+	if cleaned.File == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string]*RunCounts)
+	}
+	file, ok := s.files[cleaned.File]
+	if !ok {
+		file = &RunCounts{}
+		s.files[cleaned.File] = file
+	}
+	file.add(counts)
+}
+
+func (s *RunStats) String() string {
+	return s.Counts().String()
+}
+
+// RunCounts is a snapshot of the RunStats, saved by the checkpoints of the aggregation
+type RunCounts struct {
+	Read       int64 `json:"read"`
+	Outliers   int64 `json:"outliers"`
+	Filtered   int64 `json:"filtered"`
+	Skipped    int64 `json:"skipped"`
+	Aggregated int64 `json:"aggregated"`
+}
+
+func (c *RunCounts) add(counts RunCounts) {
+	c.Read += counts.Read
+	c.Outliers += counts.Outliers
+	c.Filtered += counts.Filtered
+	c.Skipped += counts.Skipped
+	c.Aggregated += counts.Aggregated
+}
+
+func (c RunCounts) String() string {
+	return fmt.Sprintf("%d transactions read, %d aggregated, %d outliers, %d filtered out, %d skipped",
+		c.Read, c.Aggregated, c.Outliers, c.Filtered, c.Skipped)
+}
+
+// Counts returns a snapshot of the counts
+func (s *RunStats) Counts() RunCounts {
+	return RunCounts{Read: s.Read.Load(), Outliers: s.Outliers.Load(), Filtered: s.Filtered.Load(), Skipped: s.Skipped.Load(), Aggregated: s.Aggregated.Load()}
+}
+
+// FileCounts returns a snapshot of the counts of each file, by path
+func (s *RunStats) FileCounts() map[string]RunCounts {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make(map[string]RunCounts, len(s.files))
+	for path, counts := range s.files {
+		files[path] = *counts
+	}
+	return files
+}
+
+// Restore sets the counts of a snapshot and the counts of its files, when an aggregation is resumed
+func (s *RunStats) Restore(counts RunCounts, files map[string]RunCounts) {
+	s.Read.Store(counts.Read)
+	s.Outliers.Store(counts.Outliers)
+	s.Filtered.Store(counts.Filtered)
+	s.Skipped.Store(counts.Skipped)
+	s.Aggregated.Store(counts.Aggregated)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = make(map[string]*RunCounts, len(files))
+	for path, file := range files {
+		s.files[path] = &file
+	}
+}